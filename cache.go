@@ -1,81 +1,256 @@
 package dns
 
 import (
+	"container/list"
 	"context"
+	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/jjeffcaii/dns/edns"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 // Cache is a DNS query cache handler.
 type Cache struct {
+	// MaxEntries is the maximum number of cached questions. If zero, the
+	// number is unlimited. The least recently used questions are evicted
+	// first.
+	MaxEntries int
+
+	// Upstream, if non-nil, sends the upstream queries of the Cache, such as
+	// a Client with Servers. A query shared by coalesced queries is then
+	// independent of the query that started it, and outlives it. If nil,
+	// the query is forwarded with the Recur method of the MessageWriter of
+	// the query that started it, and is canceled with that query.
+	Upstream RoundTripper
+
 	mu    sync.RWMutex
-	cache map[Question]*Message
+	cache map[string]*cacheEntry // by question key
+	lru   list.List              // of *cacheEntry, most recently used first
+
+	group singleflight.Group
+}
+
+type cacheEntry struct {
+	key  string
+	msg  *Message
+	elem *list.Element
 }
 
 // ServeDNS answers query questions from a local cache, and forwards unanswered
-// questions upstream, then caches the answers from the response.
+// questions upstream, then caches the answers from the response. Concurrent
+// queries for the same questions share a single upstream query. With an
+// Upstream, the shared query is not canceled with the query that started it,
+// but is bound to its deadline, and each query waits for it until its own
+// context is done.
+//
+// Questions are cached apart for queries with different CacheKey flags, so
+// that DNSSEC records or client subnet answers are only served to matching
+// queries.
+//
+// Name Error (NXDOMAIN) and NODATA responses are cached for the negative
+// caching TTL of the SOA record in the authority section (RFC 2308, section
+// 5).
 func (c *Cache) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	c.serve(ctx, w, r, c.Upstream)
+}
+
+// serve answers the query r as ServeDNS, with upstream queries sent by
+// upstream if non-nil. It returns the error of the upstream query.
+func (c *Cache) serve(ctx context.Context, w MessageWriter, r *Query, upstream RoundTripper) error {
 	var (
 		miss bool
 
+		hits, expired []*cacheEntry
+
 		now = time.Now()
 	)
 
+	flags := cacheFlags(r.Message)
+
 	c.mu.RLock()
 	for _, q := range r.Questions {
-		if hit := c.lookup(q, w, now); !hit {
+		e, ok := c.lookup(questionKey(q)+flags, w, now)
+		switch {
+		case ok:
+			hits = append(hits, e)
+		case e != nil:
+			expired = append(expired, e)
+			miss = true
+		default:
 			miss = true
 		}
 	}
 	c.mu.RUnlock()
 
+	c.touch(hits, expired)
+
 	if !miss {
-		return
+		return nil
 	}
 
-	msg, err := w.Recur(ctx)
-	if err != nil || msg == nil {
-		w.Status(ServFail)
-		return
+	key := CacheKey(r.Message)
+
+	var res singleflight.Result
+	if upstream == nil {
+		// the writer is only used until the query that started the
+		// shared query returns
+		val, err, _ := c.group.Do(key, func() (interface{}, error) {
+			return c.recur(r.Message, now, func() (*Message, error) { return w.Recur(ctx) })
+		})
+		res = singleflight.Result{Val: val, Err: err}
+	} else {
+		query := r.withMessage(request(r.Message))
+
+		resc := c.group.DoChan(key, func() (interface{}, error) {
+			ctx, cancel := recurContext(ctx)
+			defer cancel()
+
+			return c.recur(r.Message, now, func() (*Message, error) { return upstream.Do(ctx, query) })
+		})
+
+		select {
+		case res = <-resc:
+		case <-ctx.Done():
+			w.Status(ServFail)
+			return ctx.Err()
+		}
 	}
-	if msg.RCode == NoError {
-		c.insert(msg, now)
+
+	msg, _ := res.Val.(*Message)
+	if res.Err != nil || msg == nil {
+		w.Status(ServFail)
+		return res.Err
 	}
 	writeMessage(w, msg)
+	return nil
+}
+
+// recur sends the upstream query for the query message query with do, and
+// caches the response.
+func (c *Cache) recur(query *Message, now time.Time, do func() (*Message, error)) (interface{}, error) {
+	msg, err := do()
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	c.insert(query, msg, now)
+	return msg, nil
+}
+
+// recurContext returns the context of an upstream query shared by the queries
+// coalesced with the query of ctx. The deadline of ctx is kept, so that the
+// upstream query is bounded.
+func recurContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detachedContext{ctx}, deadline)
+	}
+	return context.WithCancel(detachedContext{ctx})
+}
+
+// Prime sends the queries concurrently with rt, and caches the answers of the
+// responses. The first error is returned after all queries are done.
+func (c *Cache) Prime(ctx context.Context, rt RoundTripper, queries []*Query) error {
+	var g errgroup.Group
+	for _, query := range queries {
+		query := query
+
+		g.Go(func() error {
+			now := time.Now()
+
+			msg, err := rt.Do(ctx, query)
+			if err != nil {
+				return err
+			}
+			c.insert(query.Message, msg, now)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// CacheKey returns a canonical key of the query message m for caching its
+// response. The key is made of the questions, with names lowercased, the
+// Checking Disabled (CD) bit, and the EDNS DNSSEC OK (DO) bit and Client Subnet
+// option. The message ID and other EDNS options, such as cookies, are not part
+// of the key.
+func CacheKey(m *Message) string {
+	var b strings.Builder
+	for _, q := range m.Questions {
+		b.WriteString(questionKey(q))
+	}
+	b.WriteString(cacheFlags(m))
+	return b.String()
 }
 
+// questionKey returns the part of a cache key for the question q.
+func questionKey(q Question) string {
+	return fmt.Sprintf("%s/%d/%d;", strings.ToLower(q.Name), q.Type, q.Class)
+}
+
+// cacheFlags returns the part of a cache key for the flags and options of the
+// query message m.
+func cacheFlags(m *Message) string {
+	var b strings.Builder
+	if m.CheckingDisabled {
+		b.WriteString("cd;")
+	}
+	if flags, ok := m.EDNSFlags(); ok && flags&EDNSFlagDO != 0 {
+		b.WriteString("do;")
+	}
+	if _, opt := m.opt(); opt != nil {
+		for _, o := range opt.Options {
+			if o.Code == edns.OptionCodeEDNSClientSubnet {
+				fmt.Fprintf(&b, "ecs=%x;", o.Data)
+			}
+		}
+	}
+	return b.String()
+}
+
+// lookup writes the cached records for the question key to w, and reports
+// whether the question is cached. The entry of the question is returned even if
+// it has expired.
+//
 // c.mu.RLock held
-func (c *Cache) lookup(q Question, w MessageWriter, now time.Time) bool {
-	msg, ok := c.cache[q]
+func (c *Cache) lookup(key string, w MessageWriter, now time.Time) (*cacheEntry, bool) {
+	e, ok := c.cache[key]
 	if !ok {
-		return false
+		return nil, false
 	}
+	msg := e.msg
 
 	var answers, authorities, additionals []Resource
 
 	for _, res := range msg.Answers {
 		if res.TTL = cacheTTL(res.TTL, now); res.TTL <= 0 {
-			return false
+			return e, false
 		}
 
 		answers = append(answers, res)
 	}
 	for _, res := range msg.Authorities {
 		if res.TTL = cacheTTL(res.TTL, now); res.TTL <= 0 {
-			return false
+			return e, false
 		}
 
 		authorities = append(authorities, res)
 	}
 	for _, res := range msg.Additionals {
 		if res.TTL = cacheTTL(res.TTL, now); res.TTL <= 0 {
-			return false
+			return e, false
 		}
 
 		additionals = append(additionals, res)
 	}
 
+	if msg.RCode != NoError {
+		w.Status(msg.RCode)
+	}
+
 	randomize(answers)
 	for _, res := range answers {
 		w.Answer(res.Name, res.TTL, res.Record)
@@ -87,40 +262,121 @@ func (c *Cache) lookup(q Question, w MessageWriter, now time.Time) bool {
 		w.Additional(res.Name, res.TTL, res.Record)
 	}
 
-	return true
+	return e, true
+}
+
+// touch marks the hit entries as recently used when the number of entries is
+// limited, and evicts the expired entries.
+func (c *Cache) touch(hits, expired []*cacheEntry) {
+	if len(expired) == 0 && (c.MaxEntries == 0 || len(hits) == 0) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range expired {
+		if c.cache[e.key] == e {
+			c.remove(e)
+		}
+	}
+	if c.MaxEntries > 0 {
+		for _, e := range hits {
+			if c.cache[e.key] == e {
+				c.lru.MoveToFront(e.elem)
+			}
+		}
+	}
 }
 
-func (c *Cache) insert(msg *Message, now time.Time) {
-	cache := make(map[Question]*Message, len(msg.Questions))
+// insert caches the response msg to the query message query.
+func (c *Cache) insert(query, msg *Message, now time.Time) {
+	maxTTL := time.Duration(-1)
+	switch {
+	case msg.RCode == NXDomain, msg.RCode == NoError && len(msg.Answers) == 0:
+		// a NODATA response is negative too (RFC 2308, section 2.2)
+		var ok bool
+		if maxTTL, ok = negativeTTL(msg); !ok {
+			return
+		}
+	case msg.RCode != NoError:
+		return
+	}
+
+	epoch := func(ttl time.Duration) time.Duration {
+		if maxTTL >= 0 && ttl > maxTTL {
+			ttl = maxTTL
+		}
+		return cacheEpoch(ttl, now)
+	}
+
+	flags := cacheFlags(query)
+
+	entries := make([]*cacheEntry, 0, len(msg.Questions))
 	for _, q := range msg.Questions {
-		m := new(Message)
+		m := &Message{RCode: msg.RCode}
 		for _, res := range msg.Answers {
-			res.TTL = cacheEpoch(res.TTL, now)
+			res.TTL = epoch(res.TTL)
 			m.Answers = append(m.Answers, res)
 		}
 		for _, res := range msg.Authorities {
-			res.TTL = cacheEpoch(res.TTL, now)
+			res.TTL = epoch(res.TTL)
 			m.Authorities = append(m.Authorities, res)
 		}
 		for _, res := range msg.Additionals {
-			res.TTL = cacheEpoch(res.TTL, now)
+			// the OPT record is hop-by-hop, and is never cached (RFC 6891,
+			// section 6.2.1)
+			if res.Record.Type() == TypeOPT {
+				continue
+			}
+
+			res.TTL = epoch(res.TTL)
 			m.Additionals = append(m.Additionals, res)
 		}
 
-		cache[q] = m
+		entries = append(entries, &cacheEntry{key: questionKey(q) + flags, msg: m})
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.cache == nil {
-		c.cache = cache
-		return
+		c.cache = make(map[string]*cacheEntry, len(entries))
+	}
+
+	for _, e := range entries {
+		if old, ok := c.cache[e.key]; ok {
+			c.remove(old)
+		}
+
+		e.elem = c.lru.PushFront(e)
+		c.cache[e.key] = e
+	}
+
+	for c.MaxEntries > 0 && len(c.cache) > c.MaxEntries {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
 	}
+}
+
+// c.mu.Lock held
+func (c *Cache) remove(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.cache, e.key)
+}
 
-	for q, m := range cache {
-		c.cache[q] = m
+// negativeTTL returns the TTL of the negative response msg, which is the lower
+// of the TTL and the MINIMUM field of the SOA record in the authority section
+// (RFC 2308, section 5). A response without a SOA record is not cached.
+func negativeTTL(msg *Message) (time.Duration, bool) {
+	for _, res := range msg.Authorities {
+		if soa, ok := res.Record.(*SOA); ok {
+			if soa.MinTTL < res.TTL {
+				return soa.MinTTL, true
+			}
+			return res.TTL, true
+		}
 	}
+	return 0, false
 }
 
 func cacheEpoch(ttl time.Duration, now time.Time) time.Duration {
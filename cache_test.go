@@ -3,10 +3,15 @@ package dns
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/jjeffcaii/dns/edns"
+	"golang.org/x/sync/errgroup"
 )
 
 func TestCache(t *testing.T) {
@@ -31,7 +36,7 @@ func TestCache(t *testing.T) {
 		RemoteAddr: addrUDP,
 		Message: &Message{
 			Questions: []Question{
-				{Name: "test.local.", Type: TypeA},
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
 			},
 		},
 	}
@@ -84,7 +89,7 @@ func TestCacheMultiAnswer(t *testing.T) {
 		RemoteAddr: addrUDP,
 		Message: &Message{
 			Questions: []Question{
-				{Name: "test.local.", Type: TypeA},
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
 			},
 		},
 	}
@@ -142,7 +147,7 @@ func TestCacheRecurError(t *testing.T) {
 	query := &Query{
 		Message: &Message{
 			Questions: []Question{
-				{Name: "test.local.", Type: TypeA},
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
 			},
 		},
 	}
@@ -168,3 +173,451 @@ var badSend = errors.New("bad send")
 func (badConn) Send(_ *Message) error {
 	return badSend
 }
+
+func (badConn) Close() error { return nil }
+
+func TestCacheUpstreamCanceled(t *testing.T) {
+	t.Parallel()
+
+	var nquery int32
+	startc, releasec := make(chan struct{}, 1), make(chan struct{})
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&nquery, 1)
+		startc <- struct{}{}
+		<-releasec
+
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &Cache{
+		Upstream: &Client{Servers: []net.Addr{addr}},
+	}
+
+	newQuery := func() (*Query, *resolverWriter) {
+		query := &Query{
+			Message: &Message{
+				Questions: []Question{
+					{Name: "slow.local.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+		// the writers cannot forward queries, so the Upstream is used
+		return query, &resolverWriter{messageWriter: &messageWriter{msg: response(query.Message)}}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	firstq, firstw := newQuery()
+	firstc := make(chan struct{})
+	go func() {
+		cache.ServeDNS(ctx, firstw, firstq)
+		close(firstc)
+	}()
+	<-startc
+
+	secondq, secondw := newQuery()
+	secondc := make(chan struct{})
+	go func() {
+		cache.ServeDNS(context.Background(), secondw, secondq)
+		close(secondc)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the first query returns when canceled, and the shared upstream query
+	// still answers the second query
+	cancel()
+	select {
+	case <-firstc:
+		if want, got := ServFail, firstw.msg.RCode; want != got {
+			t.Errorf("want canceled query rcode %d, got %d", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("canceled query did not return")
+	}
+
+	close(releasec)
+	<-secondc
+
+	if want, got := NoError, secondw.msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if want, got := 1, len(secondw.msg.Answers); want != got {
+		t.Fatalf("want %d answer, got %d", want, got)
+	}
+	if want, got := int32(1), atomic.LoadInt32(&nquery); want != got {
+		t.Errorf("want %d upstream queries, got %d", want, got)
+	}
+}
+
+func TestCacheConcurrentLookupHost(t *testing.T) {
+	t.Parallel()
+
+	var queries int32
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&queries, 1)
+		time.Sleep(50 * time.Millisecond)
+
+		for _, q := range r.Questions {
+			switch q.Type {
+			case TypeA:
+				w.Answer(q.Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+			case TypeAAAA:
+				w.Answer(q.Name, time.Minute, &AAAA{AAAA: net.IPv6loopback})
+			}
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Resolver: new(Cache),
+		Transport: &Transport{
+			Proxy: func(_ context.Context, _ net.Addr) (net.Addr, error) {
+				return addr, nil
+			},
+		},
+	}
+
+	rlv := &net.Resolver{
+		PreferGo: true,
+		Dial:     client.Dial,
+	}
+
+	var g errgroup.Group
+	for i := 0; i < 2; i++ {
+		g.Go(func() error {
+			addrs, err := rlv.LookupHost(context.Background(), "localhost.dev.")
+			if err != nil {
+				return err
+			}
+			if want, got := 2, len(addrs); want != got {
+				return fmt.Errorf("want %d addrs, got %d", want, got)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// one upstream query for each of the A and AAAA questions
+	if want, got := int32(2), atomic.LoadInt32(&queries); want != got {
+		t.Errorf("want %d upstream queries, got %d", want, got)
+	}
+}
+
+func TestCachePrime(t *testing.T) {
+	t.Parallel()
+
+	var queries int32
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&queries, 1)
+
+		for _, q := range r.Questions {
+			w.Answer(q.Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var primes []*Query
+	for _, name := range []string{"a.local.", "b.local."} {
+		primes = append(primes, &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: name, Type: TypeA, Class: ClassIN},
+				},
+			},
+		})
+	}
+
+	cache := new(Cache)
+	if err := cache.Prime(context.Background(), new(Client), primes); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int32(2), atomic.LoadInt32(&queries); want != got {
+		t.Fatalf("want %d upstream queries, got %d", want, got)
+	}
+
+	client := &Client{Resolver: cache}
+	for _, query := range primes {
+		msg, err := client.Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := 1, len(msg.Answers); want != got {
+			t.Errorf("want %d answers, got %d", want, got)
+		}
+	}
+
+	if want, got := int32(2), atomic.LoadInt32(&queries); want != got {
+		t.Errorf("want %d upstream queries after cache hits, got %d", want, got)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	t.Parallel()
+
+	var nquery int32
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&nquery, 1)
+
+		if r.Questions[0].Name == "missing.local." {
+			w.Status(NXDomain)
+		}
+		w.Authority("local.", time.Hour, &SOA{
+			NS:     "ns.local.",
+			MBox:   "hostmaster.local.",
+			Serial: 1,
+			MinTTL: 30 * time.Second,
+		})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		rcode RCode
+	}{
+		{name: "missing.local.", rcode: NXDomain},
+		{name: "nodata.local.", rcode: NoError},
+	}
+
+	for _, test := range tests {
+		atomic.StoreInt32(&nquery, 0)
+
+		client := &Client{
+			Resolver: new(Cache),
+		}
+
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: test.name, Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		for i := 0; i < 2; i++ {
+			msg, err := client.Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("%s: want rcode %d, got %d", test.name, want, got)
+			}
+			if want, got := 1, len(msg.Authorities); want != got {
+				t.Fatalf("%s: want %d authority, got %d", test.name, want, got)
+			}
+			if ttl := msg.Authorities[0].TTL; i > 0 && ttl > 30*time.Second {
+				t.Errorf("%s: want cached SOA TTL of at most 30s, got %s", test.name, ttl)
+			}
+		}
+
+		if want, got := int32(1), atomic.LoadInt32(&nquery); want != got {
+			t.Errorf("%s: want %d upstream queries, got %d", test.name, want, got)
+		}
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	var nquery int32
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&nquery, 1)
+
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Resolver: &Cache{MaxEntries: 2},
+	}
+
+	tests := []struct {
+		name   string
+		cached bool
+	}{
+		{"a.local.", false},
+		{"b.local.", false},
+		{"a.local.", true},
+		{"c.local.", false}, // evicts b.local.
+		{"a.local.", true},
+		{"b.local.", false}, // evicts c.local.
+		{"c.local.", false},
+	}
+
+	for i, test := range tests {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: test.name, Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		before := atomic.LoadInt32(&nquery)
+		if _, err := client.Do(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.cached, atomic.LoadInt32(&nquery) == before; want != got {
+			t.Errorf("%d: want %s cached %t, got %t", i, test.name, want, got)
+		}
+	}
+}
+
+func TestCacheDNSSECOK(t *testing.T) {
+	t.Parallel()
+
+	var nquery int32
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&nquery, 1)
+
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		if flags, _ := r.EDNSFlags(); flags&EDNSFlagDO != 0 {
+			w.Answer(r.Questions[0].Name, time.Minute, &RRSIG{
+				TypeCovered: TypeA,
+				Expiration:  time.Now().Add(time.Hour),
+				Inception:   time.Now(),
+				SignerName:  "local.",
+				Signature:   []byte{1, 2, 3, 4},
+			})
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Resolver: new(Cache),
+	}
+
+	tests := []struct {
+		do bool
+
+		answers int
+		cached  bool
+	}{
+		{do: true, answers: 2},
+		{do: false, answers: 1},
+		{do: true, answers: 2, cached: true},
+		{do: false, answers: 1, cached: true},
+	}
+
+	for i, test := range tests {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "signed.local.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+		query.SetEDNS0(maxEDNSPacketLen, test.do)
+
+		before := atomic.LoadInt32(&nquery)
+		msg, err := client.Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.answers, len(msg.Answers); want != got {
+			t.Errorf("%d: want %d answers with DO %t, got %d", i, want, test.do, got)
+		}
+		if want, got := test.cached, atomic.LoadInt32(&nquery) == before; want != got {
+			t.Errorf("%d: want cached %t with DO %t, got %t", i, want, test.do, got)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	t.Parallel()
+
+	query := func(id int, name string, do bool, opts ...edns.Option) *Message {
+		msg := &Message{
+			ID: id,
+			Questions: []Question{
+				{Name: name, Type: TypeA, Class: ClassIN},
+			},
+		}
+		msg.SetEDNS0(maxEDNSPacketLen, do)
+
+		_, opt := msg.opt()
+		opt.Options = opts
+		return msg
+	}
+
+	cookie := func(b byte) edns.Option {
+		return edns.Option{Code: edns.OptionCodeCookie, Data: []byte{b, b, b, b, b, b, b, b}}
+	}
+	ecs := func(b byte) edns.Option {
+		return edns.Option{Code: edns.OptionCodeEDNSClientSubnet, Data: []byte{0x00, 0x01, 0x18, 0x00, 192, 0, b}}
+	}
+
+	tests := []struct {
+		name string
+		a, b *Message
+		same bool
+	}{
+		{
+			name: "id-and-cookie",
+			a:    query(1, "app.local.", false, cookie(1)),
+			b:    query(2, "app.local.", false, cookie(2)),
+			same: true,
+		},
+		{
+			name: "name-case",
+			a:    query(1, "app.local.", false),
+			b:    query(1, "APP.local.", false),
+			same: true,
+		},
+		{
+			name: "do-bit",
+			a:    query(1, "app.local.", false),
+			b:    query(1, "app.local.", true),
+		},
+		{
+			name: "client-subnet",
+			a:    query(1, "app.local.", false, ecs(1)),
+			b:    query(1, "app.local.", false, ecs(2)),
+		},
+		{
+			name: "question",
+			a:    query(1, "app.local.", false),
+			b:    query(1, "www.local.", false),
+		},
+	}
+
+	for _, test := range tests {
+		if want, got := test.same, CacheKey(test.a) == CacheKey(test.b); want != got {
+			t.Errorf("%s: want same key %t, got keys %q and %q", test.name, want, CacheKey(test.a), CacheKey(test.b))
+		}
+	}
+}
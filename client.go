@@ -2,8 +2,12 @@ package dns
 
 import (
 	"context"
+	cryptorand "crypto/rand"
+	"math/rand"
 	"net"
-	"sync/atomic"
+	"strings"
+	"sync"
+	"time"
 )
 
 type QueryFilter func(*Query) bool
@@ -21,9 +25,49 @@ type Client struct {
 	// Filter only process those returns true.
 	Filter QueryFilter
 
-	id uint32
+	// Servers is an ordered list of DNS server addresses. If not empty, Do
+	// sends a query to each server in order until one responds, instead of
+	// the remote address of the query. Servers that recently failed are
+	// tried last. The servers may use different networks, such as an
+	// OverHTTPSAddr followed by a UDP address to fall back to when the
+	// DNS-over-HTTPS server fails with an HTTP error status or a connection
+	// error.
+	Servers []net.Addr
+
+	// ServerCooldown is the duration a failed server is tried last. If zero,
+	// DefaultServerCooldown is used.
+	ServerCooldown time.Duration
+
+	// StrictBailiwick drops the answer and additional records of upstream
+	// responses with names that are not equal to, or a subdomain of, a
+	// question name or a CNAME target of the answers. OPT records are kept.
+	StrictBailiwick bool
+
+	// IDGenerator allocates the message ID of queries sent with an ID of
+	// zero. If nil, IDs are drawn from crypto/rand.
+	IDGenerator IDGenerator
+
+	// DNSSECOK sets the DNSSEC OK (DO) bit of the OPT record of queries sent
+	// upstream, so that servers include DNSSEC records, such as RRSIG and
+	// DNSKEY records, in responses. Queries without an OPT record are sent
+	// with one advertising a UDP payload size of 4096 bytes.
+	DNSSECOK bool
+
+	failmu   sync.Mutex
+	failures map[net.Addr]time.Time
 }
 
+// IDGenerator allocates message IDs of queries.
+type IDGenerator interface {
+	// Next returns the ID of the query message for q, the first question of
+	// the message.
+	Next(q Question) uint16
+}
+
+// DefaultServerCooldown is the default duration a failed server of a Client is
+// tried last.
+const DefaultServerCooldown = 30 * time.Second
+
 // Dial dials a DNS server and returns a net Conn that reads and writes DNS
 // messages.
 func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, error) {
@@ -72,11 +116,146 @@ func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, e
 }
 
 // Do sends a DNS query to a server and returns the response message.
+// Responses with an ID or questions that do not match the query are
+// discarded, and Do waits for the matching response until the deadline.
 func (c *Client) Do(ctx context.Context, query *Query) (*Message, error) {
-	conn, err := c.dial(ctx, query.RemoteAddr)
+	if len(c.Servers) == 0 {
+		return c.doAddr(ctx, query)
+	}
+
+	return c.eachServer(ctx, func(addr net.Addr) (*Message, error) {
+		q := *query // shallow copy
+		q.RemoteAddr = addr
+
+		return c.doAddr(ctx, &q)
+	})
+}
+
+// Exchange sends the request message req to the Servers of c in order until
+// one responds, and returns the response message. Unlike Do, req is sent
+// as is, including its ID and flags, and the response is returned without
+// modification; the Resolver and Filter of c are not used. As for Do,
+// responses that do not match the ID and questions of req are discarded.
+func (c *Client) Exchange(ctx context.Context, req *Message) (*Message, error) {
+	if len(c.Servers) == 0 {
+		return nil, errNoServers
+	}
+
+	return c.eachServer(ctx, func(addr net.Addr) (*Message, error) {
+		return c.withConn(ctx, addr, func(conn Conn) (*Message, error) {
+			if err := conn.Send(req); err != nil {
+				return nil, err
+			}
+
+			for {
+				msg := new(Message)
+				if err := conn.Recv(msg); err != nil {
+					return nil, err
+				}
+				if responseMatches(msg, req) {
+					return msg, nil
+				}
+			}
+		})
+	})
+}
+
+// Lookup sends a recursive query for the records of type qtype of name, in
+// the IN class, to the Servers of c, and returns the response message. The
+// name is made fully qualified if it is not. The query is sent as by Do,
+// with a random message ID.
+func (c *Client) Lookup(ctx context.Context, name string, qtype Type) (*Message, error) {
+	if len(c.Servers) == 0 {
+		return nil, errNoServers
+	}
+
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	query := &Query{
+		Message: &Message{
+			RecursionDesired: true,
+			Questions: []Question{
+				{Name: name, Type: qtype, Class: ClassIN},
+			},
+		},
+	}
+	return c.Do(ctx, query)
+}
+
+// eachServer calls fn with each server of c in order of preference, until a
+// call succeeds or ctx is done, and records the failed servers.
+func (c *Client) eachServer(ctx context.Context, fn func(net.Addr) (*Message, error)) (*Message, error) {
+	var err error
+	for _, addr := range c.servers(time.Now()) {
+		var msg *Message
+		if msg, err = fn(addr); err == nil {
+			c.setFailed(addr, time.Time{})
+			return msg, nil
+		}
+
+		c.setFailed(addr, time.Now())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// servers returns the servers of c ordered by preference, with the servers
+// that failed within the cooldown last.
+func (c *Client) servers(now time.Time) []net.Addr {
+	cooldown := c.ServerCooldown
+	if cooldown == 0 {
+		cooldown = DefaultServerCooldown
+	}
+
+	c.failmu.Lock()
+	defer c.failmu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(c.Servers))
+	var failed []net.Addr
+	for _, addr := range c.Servers {
+		if t, ok := c.failures[addr]; ok && now.Sub(t) < cooldown {
+			failed = append(failed, addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return append(addrs, failed...)
+}
+
+// setFailed records the time addr failed, or clears the failure for a zero t.
+func (c *Client) setFailed(addr net.Addr, t time.Time) {
+	c.failmu.Lock()
+	defer c.failmu.Unlock()
+
+	if t.IsZero() {
+		delete(c.failures, addr)
+		return
+	}
+
+	if c.failures == nil {
+		c.failures = make(map[net.Addr]time.Time)
+	}
+	c.failures[addr] = t
+}
+
+func (c *Client) doAddr(ctx context.Context, query *Query) (*Message, error) {
+	return c.withConn(ctx, query.RemoteAddr, func(conn Conn) (*Message, error) {
+		return c.do(ctx, conn, query)
+	})
+}
+
+// withConn calls fn with a connection to addr, which is bound to the deadline
+// and cancellation of ctx.
+func (c *Client) withConn(ctx context.Context, addr net.Addr, fn func(Conn) (*Message, error)) (*Message, error) {
+	conn, err := c.dial(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
 
 	if t, ok := ctx.Deadline(); ok {
 		if err := conn.SetDeadline(t); err != nil {
@@ -84,7 +263,25 @@ func (c *Client) Do(ctx context.Context, query *Query) (*Message, error) {
 		}
 	}
 
-	return c.do(ctx, conn, query)
+	// closing the conn unblocks a pending Recv when ctx is canceled
+	if done := ctx.Done(); done != nil {
+		stopc := make(chan struct{})
+		defer close(stopc)
+
+		go func() {
+			select {
+			case <-done:
+				conn.Close()
+			case <-stopc:
+			}
+		}()
+	}
+
+	msg, err := fn(conn)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return msg, err
 }
 
 func (c *Client) dial(ctx context.Context, addr net.Addr) (Conn, error) {
@@ -124,24 +321,146 @@ func (c *Client) roundtrip(conn Conn, query *Query) (*Message, error) {
 	id := query.ID
 
 	msg := *query.Message
-	msg.ID = c.nextID()
+	if msg.ID == 0 {
+		msg.ID = c.nextID(msg.Questions)
+	}
+	if c.DNSSECOK {
+		setDO(&msg)
+	}
 
 	if err := conn.Send(&msg); err != nil {
 		return nil, err
 	}
 
-	if err := conn.Recv(&msg); err != nil {
-		return nil, err
+	// responses to other queries, such as spoofed packets, are discarded
+	// until the matching response arrives or the read deadline passes
+	for sent := msg; ; {
+		msg = Message{}
+		if err := conn.Recv(&msg); err != nil {
+			return nil, err
+		}
+		if responseMatches(&msg, &sent) {
+			break
+		}
 	}
 	msg.ID = id
 
+	if c.StrictBailiwick {
+		stripOutOfBailiwick(&msg, query.Questions)
+	}
+
 	return &msg, nil
 }
 
+// responseMatches reports whether msg is the response to the query message
+// req: the IDs are equal, and the response echoes the questions of the query,
+// as described in RFC 5452, section 4. Names are compared case-insensitively.
+// The question section may be empty in an error response, such as a format
+// error.
+func responseMatches(msg, req *Message) bool {
+	if msg.ID != req.ID {
+		return false
+	}
+	if len(msg.Questions) == 0 && msg.RCode != NoError {
+		return true
+	}
+	if len(msg.Questions) != len(req.Questions) {
+		return false
+	}
+	for i, q := range msg.Questions {
+		rq := req.Questions[i]
+		if q.Type != rq.Type || q.Class != rq.Class || !strings.EqualFold(q.Name, rq.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// setDO sets the DO bit of the shallow message copy msg, without modifying the
+// additional records of the original message.
+func setDO(msg *Message) {
+	msg.Additionals = append([]Resource(nil), msg.Additionals...)
+
+	if _, opt := msg.opt(); opt == nil {
+		msg.SetEDNS0(maxEDNSPacketLen, true)
+		return
+	}
+
+	flags, _ := msg.EDNSFlags()
+	msg.SetEDNSFlags(flags | EDNSFlagDO)
+}
+
+// stripOutOfBailiwick removes the answer and additional records of msg that
+// are not in the bailiwick of a question of qs, or of a CNAME target in the
+// answers.
+func stripOutOfBailiwick(msg *Message, qs []Question) {
+	names := make([]string, 0, len(qs))
+	for _, q := range qs {
+		names = append(names, q.Name)
+	}
+
+	// follow CNAME chains in any order
+	for added := true; added; {
+		added = false
+		for _, rr := range msg.Answers {
+			cname, ok := rr.Record.(*CNAME)
+			if !ok || !inBailiwick(rr.Name, names) || inBailiwick(cname.CNAME, names) {
+				continue
+			}
+			names = append(names, cname.CNAME)
+			added = true
+		}
+	}
+
+	filter := func(rrs []Resource) []Resource {
+		kept := rrs[:0]
+		for _, rr := range rrs {
+			if rr.Record.Type() == TypeOPT || inBailiwick(rr.Name, names) {
+				kept = append(kept, rr)
+			}
+		}
+		return kept
+	}
+
+	msg.Answers = filter(msg.Answers)
+	msg.Additionals = filter(msg.Additionals)
+}
+
+// inBailiwick reports whether name is equal to, or a subdomain of, one of
+// zones. Names are compared case-insensitively.
+func inBailiwick(name string, zones []string) bool {
+	name = strings.ToLower(name)
+	for _, zone := range zones {
+		zone = strings.ToLower(zone)
+		if name == zone || zone == "." || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
 const idMask = (1 << 16) - 1
 
-func (c *Client) nextID() int {
-	return int(atomic.AddUint32(&c.id, 1) & idMask)
+func (c *Client) nextID(qs []Question) int {
+	var q Question
+	if len(qs) > 0 {
+		q = qs[0]
+	}
+
+	if c.IDGenerator != nil {
+		return int(c.IDGenerator.Next(q))
+	}
+	return randomID()
+}
+
+// randomID returns a message ID read from crypto/rand, or from math/rand if
+// crypto/rand fails.
+func randomID() int {
+	var b [2]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		return rand.Intn(idMask + 1)
+	}
+	return int(nbo.Uint16(b[:]))
 }
 
 type clientWriter struct {
@@ -182,6 +501,10 @@ func (w *clientWriter) Reply(context.Context) error {
 	return ErrUnsupportedOp
 }
 
+func (w *clientWriter) SendRaw([]byte) error {
+	return ErrUnsupportedOp
+}
+
 func request(msg *Message) *Message {
 	req := new(Message)
 	*req = *msg // shallow copy
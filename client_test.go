@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -117,7 +119,7 @@ func TestClientResolver(t *testing.T) {
 		RemoteAddr: addrUDP,
 		Message: &Message{
 			Questions: []Question{
-				{Name: "test.local.", Type: TypeA},
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
 			},
 		},
 	}
@@ -131,7 +133,7 @@ func TestClientResolver(t *testing.T) {
 		t.Errorf("want A record %q, got %q", want, got)
 	}
 
-	query.Questions[0] = Question{Name: "test.goog.", Type: TypeA}
+	query.Questions[0] = Question{Name: "test.goog.", Type: TypeA, Class: ClassIN}
 	if msg, err = client.Do(context.Background(), query); err != nil {
 		t.Fatal(err)
 	}
@@ -140,3 +142,563 @@ func TestClientResolver(t *testing.T) {
 		t.Errorf("want A record %q, got %q", want, got)
 	}
 }
+
+func TestClientServers(t *testing.T) {
+	t.Parallel()
+
+	answerA := func(ip net.IP) Handler {
+		return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: ip.To4()})
+		})
+	}
+
+	primary := mustUnusedAddr() // not yet listening
+	primaryAddr, err := net.ResolveTCPAddr("tcp", primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := mustServer(answerA(net.IPv4(10, 0, 0, 2)))
+	secondaryAddr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var primaryDials int32
+	client := &Client{
+		Transport: &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				if address == primaryAddr.String() {
+					atomic.AddInt32(&primaryDials, 1)
+				}
+				return new(net.Dialer).DialContext(ctx, network, address)
+			},
+		},
+		Servers:        []net.Addr{primaryAddr, secondaryAddr},
+		ServerCooldown: 100 * time.Millisecond,
+	}
+
+	query := &Query{
+		Message: &Message{
+			Questions: []Question{
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	lookup := func(want net.IP, wantDials int32) {
+		t.Helper()
+
+		msg, err := client.Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := msg.Answers[0].Record.(*A).A; !want.Equal(got) {
+			t.Errorf("want A record %s, got %s", want, got)
+		}
+		if got := atomic.LoadInt32(&primaryDials); wantDials != got {
+			t.Errorf("want %d primary server dials, got %d", wantDials, got)
+		}
+	}
+
+	// primary fails, and secondary answers
+	lookup(net.IPv4(10, 0, 0, 2), 1)
+
+	// primary is skipped during the cooldown
+	lookup(net.IPv4(10, 0, 0, 2), 1)
+
+	mustStart(&Server{
+		Addr:    primary,
+		Handler: answerA(net.IPv4(10, 0, 0, 1)),
+	})
+	time.Sleep(client.ServerCooldown)
+
+	// primary recovers after the cooldown
+	lookup(net.IPv4(10, 0, 0, 1), 2)
+}
+
+func TestClientDoCancel(t *testing.T) {
+	t.Parallel()
+
+	// a server that never answers
+	blackhole, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blackhole.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name string
+
+		tport *Transport
+	}{
+		{name: "dialed", tport: new(Transport)},
+		{name: "packet-conn", tport: &Transport{PacketConn: conn}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &Client{Transport: test.tport}
+
+			query := &Query{
+				RemoteAddr: blackhole.LocalAddr(),
+				Message: &Message{
+					Questions: []Question{questions["A"]},
+				},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			errc := make(chan error, 1)
+			go func() {
+				_, err := client.Do(ctx, query)
+				errc <- err
+			}()
+
+			select {
+			case err := <-errc:
+				if want, got := context.Canceled, err; want != got {
+					t.Errorf("want error %q, got %v", want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Do did not return after the context was canceled")
+			}
+
+			if pline := test.tport.ppline; pline != nil {
+				pline.mu.Lock()
+				defer pline.mu.Unlock()
+
+				if want, got := 0, len(pline.inflight); want != got {
+					t.Errorf("want %d inflight queries, got %d", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestClientStrictBailiwick(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer("app.example.net.", time.Minute, &A{A: net.IPv4(192, 0, 2, 2).To4()})
+		w.Answer("www.example.com.", time.Minute, &CNAME{CNAME: "app.example.net."})
+		w.Answer("bank.example.org.", time.Minute, &A{A: net.IPv4(192, 0, 2, 66).To4()})
+		w.Additional("ns.www.example.com.", time.Minute, &A{A: net.IPv4(192, 0, 2, 53).To4()})
+		w.Additional("ns.example.org.", time.Minute, &A{A: net.IPv4(192, 0, 2, 67).To4()})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "WWW.example.com.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	msg, err := (&Client{StrictBailiwick: true}).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, rr := range append(msg.Answers, msg.Additionals...) {
+		names = append(names, rr.Name)
+	}
+
+	want := []string{"app.example.net.", "WWW.example.com.", "ns.www.example.com."}
+	if got := names; !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %q, got %q", want, got)
+	}
+}
+
+func TestClientExchange(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &Message{
+		ID:               0xBEEF,
+		RecursionDesired: true,
+		Questions:        []Question{questions["A"]},
+	}
+
+	if _, err := new(Client).Exchange(context.Background(), req); err != errNoServers {
+		t.Errorf("want error %q without servers, got %v", errNoServers, err)
+	}
+
+	client := &Client{Servers: []net.Addr{addr}}
+
+	msg, err := client.Exchange(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Message{
+		ID:               0xBEEF,
+		Response:         true,
+		RecursionDesired: true,
+		Questions:        []Question{questions["A"]},
+		Answers: []Resource{
+			{
+				Name:   questions["A"].Name,
+				Class:  ClassIN,
+				TTL:    time.Minute,
+				Record: answers[questions["A"]],
+			},
+		},
+	}
+	if got := msg; !reflect.DeepEqual(want, got) {
+		t.Errorf("want response %+v, got %+v", want, got)
+	}
+}
+
+func TestClientLookup(t *testing.T) {
+	t.Parallel()
+
+	queryc := make(chan *Message, 1)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		queryc <- r.Message
+
+		(&answerHandler{answers}).ServeDNS(ctx, w, r)
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := new(Client).Lookup(context.Background(), "test.local", TypeA); err != errNoServers {
+		t.Errorf("want error %q without servers, got %v", errNoServers, err)
+	}
+
+	client := &Client{Servers: []net.Addr{addr}}
+
+	name := strings.TrimSuffix(questions["A"].Name, ".")
+	msg, err := client.Lookup(context.Background(), name, TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := <-queryc
+	if want, got := []Question{questions["A"]}, query.Questions; !reflect.DeepEqual(want, got) {
+		t.Errorf("want questions %+v, got %+v", want, got)
+	}
+	if !query.RecursionDesired {
+		t.Error("want RD bit set on the query")
+	}
+
+	if want, got := NoError, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if want, got := []Resource{
+		{Name: questions["A"].Name, Class: ClassIN, TTL: time.Minute, Record: answers[questions["A"]]},
+	}, msg.Answers; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answers %+v, got %+v", want, got)
+	}
+}
+
+func TestClientMismatchedResponse(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// each query is answered by spoofed responses with the wrong ID or
+	// question before the matching response
+	go func() {
+		buf := make([]byte, maxPacketLen)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var msg Message
+			if _, err := msg.Unpack(buf[:n]); err != nil {
+				continue
+			}
+
+			wrongID := response(&msg)
+			wrongID.ID ^= 0xFFFF
+			wrongID.Answers = []Resource{
+				{Name: msg.Questions[0].Name, Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(203, 0, 113, 1).To4()}},
+			}
+
+			wrongQuestion := response(&msg)
+			wrongQuestion.Questions = []Question{{Name: "evil.local.", Type: TypeA, Class: ClassIN}}
+			wrongQuestion.Answers = []Resource{
+				{Name: "evil.local.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(203, 0, 113, 2).To4()}},
+			}
+
+			res := response(&msg)
+			res.Answers = []Resource{
+				{Name: msg.Questions[0].Name, Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+			}
+
+			for _, m := range []*Message{wrongID, wrongQuestion, res} {
+				if raw, err := m.Pack(nil, true); err == nil {
+					conn.WriteTo(raw, addr)
+				}
+			}
+		}
+	}()
+
+	query := &Query{
+		RemoteAddr: conn.LocalAddr(),
+		Message: &Message{
+			Questions: []Question{
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		do   func(context.Context) (*Message, error)
+	}{
+		{
+			name: "do",
+			do: func(ctx context.Context) (*Message, error) {
+				return new(Client).Do(ctx, query)
+			},
+		},
+		{
+			name: "exchange",
+			do: func(ctx context.Context) (*Message, error) {
+				client := &Client{Servers: []net.Addr{conn.LocalAddr()}}
+				return client.Exchange(ctx, &Message{ID: 0x1234, Questions: query.Questions})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		msg, err := test.do(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if want, got := 1, len(msg.Answers); want != got {
+			t.Fatalf("%s: want %d answer, got %d", test.name, want, got)
+		}
+		if want, got := net.IPv4(192, 0, 2, 1).To4(), msg.Answers[0].Record.(*A).A; !want.Equal(got) {
+			t.Errorf("%s: want A record %q from the matching response, got %q", test.name, want, got)
+		}
+	}
+}
+
+func TestResponseMatches(t *testing.T) {
+	t.Parallel()
+
+	req := &Message{
+		ID:        0x1234,
+		Questions: []Question{{Name: "www.example.com.", Type: TypeA, Class: ClassIN}},
+	}
+
+	tests := []struct {
+		name string
+
+		msg Message
+
+		match bool
+	}{
+		{
+			name: "match",
+
+			msg: Message{ID: 0x1234, Questions: req.Questions},
+
+			match: true,
+		},
+		{
+			name: "name-case",
+
+			msg: Message{ID: 0x1234, Questions: []Question{{Name: "WWW.Example.com.", Type: TypeA, Class: ClassIN}}},
+
+			match: true,
+		},
+		{
+			name: "id",
+
+			msg: Message{ID: 0x4321, Questions: req.Questions},
+		},
+		{
+			name: "name",
+
+			msg: Message{ID: 0x1234, Questions: []Question{{Name: "example.com.", Type: TypeA, Class: ClassIN}}},
+		},
+		{
+			name: "type",
+
+			msg: Message{ID: 0x1234, Questions: []Question{{Name: "www.example.com.", Type: TypeAAAA, Class: ClassIN}}},
+		},
+		{
+			name: "no-questions",
+
+			msg: Message{ID: 0x1234},
+		},
+		{
+			name: "no-questions-format-error",
+
+			msg: Message{ID: 0x1234, RCode: FormErr},
+
+			match: true,
+		},
+	}
+
+	for _, test := range tests {
+		if want, got := test.match, responseMatches(&test.msg, req); want != got {
+			t.Errorf("%s: want match %t, got %t", test.name, want, got)
+		}
+	}
+}
+
+type sequentialIDs struct {
+	mu sync.Mutex
+	id uint16
+}
+
+func (g *sequentialIDs) Next(Question) uint16 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.id++
+	return g.id
+}
+
+func TestClientIDGenerator(t *testing.T) {
+	t.Parallel()
+
+	idc := make(chan int, 4)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		idc <- r.ID
+		w.Answer(r.Questions[0].Name, time.Minute, answers[questions["A"]])
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{IDGenerator: &sequentialIDs{id: 100}}
+
+	for _, test := range []struct {
+		id, sent int
+	}{
+		{id: 0, sent: 101},
+		{id: 0, sent: 102},
+		{id: 0xBEEF, sent: 0xBEEF},
+		{id: 0, sent: 103},
+	} {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				ID:        test.id,
+				Questions: []Question{questions["A"]},
+			},
+		}
+
+		msg, err := client.Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.sent, <-idc; want != got {
+			t.Errorf("want sent ID %d, got %d", want, got)
+		}
+		if want, got := test.id, msg.ID; want != got {
+			t.Errorf("want response ID %d, got %d", want, got)
+		}
+	}
+}
+
+func TestClientDNSSECOK(t *testing.T) {
+	t.Parallel()
+
+	type queryEDNS struct {
+		flags uint16
+		ok    bool
+		size  int
+	}
+
+	ednsc := make(chan queryEDNS, 1)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		flags, ok := r.EDNSFlags()
+		ednsc <- queryEDNS{flags, ok, r.UDPSize()}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		dnssecOK bool
+		opt      bool
+
+		do   bool
+		size int
+	}{
+		{name: "disabled", size: 512},
+		{name: "no-OPT", dnssecOK: true, do: true, size: maxEDNSPacketLen},
+		{name: "OPT", dnssecOK: true, opt: true, do: true, size: 1232},
+	}
+
+	for _, test := range tests {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{questions["A"]},
+			},
+		}
+		if test.opt {
+			query.SetEDNS0(1232, false)
+		}
+		nadditional := len(query.Additionals)
+
+		client := &Client{DNSSECOK: test.dnssecOK}
+		if _, err := client.Do(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+
+		got := <-ednsc
+		if want, got := test.do, got.ok && got.flags&EDNSFlagDO != 0; want != got {
+			t.Errorf("%s: want DO bit %t, got %t", test.name, want, got)
+		}
+		if want, got := test.size, got.size; want != got {
+			t.Errorf("%s: want UDP payload size %d, got %d", test.name, want, got)
+		}
+
+		if want, got := nadditional, len(query.Additionals); want != got {
+			t.Errorf("%s: want %d query additionals, got %d", test.name, want, got)
+		}
+		if flags, _ := query.EDNSFlags(); flags&EDNSFlagDO != 0 {
+			t.Errorf("%s: DO bit set on the caller's query", test.name)
+		}
+	}
+}
@@ -15,9 +15,53 @@ type Decompressor interface {
 	Unpack([]byte) (string, []byte, error)
 }
 
+// maxNameLen is the maximum length of an encoded domain name.
+const maxNameLen = 255
+
+// checkName validates the labels and length of the fully-qualified domain
+// name. If strict is set, labels must be made up of letters, digits, and
+// hyphens, or underscores as used by service labels (RFC 2782).
+func checkName(name string, strict bool) error {
+	if name == "." {
+		return nil
+	}
+	if !strings.HasSuffix(name, ".") {
+		return errInvalidFQDN
+	}
+	if len(name)+1 > maxNameLen {
+		return errNameTooLong
+	}
+
+	for _, label := range strings.Split(name[:len(name)-1], ".") {
+		switch {
+		case len(label) == 0:
+			return errZeroSegLen
+		case len(label) > 63:
+			return errSegTooLong
+		}
+
+		if !strict {
+			continue
+		}
+
+		for i := 0; i < len(label); i++ {
+			switch c := label[i]; {
+			case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			case c == '-' || c == '_':
+			default:
+				return errInvalidLabel
+			}
+		}
+	}
+	return nil
+}
+
 type compressor struct {
 	tbl    map[string]int
 	offset int
+
+	// max is the maximum number of names tracked in tbl; zero is unlimited.
+	max int
 }
 
 func (c compressor) Length(names ...string) (int, error) {
@@ -53,7 +97,9 @@ func (c compressor) length(name string, visited map[string]struct{}) (int, error
 			return 2, nil
 		}
 
-		visited[name] = struct{}{}
+		if c.track(len(visited)) {
+			visited[name] = struct{}{}
+		}
 	}
 
 	pvt := strings.IndexByte(name, '.')
@@ -68,6 +114,9 @@ func (c compressor) Pack(b []byte, fqdn string) ([]byte, error) {
 	if fqdn == "." || fqdn == "" {
 		return append(b, 0x00), nil
 	}
+	if len(fqdn)+1 > maxNameLen {
+		return nil, errNameTooLong
+	}
 
 	if c.tbl != nil {
 		if idx, ok := c.tbl[fqdn]; ok {
@@ -90,7 +139,7 @@ func (c compressor) Pack(b []byte, fqdn string) ([]byte, error) {
 		return nil, errSegTooLong
 	}
 
-	if c.tbl != nil {
+	if c.tbl != nil && c.track(0) {
 		idx := len(b) - c.offset
 		if int(uint16(idx)) != idx {
 			return nil, errInvalidPtr
@@ -104,6 +153,12 @@ func (c compressor) Pack(b []byte, fqdn string) ([]byte, error) {
 	return c.Pack(b, fqdn[pvt+1:])
 }
 
+// track reports whether another name may be added to the table, given n
+// names pending addition.
+func (c compressor) track(n int) bool {
+	return c.max == 0 || len(c.tbl)+n < c.max
+}
+
 type decompressor []byte
 
 func (d decompressor) Unpack(b []byte) (string, []byte, error) {
@@ -129,6 +184,9 @@ func (d decompressor) unpack(name, b []byte, visited []int) ([]byte, []byte, err
 		return nil, nil, errBaseLen
 	}
 
+	if isReserved(b[0]) {
+		return nil, nil, errReserved
+	}
 	if isPointer(b[0]) {
 		if d == nil {
 			return nil, nil, errBaseLen
@@ -151,13 +209,16 @@ func (d decompressor) unpack(name, b []byte, visited []int) ([]byte, []byte, err
 
 	name = append(name, b[:lenl]...)
 	name = append(name, '.')
+	if len(name)+1 > maxNameLen {
+		return nil, nil, errNameTooLong
+	}
 
 	return d.unpack(name, b[lenl:], visited)
 }
 
 func (d decompressor) deref(name []byte, ptr uint16, visited []int) ([]byte, error) {
 	idx := int(ptr & 0x3FFF)
-	if len(d) < idx {
+	if len(d) <= idx {
 		return nil, errInvalidPtr
 	}
 
@@ -175,7 +236,11 @@ func (d decompressor) deref(name []byte, ptr uint16, visited []int) ([]byte, err
 	return name, err
 }
 
-func isPointer(b byte) bool { return b&0xC0 > 0 }
+func isPointer(b byte) bool { return b&0xC0 == 0xC0 }
+
+// isReserved reports whether b is a label length byte with one of the
+// reserved 0x40 or 0x80 prefixes.
+func isReserved(b byte) bool { return b&0xC0 == 0x40 || b&0xC0 == 0x80 }
 
 func pointerTo(idx int) ([]byte, error) {
 	ptr := uint16(idx)
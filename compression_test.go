@@ -2,6 +2,7 @@ package dns
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -63,7 +64,7 @@ func TestCompressor(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			com := compressor{test.state, 0}
+			com := compressor{tbl: test.state}
 
 			length, err := com.Length(test.fqdn)
 			if err != nil {
@@ -134,6 +135,72 @@ func TestDecompressor(t *testing.T) {
 
 			fqdn: "example.com.",
 		},
+		{
+			name: "truncated-label",
+
+			raw: []byte{
+				0x07, 'e', 'x', 'a',
+			},
+
+			err: errCalcLen,
+		},
+		{
+			name: "missing-root-label",
+
+			raw: []byte{
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+			},
+
+			err: errBaseLen,
+		},
+		{
+			name: "label-length-at-end",
+
+			raw: []byte{
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03,
+			},
+
+			err: errBaseLen,
+		},
+		{
+			name: "pointer-past-end",
+
+			raw: []byte{
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0xC0, 0x0A,
+			},
+			state: []byte{
+				0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+				0x03, 'c', 'o', 'm',
+				0x00,
+			},
+
+			err: errInvalidPtr,
+		},
+		{
+			name: "pointer-to-truncated-name",
+
+			raw: []byte{
+				0xC0, 0x05,
+			},
+			state: []byte{
+				0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+				0x03, 'c', 'o', 'm',
+			},
+
+			err: errBaseLen,
+		},
+		{
+			name: "reserved-prefix",
+
+			raw: []byte{
+				0x40, 0x00,
+			},
+
+			err: errReserved,
+		},
 	}
 
 	t.Parallel()
@@ -147,10 +214,10 @@ func TestDecompressor(t *testing.T) {
 			dec := decompressor(test.state)
 
 			fqdn, _, err := dec.Unpack(test.raw)
+			if want, got := test.err, err; want != got {
+				t.Fatalf("want err %v, got %v", want, got)
+			}
 			if err != nil {
-				if want, got := test.err, err; want != got {
-					t.Errorf("want err %q, got %q", want, got)
-				}
 				return
 			}
 
@@ -160,3 +227,78 @@ func TestDecompressor(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		strict bool
+
+		err error
+	}{
+		{name: ".", strict: true},
+		{name: "example.com.", strict: true},
+		{name: "_sip._tcp.example.com.", strict: true},
+		{name: "example.com", err: errInvalidFQDN},
+		{name: "example..com.", err: errZeroSegLen},
+		{name: strings.Repeat("a", 64) + ".com.", err: errSegTooLong},
+		{name: strings.Repeat(strings.Repeat("a", 63)+".", 4), err: errNameTooLong},
+		{name: "a b.example.com."},
+		{name: "a b.example.com.", strict: true, err: errInvalidLabel},
+	}
+
+	for _, test := range tests {
+		if want, got := test.err, checkName(test.name, test.strict); want != got {
+			t.Errorf("%q: want err %v, got %v", test.name, want, got)
+		}
+	}
+}
+
+func TestNameLengthLimit(t *testing.T) {
+	t.Parallel()
+
+	// names of 255 and 256 bytes in wire format, with the root label
+	labels := strings.Repeat(strings.Repeat("a", 63)+".", 3)
+	tests := []struct {
+		fqdn string
+
+		err error
+	}{
+		{fqdn: labels + strings.Repeat("b", 61) + "."},
+		{fqdn: labels + strings.Repeat("b", 62) + ".", err: errNameTooLong},
+	}
+
+	for _, test := range tests {
+		n := len(test.fqdn) + 1
+
+		if want, got := test.err, checkName(test.fqdn, false); want != got {
+			t.Errorf("%d bytes: want checkName error %v, got %v", n, want, got)
+		}
+
+		raw, err := compressor{}.Pack(nil, test.fqdn)
+		if want, got := test.err, err; want != got {
+			t.Errorf("%d bytes: want Pack error %v, got %v", n, want, got)
+		}
+
+		// the wire format of the name, without the length limit of Pack
+		raw = raw[:0]
+		for _, label := range strings.SplitAfter(test.fqdn, ".") {
+			if label != "" {
+				raw = append(append(raw, byte(len(label)-1)), label[:len(label)-1]...)
+			}
+		}
+		raw = append(raw, 0x00)
+		if want, got := n, len(raw); want != got {
+			t.Fatalf("want %d wire bytes, got %d", want, got)
+		}
+
+		fqdn, _, err := decompressor(nil).Unpack(raw)
+		if want, got := test.err, err; want != got {
+			t.Errorf("%d bytes: want Unpack error %v, got %v", n, want, got)
+		}
+		if want, got := test.err == nil, fqdn == test.fqdn; want != got {
+			t.Errorf("%d bytes: want unpacked name %t, got %q", n, want, fqdn)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package dns
 
 import (
 	"io"
+	"io/ioutil"
 	"net"
 )
 
@@ -25,10 +26,12 @@ type PacketConn struct {
 	rbuf, wbuf []byte
 }
 
-// Recv reads a DNS message from the underlying connection.
+// Recv reads a DNS message from the underlying connection. Messages of up to
+// 4096 bytes are read, for responses to queries advertising a larger UDP
+// payload size with EDNS.
 func (c *PacketConn) Recv(msg *Message) error {
-	if len(c.rbuf) != maxPacketLen {
-		c.rbuf = make([]byte, maxPacketLen)
+	if len(c.rbuf) != maxEDNSPacketLen {
+		c.rbuf = make([]byte, maxEDNSPacketLen)
 	}
 
 	n, err := c.Read(c.rbuf)
@@ -36,8 +39,7 @@ func (c *PacketConn) Recv(msg *Message) error {
 		return err
 	}
 
-	_, err = msg.Unpack(c.rbuf[:n])
-	return err
+	return unpackAll(msg, c.rbuf[:n])
 }
 
 // Send writes a DNS message to the underlying connection.
@@ -87,8 +89,7 @@ func (c *StreamConn) Recv(msg *Message) error {
 		return err
 	}
 
-	_, err := msg.Unpack(c.rbuf[:mlen])
-	return err
+	return unpackAll(msg, c.rbuf[:mlen])
 }
 
 // Send writes a DNS message to the underlying connection.
@@ -97,17 +98,69 @@ func (c *StreamConn) Send(msg *Message) error {
 		c.wbuf = make([]byte, 1024)
 	}
 
-	b, err := msg.Pack(c.wbuf[2:2], true)
-	if err != nil {
+	var err error
+	if c.wbuf, err = msg.Pack(c.wbuf[:2], true); err != nil {
 		return err
 	}
 
-	mlen := uint16(len(b))
-	if int(mlen) != len(b) {
+	mlen := uint16(len(c.wbuf) - 2)
+	if int(mlen) != len(c.wbuf)-2 {
 		return ErrOversizedMessage
 	}
 	nbo.PutUint16(c.wbuf[:2], mlen)
 
-	_, err = c.Write(c.wbuf[:len(b)+2])
-	return err
+	return writeFull(c.Conn, c.wbuf)
+}
+
+// writeFull writes all of b to w, retrying short writes.
+func writeFull(w io.Writer, b []byte) error {
+	for len(b) > 0 {
+		n, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// maxMessageLen is the largest encoded DNS message, as limited by the two
+// byte length prefix of stream-oriented transports.
+const maxMessageLen = 1<<16 - 1
+
+// ReadMessage reads a whole DNS message from r until EOF, such as the body of
+// a DNS over HTTPS (RFC 8484) response delivered in several chunks, and
+// decodes it into msg. Bodies longer than maxLen bytes return an
+// ErrOversizedMessage error. If maxLen is zero, the largest DNS message
+// length (65535 bytes) is used.
+func ReadMessage(r io.Reader, msg *Message, maxLen int) error {
+	if maxLen <= 0 {
+		maxLen = maxMessageLen
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(r, int64(maxLen)+1))
+	if err != nil {
+		return err
+	}
+	if len(buf) > maxLen {
+		return ErrOversizedMessage
+	}
+
+	return unpackAll(msg, buf)
+}
+
+// unpackAll decodes msg from b, which must hold exactly the records declared
+// by the header counts.
+func unpackAll(msg *Message, b []byte) error {
+	b, err := msg.Unpack(b)
+	if err != nil {
+		return err
+	}
+	if len(b) > 0 {
+		return errExtraBytes
+	}
+	return nil
 }
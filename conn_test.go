@@ -1,7 +1,9 @@
 package dns
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"strings"
@@ -57,7 +59,17 @@ func TestPacketConnRoundTrip(t *testing.T) {
 			req: &Message{
 				Questions: []Question{
 					{
-						Name:  strings.Repeat(strings.Repeat("a", 63)+".", 10),
+						Name:  strings.Repeat(strings.Repeat("a", 63)+".", 3),
+						Type:  TypeA,
+						Class: ClassIN,
+					},
+					{
+						Name:  strings.Repeat(strings.Repeat("b", 63)+".", 3),
+						Type:  TypeA,
+						Class: ClassIN,
+					},
+					{
+						Name:  strings.Repeat(strings.Repeat("c", 63)+".", 3),
 						Type:  TypeA,
 						Class: ClassIN,
 					},
@@ -165,6 +177,119 @@ func TestStreamConnRoundTrip(t *testing.T) {
 	}
 }
 
+func TestStreamConnShortWrites(t *testing.T) {
+	t.Parallel()
+
+	req := &Message{
+		Questions: []Question{
+			{
+				Name:  "example.com.",
+				Type:  TypeTXT,
+				Class: ClassIN,
+			},
+		},
+	}
+
+	res := &Message{
+		Questions: req.Questions,
+	}
+	for i := 0; i < 64; i++ {
+		res.Answers = append(res.Answers, Resource{
+			Name:   "example.com.",
+			Class:  ClassIN,
+			TTL:    60 * time.Second,
+			Record: &TXT{TXT: []string{strings.Repeat("x", 32)}},
+		})
+	}
+
+	c1, c2 := net.Pipe()
+
+	client := &StreamConn{
+		Conn: &throttledConn{Conn: c1, n: 3},
+	}
+	server := &StreamConn{
+		Conn: &throttledConn{Conn: c2, n: 3},
+	}
+
+	if err := testRoundTrip(client, server, req, res); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// throttledConn is a net.Conn that writes at most n bytes per call to Write,
+// without returning an error for the short write.
+type throttledConn struct {
+	net.Conn
+
+	n int
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	if len(b) > c.n {
+		b = b[:c.n]
+	}
+	return c.Conn.Write(b)
+}
+
+func TestReadMessage(t *testing.T) {
+	t.Parallel()
+
+	msg := &Message{
+		ID:       0x1234,
+		Response: true,
+		Questions: []Question{
+			{Name: "example.com.", Type: TypeA, Class: ClassIN},
+		},
+		Answers: []Resource{
+			{
+				Name:   "example.com.",
+				Class:  ClassIN,
+				TTL:    60 * time.Second,
+				Record: &A{A: net.IPv4(127, 0, 0, 1).To4()},
+			},
+		},
+	}
+
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deliver the message in three chunks
+	n := len(buf) / 3
+	r := &chunkReader{chunks: [][]byte{buf[:n], buf[n : 2*n], buf[2*n:]}}
+
+	got := new(Message)
+	if err := ReadMessage(r, got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if want := msg; !reflect.DeepEqual(want, got) {
+		t.Errorf("want message %+v, got %+v", want, got)
+	}
+
+	var short Message
+	if err := ReadMessage(bytes.NewReader(buf), &short, len(buf)-1); err != ErrOversizedMessage {
+		t.Errorf("want error %q, got %v", ErrOversizedMessage, err)
+	}
+}
+
+// chunkReader returns one chunk for each call to Read.
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, r.chunks[0])
+	if r.chunks[0] = r.chunks[0][n:]; len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
 func testRoundTrip(client, server Conn, req, res *Message) error {
 	var (
 		g errgroup.Group
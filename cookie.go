@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+
+	"github.com/jjeffcaii/dns/edns"
+)
+
+// DNS Cookies are described in RFC 7873. A COOKIE option holds an 8 byte
+// client cookie, optionally followed by an 8 to 32 byte server cookie.
+const (
+	clientCookieLen = 8
+	serverCookieLen = 8
+)
+
+// cookie returns the client and server cookies of the COOKIE option of m, and
+// whether m has a well-formed COOKIE option.
+func (m *Message) cookie() (client, server []byte, ok bool) {
+	_, opt := m.opt()
+	if opt == nil {
+		return nil, nil, false
+	}
+
+	for _, o := range opt.Options {
+		if o.Code != edns.OptionCodeCookie {
+			continue
+		}
+
+		switch n := len(o.Data); {
+		case n == clientCookieLen:
+			return o.Data, nil, true
+		case n >= clientCookieLen+8 && n <= clientCookieLen+32:
+			return o.Data[:clientCookieLen], o.Data[clientCookieLen:], true
+		default:
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
+
+// cookieOption returns a COOKIE option holding the client and server cookies.
+func cookieOption(client, server []byte) edns.Option {
+	data := make([]byte, 0, len(client)+len(server))
+	data = append(data, client...)
+
+	return edns.Option{
+		Code: edns.OptionCodeCookie,
+		Data: append(data, server...),
+	}
+}
+
+// serverCookie returns the server cookie for the client cookie of a query from
+// addr.
+func (s *Server) serverCookie(client []byte, addr net.Addr) []byte {
+	s.cookieOnce.Do(func() {
+		if s.CookieSecret != nil {
+			s.cookieKey = s.CookieSecret
+			return
+		}
+
+		s.cookieKey = make([]byte, 32)
+		if _, err := rand.Read(s.cookieKey); err != nil {
+			panic(err)
+		}
+	})
+
+	mac := hmac.New(sha256.New, s.cookieKey)
+	mac.Write(client)
+	if addr != nil {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			mac.Write([]byte(host))
+		}
+	}
+	return mac.Sum(nil)[:serverCookieLen]
+}
+
+// checkCookie reports whether query r carries a valid server cookie, and
+// returns the COOKIE option for the response. A nil option is returned if the
+// query does not carry a well-formed client cookie.
+func (s *Server) checkCookie(r *Query) (bool, *edns.Option) {
+	client, server, ok := r.cookie()
+	if !ok {
+		return false, nil
+	}
+
+	want := s.serverCookie(client, r.RemoteAddr)
+	opt := cookieOption(client, want)
+
+	return hmac.Equal(want, server), &opt
+}
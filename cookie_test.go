@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/jjeffcaii/dns/edns"
+)
+
+func TestServerRequireCookie(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr:          mustUnusedAddr(),
+		Handler:       localhostZone,
+		RequireCookie: true,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCookie := []byte("\x01\x02\x03\x04\x05\x06\x07\x08")
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+			},
+			Additionals: []Resource{
+				{
+					Name:  ".",
+					Class: Class(maxPacketLen),
+					Record: &OPT{
+						Options: []edns.Option{cookieOption(clientCookie, nil)},
+					},
+				},
+			},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := BadCookie, msg.RCode; want != got {
+		t.Fatalf("want rcode %d, got %d", want, got)
+	}
+	if want, got := 0, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+
+	client, server, ok := msg.cookie()
+	if !ok {
+		t.Fatal("want cookie option")
+	}
+	if want, got := clientCookie, client; !bytes.Equal(want, got) {
+		t.Errorf("want client cookie %x, got %x", want, got)
+	}
+	if want, got := serverCookieLen, len(server); want != got {
+		t.Fatalf("want %d byte server cookie, got %d", want, got)
+	}
+
+	// retry with the server cookie
+	query.Additionals[0].Record = &OPT{
+		Options: []edns.Option{cookieOption(clientCookie, server)},
+	}
+
+	if msg, err = new(Client).Do(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := NoError, msg.RCode; want != got {
+		t.Fatalf("want rcode %d, got %d", want, got)
+	}
+	if want, got := 3, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+	if _, got, _ := msg.cookie(); !bytes.Equal(server, got) {
+		t.Errorf("want server cookie %x, got %x", server, got)
+	}
+
+	// queries without EDNS are refused
+	query.Additionals = nil
+
+	if msg, err = new(Client).Do(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := Refused, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+}
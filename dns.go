@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
+	"time"
 )
 
 var (
@@ -11,6 +13,10 @@ var (
 	// used for more than one inflight query.
 	ErrConflictingID = errors.New("conflicting message id")
 
+	// ErrServerClosed is returned by the Serve, ServeTLS, and ServePacket
+	// methods of a Server after a call to Shutdown.
+	ErrServerClosed = errors.New("server closed")
+
 	// ErrOversizedMessage is an error returned when attempting to send a
 	// message that is longer than the maximum allowed number of bytes.
 	ErrOversizedMessage = errors.New("oversized message")
@@ -37,6 +43,70 @@ type Query struct {
 
 	// RemoteAddr is the address of a DNS resolver.
 	RemoteAddr net.Addr
+
+	// Values is scratch space for handlers and middleware to carry values
+	// along with the query, such as which upstream answered it. Values are
+	// never sent on the wire. A Server allocates the map before calling its
+	// Handler, and the copies of the query passed to nested handlers, such
+	// as by a ResolveMux, share it, so that values set by an inner handler
+	// are seen by the outer middleware. The map is not safe for concurrent
+	// use; handlers that may run concurrently, such as the handlers of the
+	// questions of a ResolveMux, use Value and SetValue instead.
+	Values map[string]interface{}
+
+	valuesmu *sync.Mutex // guards Values, shared by the copies of the query
+
+	received time.Time // when the server read the query
+}
+
+// Value returns the value of key in the Values of q, or nil.
+func (q *Query) Value(key string) interface{} {
+	if q.valuesmu == nil {
+		return q.Values[key]
+	}
+
+	q.valuesmu.Lock()
+	defer q.valuesmu.Unlock()
+
+	return q.Values[key]
+}
+
+// SetValue sets the value of key in the Values of q, allocating the map if it
+// is nil. A map allocated by SetValue is not seen by the other copies of q.
+func (q *Query) SetValue(key string, v interface{}) {
+	if q.valuesmu == nil {
+		q.initValues()
+	}
+
+	q.valuesmu.Lock()
+	defer q.valuesmu.Unlock()
+
+	if q.Values == nil {
+		q.Values = make(map[string]interface{})
+	}
+	q.Values[key] = v
+}
+
+// initValues allocates the Values of q, and the mutex guarding them, before q
+// is copied.
+func (q *Query) initValues() {
+	if q.Values == nil {
+		q.Values = make(map[string]interface{})
+	}
+	if q.valuesmu == nil {
+		q.valuesmu = new(sync.Mutex)
+	}
+}
+
+// withMessage returns a copy of q for the query message msg, which shares the
+// remote address and values of q.
+func (q *Query) withMessage(msg *Message) *Query {
+	return &Query{
+		Message:    msg,
+		RemoteAddr: q.RemoteAddr,
+		Values:     q.Values,
+		valuesmu:   q.valuesmu,
+	}
 }
 
 // OverTLSAddr indicates the remote DNS service implements DNS-over-TLS as
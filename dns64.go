@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errNoUpstream = errors.New("no upstream to send the A query to")
+
+// DefaultDNS64Prefix is the Well-Known Prefix for IPv4-embedded IPv6
+// addresses, described in RFC 6052, section 2.1.
+var DefaultDNS64Prefix = &net.IPNet{
+	IP:   net.ParseIP("64:ff9b::"),
+	Mask: net.CIDRMask(96, 128),
+}
+
+// DNS64 is a recursive handler that synthesizes AAAA records from A records,
+// as described in RFC 6147. Queries are forwarded upstream, and AAAA questions
+// answered with NODATA are answered with the A records of the name embedded
+// in the NAT64 prefix.
+type DNS64 struct {
+	// Prefix is the NAT64 prefix, with a length of 32, 40, 48, 56, 64, or
+	// 96 bits. If nil, DefaultDNS64Prefix is used.
+	Prefix *net.IPNet
+
+	// Upstream sends the A queries for AAAA questions answered with NODATA,
+	// to the remote address of the query. It is required, and AAAA
+	// questions are answered with a server failure if nil.
+	Upstream RoundTripper
+}
+
+// ServeDNS forwards the query upstream, and copies the response with any
+// synthesized AAAA records.
+func (d *DNS64) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	msg, err := w.Recur(ctx)
+	if err != nil {
+		w.Status(ServFail)
+		return
+	}
+
+	if msg.RCode == NoError && !hasType(msg.Answers, TypeAAAA) {
+		for _, q := range r.Questions {
+			if q.Type != TypeAAAA {
+				continue
+			}
+
+			if msg, err = d.synthesize(ctx, r, q, msg); err != nil {
+				w.Status(ServFail)
+				return
+			}
+		}
+	}
+
+	writeMessage(w, msg)
+}
+
+// synthesize returns msg with the answers of an A query for the name of q
+// embedded as AAAA records. msg is returned unchanged if the name has no A
+// records. The CNAME records of the A response are not copied, since msg
+// already holds the CNAME chain of the name.
+func (d *DNS64) synthesize(ctx context.Context, r *Query, q Question, msg *Message) (*Message, error) {
+	if d.Upstream == nil {
+		return nil, errNoUpstream
+	}
+
+	res, err := d.Upstream.Do(ctx, r.withMessage(&Message{
+		RecursionDesired: true,
+		Questions: []Question{
+			{Name: q.Name, Type: TypeA, Class: q.Class},
+		},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if res.RCode != NoError || !hasType(res.Answers, TypeA) {
+		return msg, nil
+	}
+
+	prefix := d.Prefix
+	if prefix == nil {
+		prefix = DefaultDNS64Prefix
+	}
+
+	answers := make([]Resource, 0, len(res.Answers))
+	for _, rr := range res.Answers {
+		if rec, ok := rr.Record.(*A); ok {
+			rr.Record = &AAAA{AAAA: embedIPv4(prefix, rec.A)}
+			answers = append(answers, rr)
+		}
+	}
+
+	out := new(Message)
+	*out = *msg // shallow copy
+	out.Answers = append(msg.Answers[:len(msg.Answers):len(msg.Answers)], answers...)
+	out.Authorities = nil
+
+	return out, nil
+}
+
+// embedIPv4 returns the IPv4-embedded IPv6 address of ip in prefix, skipping
+// the reserved bits 64 to 71, as described in RFC 6052, section 2.2.
+func embedIPv4(prefix *net.IPNet, ip net.IP) net.IP {
+	ones, _ := prefix.Mask.Size()
+
+	addr := make(net.IP, net.IPv6len)
+	copy(addr, prefix.IP.To16())
+
+	pos := ones / 8
+	for _, b := range ip.To4() {
+		if pos == 8 {
+			pos++
+		}
+		addr[pos] = b
+		pos++
+	}
+	return addr
+}
+
+// hasType reports whether rrs holds a record of type t.
+func hasType(rrs []Resource, t Type) bool {
+	for _, rr := range rrs {
+		if rr.Record.Type() == t {
+			return true
+		}
+	}
+	return false
+}
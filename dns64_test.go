@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNS64(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&Zone{
+		Origin: "localhost.",
+		TTL:    time.Hour,
+		SOA:    localhostZone.SOA,
+		RRs: RRSet{
+			"v4only": {
+				TypeA: {
+					&A{net.IPv4(10, 42, 0, 4).To4()},
+					&A{net.IPv4(10, 42, 0, 5).To4()},
+				},
+			},
+			"alias": {
+				TypeA:    {&CNAME{CNAME: "v4only.localhost."}},
+				TypeAAAA: {&CNAME{CNAME: "v4only.localhost."}},
+			},
+			"dual": {
+				TypeA:    {&A{net.IPv4(10, 42, 0, 6).To4()}},
+				TypeAAAA: {&AAAA{net.ParseIP("dead:beef::6")}},
+			},
+		},
+	})
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, prefix, err := net.ParseCIDR("2001:db8:64::/96")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		prefix *net.IPNet
+		qname  string
+
+		cnames []string
+		addrs  []string
+	}{
+		{
+			name:   "configured-prefix",
+			prefix: prefix,
+			qname:  "v4only.localhost.",
+			addrs:  []string{"2001:db8:64::a2a:4", "2001:db8:64::a2a:5"},
+		},
+		{
+			name:  "well-known-prefix",
+			qname: "v4only.localhost.",
+			addrs: []string{"64:ff9b::a2a:4", "64:ff9b::a2a:5"},
+		},
+		{
+			name:   "cname",
+			prefix: prefix,
+			qname:  "alias.localhost.",
+			cnames: []string{"v4only.localhost."},
+			addrs:  []string{"2001:db8:64::a2a:4", "2001:db8:64::a2a:5"},
+		},
+		{
+			name:   "native-aaaa",
+			prefix: prefix,
+			qname:  "dual.localhost.",
+			addrs:  []string{"dead:beef::6"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := &Client{
+				Resolver: &DNS64{
+					Prefix:   test.prefix,
+					Upstream: new(Client),
+				},
+			}
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{
+						{Name: test.qname, Type: TypeAAAA, Class: ClassIN},
+					},
+				},
+			}
+
+			msg, err := client.Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := NoError, msg.RCode; want != got {
+				t.Errorf("want rcode %d, got %d", want, got)
+			}
+			if want, got := len(test.cnames)+len(test.addrs), len(msg.Answers); want != got {
+				t.Fatalf("want %d answers, got %d", want, got)
+			}
+			for i, want := range test.cnames {
+				cname, ok := msg.Answers[i].Record.(*CNAME)
+				if !ok {
+					t.Fatalf("non CNAME answer record: %+v", msg.Answers[i])
+				}
+				if got := cname.CNAME; want != got {
+					t.Errorf("want CNAME %s, got %s", want, got)
+				}
+			}
+			for i, want := range test.addrs {
+				i += len(test.cnames)
+
+				aaaa, ok := msg.Answers[i].Record.(*AAAA)
+				if !ok {
+					t.Fatalf("non AAAA answer record: %+v", msg.Answers[i])
+				}
+				if got := aaaa.AAAA.String(); want != got {
+					t.Errorf("want AAAA %s, got %s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDNS64NoUpstream(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Resolver: new(DNS64),
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "v4only.localhost.", Type: TypeAAAA, Class: ClassIN},
+			},
+		},
+	}
+
+	msg, err := client.Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+}
+
+func TestEmbedIPv4(t *testing.T) {
+	t.Parallel()
+
+	// RFC 6052, section 2.4
+	tests := []struct {
+		prefix, addr string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::c000:221"},
+	}
+
+	for _, test := range tests {
+		_, prefix, err := net.ParseCIDR(test.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.addr, embedIPv4(prefix, net.IPv4(192, 0, 2, 33)).String(); want != got {
+			t.Errorf("want %s address %s, got %s", test.prefix, want, got)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dohMediaType is the media type of DNS messages in DNS-over-HTTPS requests
+// and responses (RFC 8484, section 6).
+const dohMediaType = "application/dns-message"
+
+var (
+	errDoHMediaType = errors.New("unexpected DNS-over-HTTPS response media type")
+	errDoHNoQuery   = errors.New("no DNS-over-HTTPS query sent")
+)
+
+// OverHTTPSAddr indicates the remote DNS service implements DNS-over-HTTPS as
+// defined in RFC 8484.
+type OverHTTPSAddr struct {
+	net.Addr
+
+	// URL is the URL of the DNS API of the server, such as
+	// "https://dns.example/dns-query". If empty, the "/dns-query" path at
+	// Addr is used.
+	URL string
+}
+
+// Network returns the address's network name with a "-https" suffix.
+func (a OverHTTPSAddr) Network() string {
+	return a.Addr.Network() + "-https"
+}
+
+func (a OverHTTPSAddr) url() string {
+	if a.URL != "" {
+		return a.URL
+	}
+	return "https://" + a.Addr.String() + "/dns-query"
+}
+
+// httpClient returns the HTTP client of t for DNS-over-HTTPS queries, which
+// shares connections across queries.
+func (t *Transport) httpClient() *http.Client {
+	t.plinemu.Lock()
+	defer t.plinemu.Unlock()
+
+	if t.hclient == nil && t.HTTPTransport != nil {
+		t.hclient = &http.Client{Transport: t.HTTPTransport}
+	}
+	if t.hclient == nil {
+		dial := t.DialContext
+		if dial == nil {
+			dial = defaultDialer.DialContext
+		}
+
+		tport := &http.Transport{
+			DialContext:       dial,
+			ForceAttemptHTTP2: true,
+		}
+		if t.TLSConfig != nil {
+			tport.TLSClientConfig = t.TLSConfig.Clone()
+		}
+		t.hclient = &http.Client{Transport: tport}
+	}
+	return t.hclient
+}
+
+func (t *Transport) dialHTTPS(ctx context.Context, addr OverHTTPSAddr) Conn {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &httpsConn{
+		client: t.httpClient(),
+		addr:   addr,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// httpsConn is a Conn that sends each query as an HTTP POST request, and
+// receives the response from the response body.
+type httpsConn struct {
+	client *http.Client
+	addr   OverHTTPSAddr
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	deadline time.Time
+	res      []byte // response to the last query
+	sent     bool
+}
+
+func (c *httpsConn) Send(msg *Message) error {
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.post(buf)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.res, c.sent = res, true
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *httpsConn) post(buf []byte) ([]byte, error) {
+	ctx := c.ctx
+
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.addr.url(), bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS status %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != dohMediaType {
+		return nil, errDoHMediaType
+	}
+
+	return ioutil.ReadAll(io.LimitReader(res.Body, 1<<16))
+}
+
+func (c *httpsConn) Recv(msg *Message) error {
+	c.mu.Lock()
+	res, sent := c.res, c.sent
+	c.res, c.sent = nil, false
+	c.mu.Unlock()
+
+	if !sent {
+		return errDoHNoQuery
+	}
+	return unpackAll(msg, res)
+}
+
+// Read and Write are not supported, messages are sent and received as HTTP
+// request and response bodies.
+
+func (c *httpsConn) Read([]byte) (int, error)  { return 0, ErrUnsupportedOp }
+func (c *httpsConn) Write([]byte) (int, error) { return 0, ErrUnsupportedOp }
+
+// Close cancels an inflight request. Idle connections are kept by the HTTP
+// client of the Transport.
+func (c *httpsConn) Close() error {
+	c.cancel()
+	return nil
+}
+
+func (c *httpsConn) LocalAddr() net.Addr  { return nil }
+func (c *httpsConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *httpsConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *httpsConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *httpsConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
@@ -0,0 +1,223 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dohHandler answers DNS-over-HTTPS POST requests at "/dns-query" from the
+// answers of the tests.
+var dohHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/dns-query" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Content-Type") != dohMediaType {
+		http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	if _, err := msg.Unpack(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := response(&msg)
+	res.Answers = []Resource{
+		{
+			Name:   msg.Questions[0].Name,
+			Class:  ClassIN,
+			TTL:    time.Minute,
+			Record: answers[msg.Questions[0]],
+		},
+	}
+
+	if buf, err = res.Pack(nil, true); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Write(buf)
+})
+
+func TestTransportOverHTTPS(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(dohHandler)
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	client := &Client{
+		Transport: &Transport{
+			TLSConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	tests := []struct {
+		name string
+
+		addr OverHTTPSAddr
+		err  bool
+	}{
+		{
+			name: "default-path",
+			addr: OverHTTPSAddr{Addr: srv.Listener.Addr()},
+		},
+		{
+			name: "url",
+			addr: OverHTTPSAddr{Addr: srv.Listener.Addr(), URL: srv.URL + "/dns-query"},
+		},
+		{
+			name: "not-found",
+			addr: OverHTTPSAddr{Addr: srv.Listener.Addr(), URL: srv.URL + "/missing"},
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			query := &Query{
+				RemoteAddr: test.addr,
+				Message: &Message{
+					Questions: []Question{questions["A"]},
+				},
+			}
+
+			msg, err := client.Do(ctx, query)
+			if test.err {
+				if err == nil {
+					t.Fatal("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := answers[questions["A"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answer %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestClientOverHTTPSFallback(t *testing.T) {
+	t.Parallel()
+
+	var nreq int32
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&nreq, 1)
+		http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+	}))
+	defer doh.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(doh.Certificate())
+
+	srv := mustServer(&answerHandler{answers})
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Transport: &Transport{
+			TLSConfig: &tls.Config{RootCAs: pool},
+		},
+		Servers: []net.Addr{
+			OverHTTPSAddr{Addr: doh.Listener.Addr()},
+			addr,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	query := &Query{
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+
+	msg, err := client.Do(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := int32(1), atomic.LoadInt32(&nreq); want != got {
+		t.Errorf("want %d DNS-over-HTTPS request, got %d", want, got)
+	}
+	if want, got := answers[questions["A"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answer %+v, got %+v", want, got)
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper calling a function, standing in
+// for an HTTP/3 round tripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestTransportHTTPTransport(t *testing.T) {
+	t.Parallel()
+
+	var nreq int32
+	tport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&nreq, 1)
+
+		rec := httptest.NewRecorder()
+		dohHandler.ServeHTTP(rec, req)
+		return rec.Result(), nil
+	})
+
+	client := &Client{
+		Transport: &Transport{
+			HTTPTransport: tport,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	query := &Query{
+		RemoteAddr: OverHTTPSAddr{Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}},
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+
+	msg, err := client.Do(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := int32(1), atomic.LoadInt32(&nreq); want != got {
+		t.Errorf("want %d request sent with HTTPTransport, got %d", want, got)
+	}
+	if want, got := answers[questions["A"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answer %+v, got %+v", want, got)
+	}
+}
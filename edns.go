@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jjeffcaii/dns/edns"
+)
+
+// opt returns the OPT pseudo-RR in the additional section of m, or nil.
+func (m *Message) opt() (*Resource, *OPT) {
+	for i := range m.Additionals {
+		if rec, ok := m.Additionals[i].Record.(*OPT); ok {
+			return &m.Additionals[i], rec
+		}
+	}
+	return nil, nil
+}
+
+// The TTL of an OPT pseudo-RR holds the upper 8 bits of the extended RCODE,
+// the EDNS version, and the flags, as described in RFC 6891, section 6.1.3.
+
+// optTTL returns the OPT TTL ttl with the extended RCODE bits of rcode.
+func optTTL(ttl time.Duration, rcode RCode) time.Duration {
+	bits := uint32(ttl/time.Second)&0x00FFFFFF | uint32(rcode>>4)<<24
+	return time.Duration(bits) * time.Second
+}
+
+// optVersion returns the EDNS version of the OPT TTL ttl.
+func optVersion(ttl time.Duration) uint8 {
+	return uint8(uint32(ttl/time.Second) >> 16)
+}
+
+// EDNSFlagDO is the DNSSEC OK (DO) bit of the EDNS flags, as described in
+// RFC 3225, section 3.
+const EDNSFlagDO uint16 = 1 << 15
+
+// EDNSFlags returns the 16-bit EDNS flags of the OPT record of m, and whether
+// m has an OPT record.
+func (m *Message) EDNSFlags() (uint16, bool) {
+	res, _ := m.opt()
+	if res == nil {
+		return 0, false
+	}
+	return uint16(uint32(res.TTL / time.Second)), true
+}
+
+// SetEDNSFlags sets the 16-bit EDNS flags of the OPT record of m, including
+// reserved bits. If m has no OPT record, a version 0 OPT record is added.
+func (m *Message) SetEDNSFlags(flags uint16) {
+	res, _ := m.opt()
+	if res == nil {
+		m.Additionals = append(m.Additionals, Resource{
+			Name:   ".",
+			Class:  Class(maxPacketLen),
+			Record: new(OPT),
+		})
+		res = &m.Additionals[len(m.Additionals)-1]
+	}
+
+	bits := uint32(res.TTL/time.Second)&0xFFFF0000 | uint32(flags)
+	res.TTL = time.Duration(bits) * time.Second
+}
+
+// SetEDNS0 sets the UDP payload size and the DNSSEC OK (DO) bit of the OPT
+// record of m. If m has no OPT record, a version 0 OPT record is added.
+func (m *Message) SetEDNS0(size uint16, do bool) {
+	flags, _ := m.EDNSFlags()
+	if do {
+		flags |= EDNSFlagDO
+	} else {
+		flags &^= EDNSFlagDO
+	}
+	m.SetEDNSFlags(flags)
+
+	res, _ := m.opt()
+	res.Class = Class(size)
+}
+
+// UDPSize returns the UDP payload size advertised by the OPT record of m, the
+// size of the largest UDP response a requester can receive. It is 512 bytes if
+// m has no OPT record, and never less (RFC 6891, section 6.2.5).
+func (m *Message) UDPSize() int {
+	if res, _ := m.opt(); res != nil && int(res.Class) > maxPacketLen {
+		return int(res.Class)
+	}
+	return maxPacketLen
+}
+
+// EDNSVersionMiddleware returns a middleware that answers EDNS queries with a
+// version above maxVersion with a "Bad OPT Version" (BADVERS) message and a
+// version 0 OPT record, as described in RFC 6891, section 6.1.3. Other queries
+// are passed to the handler.
+func EDNSVersionMiddleware(maxVersion uint8) func(Handler) Handler {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			if res, _ := r.opt(); res != nil && optVersion(res.TTL) > maxVersion {
+				w.Status(BadVers)
+				w.Additional(".", 0, new(OPT))
+				return
+			}
+
+			h.ServeDNS(ctx, w, r)
+		})
+	}
+}
+
+// ExtendedError is an Extended DNS Error (RFC 8914), with an INFO-CODE and
+// optional EXTRA-TEXT.
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+func (e ExtendedError) Error() string {
+	if e.ExtraText != "" {
+		return fmt.Sprintf("dns: extended error %d: %s", e.InfoCode, e.ExtraText)
+	}
+	return fmt.Sprintf("dns: extended error %d", e.InfoCode)
+}
+
+// option encodes e as an EDNS option.
+func (e ExtendedError) option() edns.Option {
+	data := make([]byte, 2, 2+len(e.ExtraText))
+	nbo.PutUint16(data, e.InfoCode)
+
+	return edns.Option{
+		Code: edns.OptionCodeExtendedDNSError,
+		Data: append(data, e.ExtraText...),
+	}
+}
+
+// ClientSubnet returns the EDNS Client Subnet option (RFC 7871) of m, if
+// present.
+func (m *Message) ClientSubnet() (edns.ClientSubnet, bool) {
+	var ecs edns.ClientSubnet
+
+	o, ok := m.option(edns.OptionCodeEDNSClientSubnet)
+	if !ok {
+		return ecs, false
+	}
+	if _, err := ecs.Unpack(o.Data); err != nil {
+		return ecs, false
+	}
+	return ecs, true
+}
+
+// Expire returns the EDNS EXPIRE option (RFC 7314) of m, if present.
+func (m *Message) Expire() (edns.Expire, bool) {
+	var exp edns.Expire
+
+	o, ok := m.option(edns.OptionCodeEDNSExpire)
+	if !ok {
+		return exp, false
+	}
+	if _, err := exp.Unpack(o.Data); err != nil {
+		return exp, false
+	}
+	return exp, true
+}
+
+// option returns the first EDNS option of m with the code.
+func (m *Message) option(code edns.OptionCode) (edns.Option, bool) {
+	_, opt := m.opt()
+	if opt == nil {
+		return edns.Option{}, false
+	}
+
+	for _, o := range opt.Options {
+		if o.Code == code {
+			return o, true
+		}
+	}
+	return edns.Option{}, false
+}
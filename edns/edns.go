@@ -5,6 +5,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
+	"net"
+	"time"
 )
 
 var nbo = binary.BigEndian
@@ -31,14 +34,21 @@ const (
 	OptionCodePadding          OptionCode = 12 // Standard [RFC7830]
 	OptionCodeChain            OptionCode = 13 // Standard [RFC7901]
 	OptionCodeEDNSKeyTag       OptionCode = 14 // Optional [RFC8145]
-	// 15-26945	Unassigned
+	OptionCodeExtendedDNSError OptionCode = 15 // Standard [RFC8914]
+	// 16-26945	Unassigned
 	OptionCodeDeviceID OptionCode = 26946 // Optional [https://docs.umbrella.com/developer/networkdevices-api/identifying-dns-traffic2][Brian_Hartvigsen]
 	// 26947-65000	Unassigned
 	// 65001-65534	Reserved for Local/Experimental Use	[RFC6891]
 	// 65535	Reserved for future expansion		[RFC6891]
 )
 
-var errOptionLen = errors.New("insufficient data for option length")
+var (
+	errOptionLen    = errors.New("insufficient data for option length")
+	errSubnetFamily = errors.New("unknown client subnet address family")
+	errSubnetPrefix = errors.New("client subnet prefix too long for address family")
+	errExpireLen    = errors.New("invalid expire option length")
+	errExpireRange  = errors.New("expire timer out of range")
+)
 
 // Option is a EDNS0 option.
 type Option struct {
@@ -82,3 +92,133 @@ func (o *Option) Unpack(b []byte) ([]byte, error) {
 
 	return b[4+l:], nil
 }
+
+// ClientSubnet is an EDNS Client Subnet (ECS) option as defined in RFC 7871.
+type ClientSubnet struct {
+	SourcePrefix int
+	ScopePrefix  int
+
+	// Address is the client subnet address. The address family is IPv4 if
+	// Address has an IPv4 representation, and IPv6 otherwise.
+	Address net.IP
+}
+
+// Option returns c as an EDNS0 option.
+func (c ClientSubnet) Option() (Option, error) {
+	data, err := c.Pack(nil)
+	if err != nil {
+		return Option{}, err
+	}
+	return Option{Code: OptionCodeEDNSClientSubnet, Data: data}, nil
+}
+
+// Pack encodes c as option data.
+func (c ClientSubnet) Pack(b []byte) ([]byte, error) {
+	family, addr := uint16(2), c.Address.To16()
+	if ip4 := c.Address.To4(); ip4 != nil {
+		family, addr = 1, ip4
+	}
+	if addr == nil {
+		return nil, errSubnetFamily
+	}
+
+	bits := 8 * len(addr)
+	if c.SourcePrefix < 0 || c.SourcePrefix > bits || c.ScopePrefix < 0 || c.ScopePrefix > bits {
+		return nil, errSubnetPrefix
+	}
+
+	// The address is truncated to the source prefix, with the trailing
+	// bits of the final octet cleared (RFC 7871, section 6).
+	addr = addr.Mask(net.CIDRMask(c.SourcePrefix, bits))[:(c.SourcePrefix+7)/8]
+
+	buf := [4]byte{}
+	nbo.PutUint16(buf[:2], family)
+	buf[2] = byte(c.SourcePrefix)
+	buf[3] = byte(c.ScopePrefix)
+
+	return append(append(b, buf[:]...), addr...), nil
+}
+
+// Unpack decodes c from option data in b.
+func (c *ClientSubnet) Unpack(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errOptionLen
+	}
+
+	var addrlen int
+	switch nbo.Uint16(b[:2]) {
+	case 1:
+		addrlen = net.IPv4len
+	case 2:
+		addrlen = net.IPv6len
+	default:
+		return nil, errSubnetFamily
+	}
+
+	c.SourcePrefix, c.ScopePrefix = int(b[2]), int(b[3])
+	if c.SourcePrefix > 8*addrlen || c.ScopePrefix > 8*addrlen {
+		return nil, errSubnetPrefix
+	}
+
+	n := (c.SourcePrefix + 7) / 8
+	if len(b) < 4+n {
+		return nil, io.ErrShortBuffer
+	}
+
+	c.Address = make(net.IP, addrlen)
+	copy(c.Address, b[4:4+n])
+
+	return b[4+n:], nil
+}
+
+// Expire is an EDNS EXPIRE option as defined in RFC 7314. A query requests the
+// expire timer with an empty option, and a response holds the time until the
+// zone expires.
+type Expire struct {
+	// Expire is the time until the zone expires, with the precision of a
+	// second. It is not encoded for an empty option.
+	Expire time.Duration
+
+	// Empty indicates an option without data, such as in a query.
+	Empty bool
+}
+
+// Option returns e as an EDNS0 option.
+func (e Expire) Option() (Option, error) {
+	data, err := e.Pack(nil)
+	if err != nil {
+		return Option{}, err
+	}
+	return Option{Code: OptionCodeEDNSExpire, Data: data}, nil
+}
+
+// Pack encodes e as option data.
+func (e Expire) Pack(b []byte) ([]byte, error) {
+	if e.Empty {
+		return b, nil
+	}
+
+	secs := e.Expire / time.Second
+	if secs < 0 || secs > math.MaxUint32 {
+		return nil, errExpireRange
+	}
+
+	buf := [4]byte{}
+	nbo.PutUint32(buf[:], uint32(secs))
+
+	return append(b, buf[:]...), nil
+}
+
+// Unpack decodes e from option data in b.
+func (e *Expire) Unpack(b []byte) ([]byte, error) {
+	switch len(b) {
+	case 0:
+		*e = Expire{Empty: true}
+		return b, nil
+	case 4:
+		*e = Expire{Expire: time.Duration(nbo.Uint32(b)) * time.Second}
+		return b[4:], nil
+	default:
+		return nil, errExpireLen
+	}
+}
@@ -2,8 +2,10 @@ package edns
 
 import (
 	"bytes"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestOptionPackUnpack(t *testing.T) {
@@ -85,3 +87,134 @@ func TestOptionPackUnpack(t *testing.T) {
 		})
 	}
 }
+
+func TestClientSubnetPackUnpack(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		ecs ClientSubnet
+
+		raw []byte
+	}{
+		{
+			name: "IPv4 /24",
+
+			ecs: ClientSubnet{
+				SourcePrefix: 24,
+				Address:      net.IPv4(192, 0, 2, 0).To4(),
+			},
+
+			raw: []byte{
+				0x00, 0x01, // FAMILY = 1 (IPv4)
+				0x18,             // SOURCE PREFIX-LENGTH = 24
+				0x00,             // SCOPE PREFIX-LENGTH = 0
+				0xC0, 0x00, 0x02, // ADDRESS = 192.0.2
+			},
+		},
+		{
+			name: "IPv6 /56 scope /48",
+
+			ecs: ClientSubnet{
+				SourcePrefix: 56,
+				ScopePrefix:  48,
+				Address:      net.ParseIP("2001:db8:ab:cd00::"),
+			},
+
+			raw: []byte{
+				0x00, 0x02, // FAMILY = 2 (IPv6)
+				0x38,                                     // SOURCE PREFIX-LENGTH = 56
+				0x30,                                     // SCOPE PREFIX-LENGTH = 48
+				0x20, 0x01, 0x0D, 0xB8, 0x00, 0xAB, 0xCD, // ADDRESS = 2001:db8:ab:cd
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw, err := test.ecs.Pack(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.raw, raw; !bytes.Equal(want, got) {
+				t.Errorf("want raw client subnet %+v, got %+v", want, got)
+			}
+
+			ecs := new(ClientSubnet)
+			buf, err := ecs.Unpack(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(buf) > 0 {
+				t.Errorf("left-over data after unpack: %x", buf)
+			}
+
+			if want, got := test.ecs, *ecs; !reflect.DeepEqual(want, got) {
+				t.Errorf("want client subnet %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestExpirePackUnpack(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		exp Expire
+
+		raw []byte
+	}{
+		{
+			name: "query",
+
+			exp: Expire{Empty: true},
+
+			raw: []byte{},
+		},
+		{
+			name: "one day",
+
+			exp: Expire{Expire: 24 * time.Hour},
+
+			raw: []byte{0x00, 0x01, 0x51, 0x80}, // EXPIRE = 86400
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw, err := test.exp.Pack([]byte{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.raw, raw; !bytes.Equal(want, got) {
+				t.Errorf("want raw expire %+v, got %+v", want, got)
+			}
+
+			exp := new(Expire)
+			if _, err := exp.Unpack(raw); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.exp, *exp; want != got {
+				t.Errorf("want expire %+v, got %+v", want, got)
+			}
+		})
+	}
+
+	if _, err := new(Expire).Unpack([]byte{0x00, 0x01}); err != errExpireLen {
+		t.Errorf("want error %q, got %v", errExpireLen, err)
+	}
+}
@@ -0,0 +1,329 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/dns/edns"
+)
+
+func TestMessageClientSubnet(t *testing.T) {
+	t.Parallel()
+
+	ecs := edns.ClientSubnet{
+		SourcePrefix: 24,
+		Address:      net.IPv4(198, 51, 100, 0).To4(),
+	}
+
+	opt, err := ecs.Option()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{
+		Questions: []Question{questions["A"]},
+		Additionals: []Resource{
+			{
+				Name:   ".",
+				Class:  1232,
+				Record: &OPT{Options: []edns.Option{opt}},
+			},
+		},
+	}
+
+	raw, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msg.Unpack(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := msg.ClientSubnet()
+	if !ok {
+		t.Fatal("missing client subnet")
+	}
+	if want := ecs; !reflect.DeepEqual(want, got) {
+		t.Errorf("want client subnet %+v, got %+v", want, got)
+	}
+
+	if _, ok := new(Message).ClientSubnet(); ok {
+		t.Error("want no client subnet for message without OPT")
+	}
+}
+
+func TestEDNSVersionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(EDNSVersionMiddleware(0)(&answerHandler{answers}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		version uint8
+
+		rcode   RCode
+		answers int
+	}{
+		{name: "version 0", version: 0, rcode: NoError, answers: 1},
+		{name: "version 2", version: 2, rcode: BadVers},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{questions["A"]},
+					Additionals: []Resource{
+						{
+							Name:   ".",
+							Class:  1232,
+							TTL:    time.Duration(test.version) << 16 * time.Second,
+							Record: new(OPT),
+						},
+					},
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %d, got %d", want, got)
+			}
+			if want, got := test.answers, len(msg.Answers); want != got {
+				t.Errorf("want %d answers, got %d", want, got)
+			}
+
+			res, _ := msg.opt()
+			if res == nil {
+				t.Fatal("missing response OPT record")
+			}
+			if want, got := uint8(0), optVersion(res.TTL); want != got {
+				t.Errorf("want response EDNS version %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestMessageEDNSFlags(t *testing.T) {
+	t.Parallel()
+
+	msg := &Message{
+		ID: 0x1234,
+		Questions: []Question{
+			{Name: "localhost.", Type: TypeA, Class: ClassIN},
+		},
+	}
+	if _, ok := msg.EDNSFlags(); ok {
+		t.Fatal("want no EDNS flags without an OPT record")
+	}
+
+	flags := EDNSFlagDO | 0x0001 // DO and a reserved bit
+	msg.SetEDNSFlags(flags)
+
+	// the version and extended RCODE bits are preserved
+	msg.Additionals[0].TTL = time.Duration(2<<16|uint32(flags)) * time.Second
+
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(Message)
+	if _, err := got.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	gotFlags, ok := got.EDNSFlags()
+	if !ok {
+		t.Fatal("want OPT record")
+	}
+	if want, got := flags, gotFlags; want != got {
+		t.Errorf("want EDNS flags %#04x, got %#04x", want, got)
+	}
+
+	got.SetEDNSFlags(0)
+	if want, got := uint8(2), optVersion(got.Additionals[0].TTL); want != got {
+		t.Errorf("want EDNS version %d, got %d", want, got)
+	}
+	if want, got := 1, len(got.Additionals); want != got {
+		t.Errorf("want %d additionals, got %d", want, got)
+	}
+}
+
+func TestMessageSetEDNS0(t *testing.T) {
+	t.Parallel()
+
+	msg := new(Message)
+	if want, got := 512, msg.UDPSize(); want != got {
+		t.Errorf("want UDP size %d without an OPT record, got %d", want, got)
+	}
+
+	msg.SetEDNS0(4096, true)
+	if want, got := 4096, msg.UDPSize(); want != got {
+		t.Errorf("want UDP size %d, got %d", want, got)
+	}
+	if flags, _ := msg.EDNSFlags(); flags&EDNSFlagDO == 0 {
+		t.Error("want DO bit set")
+	}
+
+	msg.SetEDNS0(256, false)
+	if want, got := 512, msg.UDPSize(); want != got {
+		t.Errorf("want UDP size %d for a small payload size, got %d", want, got)
+	}
+	if flags, _ := msg.EDNSFlags(); flags&EDNSFlagDO != 0 {
+		t.Error("want DO bit cleared")
+	}
+	if want, got := 1, len(msg.Additionals); want != got {
+		t.Errorf("want %d additionals, got %d", want, got)
+	}
+}
+
+func TestServerForwardOPT(t *testing.T) {
+	t.Parallel()
+
+	ecs := edns.ClientSubnet{
+		SourcePrefix: 24,
+		Address:      net.IPv4(198, 51, 100, 0).To4(),
+	}
+	ecsOption, err := ecs.Option()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nsid := edns.Option{Code: edns.OptionCodeNSID, Data: []byte("upstream-1")}
+	ede := ExtendedError{InfoCode: 3, ExtraText: "stale answer"}.option()
+
+	ecsc := make(chan edns.ClientSubnet, 1)
+	upstream := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		got, _ := r.ClientSubnet()
+		ecsc <- got
+
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(192, 0, 2, 1).To4()})
+		w.Additional(".", 0, &OPT{
+			Options: []edns.Option{
+				nsid,
+				ede,
+				{Code: edns.OptionCodeCookie, Data: []byte("\x01\x02\x03\x04\x05\x06\x07\x08upstream")},
+				{Code: edns.OptionCodePadding, Data: make([]byte, 16)},
+			},
+		})
+	}))
+
+	upstreamAddr, err := net.ResolveUDPAddr("udp", upstream.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{
+		Addr:    mustUnusedAddr(),
+		Handler: HandlerFunc(Recursor),
+		Forwarder: &Client{
+			Transport: &Transport{
+				Proxy: func(context.Context, net.Addr) (net.Addr, error) {
+					return upstreamAddr, nil
+				},
+			},
+		},
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+			},
+			Additionals: []Resource{
+				{
+					Name:   ".",
+					Class:  Class(maxEDNSPacketLen),
+					Record: &OPT{Options: []edns.Option{ecsOption}},
+				},
+			},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := ecs, <-ecsc; !reflect.DeepEqual(want, got) {
+		t.Errorf("want upstream client subnet %+v, got %+v", want, got)
+	}
+
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+	if want, got := 1, len(msg.Additionals); want != got {
+		t.Fatalf("want %d additional (OPT) record, got %d", want, got)
+	}
+	_, opt := msg.opt()
+	if opt == nil {
+		t.Fatal("want OPT record")
+	}
+	if want, got := []edns.Option{nsid, ede}, opt.Options; !reflect.DeepEqual(want, got) {
+		t.Errorf("want relayed options %+v, got %+v", want, got)
+	}
+}
+
+func TestServerMergeOPT(t *testing.T) {
+	t.Parallel()
+
+	nsid := edns.Option{Code: edns.OptionCodeNSID, Data: []byte("ns1")}
+	ede := ExtendedError{InfoCode: 23, ExtraText: "upstream down"}.option()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Additional(".", 0, &OPT{Options: []edns.Option{nsid}})
+		w.Additional(".", 0, &OPT{Options: []edns.Option{ede, {Code: edns.OptionCodeNSID, Data: []byte("ns2")}}})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+	query.SetEDNS0(maxEDNSPacketLen, false)
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 1, len(msg.Additionals); want != got {
+		t.Fatalf("want %d additional (OPT) record, got %d", want, got)
+	}
+	if want, got := []edns.Option{nsid, ede}, msg.Additionals[0].Record.(*OPT).Options; !reflect.DeepEqual(want, got) {
+		t.Errorf("want merged options %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"context"
+	"net"
+
+	"github.com/jjeffcaii/dns/edns"
+)
+
+// GeoHandler is a split-horizon DNS handler. It matches the client subnet of
+// a query to a Handler.
+//
+// The client subnet is the EDNS Client Subnet (RFC 7871) of the query, if
+// present, or the remote address of the query otherwise. The SCOPE
+// PREFIX-LENGTH of the response is set to the prefix length of the matched
+// subnet.
+type GeoHandler struct {
+	// Default handles queries from clients outside of every subnet. If nil,
+	// those queries are answered with a "Query Refused" message.
+	Default Handler
+
+	tbl []geoEntry
+}
+
+type geoEntry struct {
+	subnet *net.IPNet
+	h      Handler
+}
+
+// Handle registers the handler for clients in subnet.
+func (g *GeoHandler) Handle(subnet *net.IPNet, h Handler) {
+	g.tbl = append(g.tbl, geoEntry{subnet: subnet, h: h})
+}
+
+// ServeDNS dispatches the query to the handler whose subnet most closely
+// matches the client subnet.
+func (g *GeoHandler) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	ecs, ok := r.ClientSubnet()
+
+	ip := addrIP(r.RemoteAddr)
+	if ok {
+		ip = ecs.Address
+	}
+
+	h, scope := g.lookup(ip)
+	if h == nil {
+		h = HandlerFunc(Refuse)
+	}
+	if scope < 0 {
+		scope = ecs.SourcePrefix
+	}
+
+	if ok {
+		ecs.ScopePrefix = scope
+		if opt, err := ecs.Option(); err == nil {
+			w.Additional(".", 0, &OPT{Options: []edns.Option{opt}})
+		}
+	}
+
+	h.ServeDNS(ctx, w, r)
+}
+
+// lookup returns the handler and prefix length of the most specific subnet
+// containing ip, or the prefix length -1 if no subnet matches.
+func (g *GeoHandler) lookup(ip net.IP) (Handler, int) {
+	h, scope := g.Default, -1
+	for _, e := range g.tbl {
+		if !e.subnet.Contains(ip) {
+			continue
+		}
+		if ones, _ := e.subnet.Mask.Size(); ones > scope {
+			h, scope = e.h, ones
+		}
+	}
+	return h, scope
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	default:
+		return nil
+	}
+}
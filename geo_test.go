@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jjeffcaii/dns/edns"
+)
+
+func TestGeoHandler(t *testing.T) {
+	t.Parallel()
+
+	answerA := func(ip net.IP) Handler {
+		return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: ip.To4()})
+		})
+	}
+
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+	_, office, _ := net.ParseCIDR("10.42.0.0/16")
+
+	geo := &GeoHandler{
+		Default: answerA(net.IPv4(192, 0, 2, 1)),
+	}
+	geo.Handle(internal, answerA(net.IPv4(10, 0, 0, 1)))
+	geo.Handle(office, answerA(net.IPv4(10, 42, 0, 1)))
+
+	srv := mustServer(geo)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		subnet net.IP
+
+		answer net.IP
+		scope  int
+	}{
+		{
+			name:   "internal",
+			subnet: net.IPv4(10, 1, 2, 0),
+			answer: net.IPv4(10, 0, 0, 1),
+			scope:  8,
+		},
+		{
+			name:   "office",
+			subnet: net.IPv4(10, 42, 7, 0),
+			answer: net.IPv4(10, 42, 0, 1),
+			scope:  16,
+		},
+		{
+			name:   "default",
+			subnet: net.IPv4(203, 0, 113, 0),
+			answer: net.IPv4(192, 0, 2, 1),
+			scope:  24,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			opt, err := edns.ClientSubnet{
+				SourcePrefix: 24,
+				Address:      test.subnet.To4(),
+			}.Option()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{
+						{Name: "geo.dev.", Type: TypeA, Class: ClassIN},
+					},
+					Additionals: []Resource{
+						{
+							Name:   ".",
+							Class:  Class(maxPacketLen),
+							Record: &OPT{Options: []edns.Option{opt}},
+						},
+					},
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.answer, msg.Answers[0].Record.(*A).A; !want.Equal(got) {
+				t.Errorf("want A record %s, got %s", want, got)
+			}
+
+			ecs, ok := msg.ClientSubnet()
+			if !ok {
+				t.Fatal("missing response client subnet")
+			}
+			if want, got := test.scope, ecs.ScopePrefix; want != got {
+				t.Errorf("want scope prefix %d, got %d", want, got)
+			}
+			if want, got := test.subnet.To4(), ecs.Address; !want.Equal(got) {
+				t.Errorf("want client subnet address %s, got %s", want, got)
+			}
+		})
+	}
+}
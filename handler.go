@@ -2,7 +2,10 @@ package dns
 
 import (
 	"context"
+	"errors"
 	"strings"
+
+	"github.com/jjeffcaii/dns/edns"
 )
 
 // Handler responds to a DNS query.
@@ -14,6 +17,10 @@ import (
 // A recursive handler may call the Recur method of the MessageWriter to send
 // an query upstream. Only unanswered questions are included in the upstream
 // query.
+//
+// A query may hold more than one question, and every question is present in
+// the Questions of the query. Handlers that answer one question at a time
+// may be wrapped with EachQuestion.
 type Handler interface {
 	ServeDNS(context.Context, MessageWriter, *Query)
 }
@@ -28,6 +35,92 @@ func (f HandlerFunc) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
 	f(ctx, w, r)
 }
 
+// The ErrorHandlerFunc type is an adapter to allow the use of functions that
+// fail with an error as DNS handlers. If the function returns a non-nil error
+// without sending a response, the query is answered with a "Server Failure"
+// message. An ExtendedError is added to the response of an EDNS query as an
+// Extended DNS Error option.
+type ErrorHandlerFunc func(context.Context, MessageWriter, *Query) error
+
+// ServeDNS calls f(w, r), and answers with a server failure if f fails.
+func (f ErrorHandlerFunc) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	rw := &replyWriter{MessageWriter: w}
+
+	err := f(ctx, rw, r)
+	if err == nil || rw.replied {
+		return
+	}
+
+	w.Status(ServFail)
+
+	var ede ExtendedError
+	if res, _ := r.opt(); res != nil && errors.As(err, &ede) {
+		w.Additional(".", 0, &OPT{Options: []edns.Option{ede.option()}})
+	}
+}
+
+// replyWriter is a MessageWriter that records whether a response was sent.
+type replyWriter struct {
+	MessageWriter
+
+	replied bool
+}
+
+func (w *replyWriter) Reply(ctx context.Context) error {
+	w.replied = true
+	return w.MessageWriter.Reply(ctx)
+}
+
+func (w *replyWriter) SendRaw(b []byte) error {
+	w.replied = true
+	return w.MessageWriter.SendRaw(b)
+}
+
+// EachQuestion returns a handler that calls h once for each question of a
+// query, passing a copy of the query holding only that question. All calls
+// share the MessageWriter, so h should not call the Reply method.
+func EachQuestion(h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		for _, q := range r.Questions {
+			msg := new(Message)
+			*msg = *r.Message // shallow copy
+			msg.Questions = []Question{q}
+
+			h.ServeDNS(ctx, w, r.withMessage(msg))
+		}
+	})
+}
+
+// HealthCheckMiddleware returns a middleware that answers queries for the
+// health check name with the canned records, without calling the handler, so
+// that load balancers can verify the server is alive. Records are answered for
+// questions of their type, or of all types (QTYPE "*"), with a zero TTL. The
+// name is matched case-insensitively. Queries with a question for another name,
+// or without questions, are passed to the handler.
+func HealthCheckMiddleware(name string, recs ...Record) func(Handler) Handler {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			healthCheck := len(r.Questions) > 0
+			for _, q := range r.Questions {
+				healthCheck = healthCheck && strings.EqualFold(q.Name, name)
+			}
+			if !healthCheck {
+				h.ServeDNS(ctx, w, r)
+				return
+			}
+
+			w.Authoritative(true)
+			for _, q := range r.Questions {
+				for _, rec := range recs {
+					if q.Type == rec.Type() || q.Type == TypeALL {
+						w.Answer(q.Name, 0, rec)
+					}
+				}
+			}
+		})
+	}
+}
+
 // Recursor forwards a query and copies the response.
 func Recursor(ctx context.Context, w MessageWriter, r *Query) {
 	msg, err := w.Recur(ctx)
@@ -44,21 +137,106 @@ func Refuse(ctx context.Context, w MessageWriter, r *Query) {
 	w.Status(Refused)
 }
 
+// StaticHandler answers questions from a fixed set of resources. Names are
+// matched case-insensitively, and queries for unknown names are answered with
+// a "Non-Existent Domain" message. A query with questions for both known and
+// unknown names is answered for the known names only.
+type StaticHandler struct {
+	answers map[Question][]Resource
+	names   map[string]struct{}
+}
+
+// Add registers the answer resources for a question.
+func (h *StaticHandler) Add(q Question, rrs ...Resource) {
+	if h.answers == nil {
+		h.answers = make(map[Question][]Resource)
+		h.names = make(map[string]struct{})
+	}
+
+	q.Name = strings.ToLower(q.Name)
+
+	h.answers[q] = append(h.answers[q], rrs...)
+	h.names[q.Name] = struct{}{}
+}
+
+// ServeDNS answers the questions of the query from the registered resources.
+func (h *StaticHandler) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	nx := len(r.Questions) > 0
+	for _, q := range r.Questions {
+		q.Name = strings.ToLower(q.Name)
+
+		if _, ok := h.names[q.Name]; !ok {
+			continue
+		}
+		nx = false
+
+		for _, res := range h.answers[q] {
+			w.Answer(res.Name, res.TTL, res.Record)
+		}
+	}
+
+	// the name error only applies if no question is for a known name
+	if nx {
+		w.Status(NXDomain)
+	}
+}
+
 // ResolveMux is a DNS query multiplexer. It matches a question type and name
-// suffix to a Handler.
+// suffix to a Handler. Suffixes are matched by whole labels and
+// case-insensitively, and the longest matching suffix wins.
 type ResolveMux struct {
-	tbl []muxEntry
+	root muxNode
+}
+
+// muxNode is a node of the tree of registered suffixes, with a child for each
+// label towards the leaves of the DNS name space.
+type muxNode struct {
+	children map[string]*muxNode
+	entries  []muxEntry
 }
 
 type muxEntry struct {
-	typ    Type
-	suffix string
-	h      Handler
+	typ Type
+	h   Handler
 }
 
 // Handle registers the handler for the given question type and name suffix.
+// Handlers of the same suffix are matched in the order they are registered.
 func (m *ResolveMux) Handle(typ Type, suffix string, h Handler) {
-	m.tbl = append(m.tbl, muxEntry{typ: typ, suffix: suffix, h: h})
+	n, name := &m.root, strings.ToLower(strings.Trim(suffix, "."))
+	for name != "" {
+		var label string
+		name, label = lastLabel(name)
+
+		child, ok := n.children[label]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*muxNode)
+			}
+			child = new(muxNode)
+			n.children[label] = child
+		}
+		n = child
+	}
+	n.entries = append(n.entries, muxEntry{typ: typ, h: h})
+}
+
+// lastLabel splits name, without a trailing dot, before its last label.
+func lastLabel(name string) (string, string) {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// handler returns the first handler of n registered for typ, or nil.
+func (n *muxNode) handler(typ Type) Handler {
+	for _, e := range n.entries {
+		if e.typ == typ || e.typ == TypeANY {
+			return e.h
+		}
+	}
+	return nil
 }
 
 // ServeDNS dispatches the query to the handler(s) whose pattern most closely
@@ -127,16 +305,26 @@ var recursiveHandler = HandlerFunc(func(ctx context.Context, w MessageWriter, r
 })
 
 func (m *ResolveMux) lookup(q Question) Handler {
-	for _, e := range m.tbl {
-		if e.typ != q.Type && e.typ != TypeANY {
-			continue
+	h := m.root.handler(q.Type)
+
+	// ToLower does not allocate for a name that is already lowercase.
+	name := strings.TrimSuffix(strings.ToLower(q.Name), ".")
+	for n := &m.root; name != ""; {
+		var label string
+		name, label = lastLabel(name)
+
+		if n = n.children[label]; n == nil {
+			break
 		}
-		if strings.HasSuffix(q.Name, e.suffix) {
-			return e.h
+		if nh := n.handler(q.Type); nh != nil {
+			h = nh
 		}
 	}
 
-	return recursiveHandler
+	if h == nil {
+		return recursiveHandler
+	}
+	return h
 }
 
 func (m *ResolveMux) serveMux(ctx context.Context, h Handler, w *muxWriter, r *Query) {
@@ -206,6 +394,12 @@ func (w muxWriter) Reply(ctx context.Context) error {
 	return me.err
 }
 
+// SendRaw is not supported, the response of a ResolveMux is merged from the
+// responses to each question.
+func (w muxWriter) SendRaw([]byte) error {
+	return ErrUnsupportedOp
+}
+
 func (w muxWriter) finish(ctx context.Context) {
 	if w.replyc != nil {
 		w.Reply(ctx)
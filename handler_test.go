@@ -2,10 +2,16 @@ package dns
 
 import (
 	"context"
+	"errors"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/jjeffcaii/dns/edns"
 )
 
 func TestResolveMux(t *testing.T) {
@@ -63,7 +69,7 @@ func TestResolveMux(t *testing.T) {
 			RemoteAddr: addr,
 			Message: &Message{
 				Questions: []Question{
-					{Name: "foo.mx.", Type: TypeMX},
+					{Name: "foo.mx.", Type: TypeMX, Class: ClassIN},
 				},
 			},
 		}
@@ -89,8 +95,8 @@ func TestResolveMux(t *testing.T) {
 			RemoteAddr: addr,
 			Message: &Message{
 				Questions: []Question{
-					{Name: "foo.mx.", Type: TypeMX},
-					{Name: "bar.mx.", Type: TypeMX},
+					{Name: "foo.mx.", Type: TypeMX, Class: ClassIN},
+					{Name: "bar.mx.", Type: TypeMX, Class: ClassIN},
 				},
 			},
 		}
@@ -118,8 +124,8 @@ func TestResolveMux(t *testing.T) {
 			RemoteAddr: addr,
 			Message: &Message{
 				Questions: []Question{
-					{Name: "app.localhost.", Type: TypeA},
-					{Name: "app.localhost.", Type: TypeAAAA},
+					{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+					{Name: "app.localhost.", Type: TypeAAAA, Class: ClassIN},
 				},
 			},
 		}
@@ -148,8 +154,8 @@ func TestResolveMux(t *testing.T) {
 			RemoteAddr: addr,
 			Message: &Message{
 				Questions: []Question{
-					{Name: "test.local.", Type: TypeA},
-					{Name: "test.local.", Type: TypeAAAA},
+					{Name: "test.local.", Type: TypeA, Class: ClassIN},
+					{Name: "test.local.", Type: TypeAAAA, Class: ClassIN},
 				},
 			},
 		}
@@ -163,3 +169,477 @@ func TestResolveMux(t *testing.T) {
 		}
 	})
 }
+
+// muxTestHandler is a comparable Handler identifying a ResolveMux entry.
+type muxTestHandler string
+
+func (muxTestHandler) ServeDNS(context.Context, MessageWriter, *Query) {}
+
+func TestResolveMuxLookup(t *testing.T) {
+	t.Parallel()
+
+	mux := new(ResolveMux)
+	mux.Handle(TypeANY, ".", muxTestHandler("root"))
+	mux.Handle(TypeMX, "example.com.", muxTestHandler("example-mx"))
+	mux.Handle(TypeANY, "example.com.", muxTestHandler("example"))
+	mux.Handle(TypeANY, "Sub.Example.COM.", muxTestHandler("sub"))
+
+	tests := []struct {
+		q Question
+		h muxTestHandler
+	}{
+		{Question{Name: "example.com.", Type: TypeA}, "example"},
+		{Question{Name: "www.example.com.", Type: TypeA}, "example"},
+		{Question{Name: "www.example.com.", Type: TypeMX}, "example-mx"},
+		{Question{Name: "WWW.EXAMPLE.COM.", Type: TypeA}, "example"},
+		{Question{Name: "a.sub.example.com.", Type: TypeMX}, "sub"},
+		{Question{Name: "badexample.com.", Type: TypeA}, "root"},
+		{Question{Name: "example.org.", Type: TypeA}, "root"},
+		{Question{Name: ".", Type: TypeNS}, "root"},
+	}
+
+	for _, test := range tests {
+		if want, got := Handler(test.h), mux.lookup(test.q); want != got {
+			t.Errorf("%s type %d: want handler %v, got %v", test.q.Name, test.q.Type, want, got)
+		}
+	}
+}
+
+func TestResolveMuxSendRaw(t *testing.T) {
+	t.Parallel()
+
+	raw, err := (&Message{Response: true, Questions: []Question{questions["A"]}}).Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	mux := new(ResolveMux)
+	mux.Handle(TypeANY, ".", HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		errc <- w.SendRaw(raw)
+
+		// the handler falls back to adding its records
+		w.Answer(r.Questions[0].Name, time.Minute, answers[questions["A"]])
+	}))
+
+	srv := mustServer(mux)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := ErrUnsupportedOp, <-errc; want != got {
+		t.Errorf("want SendRaw error %v, got %v", want, got)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Fatalf("want %d answer, got %d", want, got)
+	}
+	if want, got := answers[questions["A"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answer %+v, got %+v", want, got)
+	}
+}
+
+func BenchmarkResolveMuxLookup(b *testing.B) {
+	const n = 10000
+
+	type linearEntry struct {
+		suffix string
+		h      Handler
+	}
+
+	var (
+		mux    = new(ResolveMux)
+		linear = make([]linearEntry, 0, n)
+	)
+	for i := 0; i < n; i++ {
+		suffix := "zone" + strconv.Itoa(i) + ".example."
+		mux.Handle(TypeANY, suffix, muxTestHandler(suffix))
+		linear = append(linear, linearEntry{suffix, muxTestHandler(suffix)})
+	}
+
+	q := Question{Name: "www.zone" + strconv.Itoa(n-1) + ".example.", Type: TypeA}
+
+	b.Run("tree", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if mux.lookup(q) == nil {
+				b.Fatal("no handler")
+			}
+		}
+	})
+
+	// linear is the previous lookup, a scan of the suffixes in order, for
+	// comparison.
+	b.Run("linear", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var h Handler
+			for _, e := range linear {
+				if strings.HasSuffix(q.Name, e.suffix) {
+					h = e.h
+					break
+				}
+			}
+			if h == nil {
+				b.Fatal("no handler")
+			}
+		}
+	})
+}
+
+func TestQueryValues(t *testing.T) {
+	t.Parallel()
+
+	// the inner handler is passed a copy of the query by EachQuestion
+	inner := EachQuestion(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer(r.Questions[0].Name, time.Minute, &TXT{TXT: []string{r.Value("outer").(string)}})
+
+		r.SetValue("inner", "answered")
+	}))
+
+	valc := make(chan interface{}, 1)
+	outer := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		r.SetValue("outer", "upstream-1")
+
+		inner.ServeDNS(ctx, w, r)
+
+		valc <- r.Value("inner")
+	})
+
+	srv := mustServer(outer)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "values.dev.", Type: TypeTXT, Class: ClassIN},
+			},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := []string{"upstream-1"}, msg.Answers[0].Record.(*TXT).TXT; !reflect.DeepEqual(want, got) {
+		t.Errorf("want TXT %q, got %q", want, got)
+	}
+	if want, got := "answered", <-valc; want != got {
+		t.Errorf("want inner value %q, got %q", want, got)
+	}
+}
+
+func TestQueryValuesResolveMux(t *testing.T) {
+	t.Parallel()
+
+	// the handlers of the questions run concurrently, and share the values
+	set := func(key string) Handler {
+		return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			r.SetValue(key, r.Questions[0].Name)
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		})
+	}
+
+	mux := new(ResolveMux)
+	mux.Handle(TypeA, "one.dev.", set("one"))
+	mux.Handle(TypeA, "two.dev.", set("two"))
+
+	valc := make(chan [2]interface{}, 1)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		mux.ServeDNS(ctx, w, r)
+
+		valc <- [2]interface{}{r.Value("one"), r.Value("two")}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "one.dev.", Type: TypeA, Class: ClassIN},
+				{Name: "two.dev.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	if _, err := new(Client).Do(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := [2]interface{}{"one.dev.", "two.dev."}, <-valc; want != got {
+		t.Errorf("want values %q, got %q", want, got)
+	}
+}
+
+func TestStaticHandler(t *testing.T) {
+	t.Parallel()
+
+	h := new(StaticHandler)
+	for q, rec := range answers {
+		h.Add(q, Resource{Name: q.Name, TTL: time.Minute, Record: rec})
+	}
+
+	srv := mustServer(h)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		qs []Question
+
+		rcode  RCode
+		answer Record
+	}{
+		{
+			name:   "A",
+			qs:     []Question{questions["A"]},
+			answer: answers[questions["A"]],
+		},
+		{
+			name:   "AAAA",
+			qs:     []Question{questions["AAAA"]},
+			answer: answers[questions["AAAA"]],
+		},
+		{
+			name:   "mixed-case",
+			qs:     []Question{{Name: "a.DEV.", Type: TypeA, Class: ClassIN}},
+			answer: answers[questions["A"]],
+		},
+		{
+			name: "no-data",
+			qs:   []Question{{Name: "A.dev.", Type: TypeAAAA, Class: ClassIN}},
+		},
+		{
+			name:   "partly-unknown",
+			qs:     []Question{questions["A"], {Name: "unknown.dev.", Type: TypeA, Class: ClassIN}},
+			answer: answers[questions["A"]],
+		},
+		{
+			name:  "unknown-name",
+			qs:    []Question{{Name: "unknown.dev.", Type: TypeA, Class: ClassIN}},
+			rcode: NXDomain,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					ID:        0x1234,
+					Questions: test.qs,
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %d, got %d", want, got)
+			}
+			if want, got := query.Questions, msg.Questions; !reflect.DeepEqual(want, got) {
+				t.Errorf("want questions %+v, got %+v", want, got)
+			}
+
+			if test.answer == nil {
+				if want, got := 0, len(msg.Answers); want != got {
+					t.Errorf("want %d answers, got %d", want, got)
+				}
+				return
+			}
+
+			if want, got := 1, len(msg.Answers); want != got {
+				t.Fatalf("want %d answers, got %d", want, got)
+			}
+			if want, got := test.answer, msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answer %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestErrorHandlerFunc(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(ErrorHandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) error {
+		return ExtendedError{InfoCode: 23, ExtraText: "upstream down"}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if want, got := 0, len(msg.Additionals); want != got {
+		t.Errorf("want %d additionals for a non-EDNS query, got %d", want, got)
+	}
+
+	query.SetEDNSFlags(0)
+
+	if msg, err = new(Client).Do(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	_, opt := msg.opt()
+	if opt == nil {
+		t.Fatal("want OPT record")
+	}
+
+	want := []edns.Option{
+		{Code: edns.OptionCodeExtendedDNSError, Data: []byte("\x00\x17upstream down")},
+	}
+	if got := opt.Options; !reflect.DeepEqual(want, got) {
+		t.Errorf("want options %+v, got %+v", want, got)
+	}
+}
+
+func TestErrorHandlerFuncReplied(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(ErrorHandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) error {
+		w.Status(NXDomain)
+		if err := w.Reply(ctx); err != nil {
+			return err
+		}
+		return errors.New("failed after reply")
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := NXDomain, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+}
+
+func TestHealthCheckMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var called int32
+	handler := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&called, 1)
+		w.Status(Refused)
+	})
+
+	mw := HealthCheckMiddleware("health.check.", &TXT{TXT: []string{"ok"}})
+	srv := mustServer(mw(handler))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "Health.Check.", Type: TypeTXT, Class: ClassIN},
+			},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := NoError, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	if want, got := (&TXT{TXT: []string{"ok"}}), msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answer %+v, got %+v", want, got)
+	}
+	if want, got := int32(0), atomic.LoadInt32(&called); want != got {
+		t.Errorf("want %d handler calls, got %d", want, got)
+	}
+
+	// other names reach the handler, even along with the health check name
+	for i, qs := range [][]Question{
+		{{Name: "www.example.com.", Type: TypeTXT, Class: ClassIN}},
+		{
+			{Name: "health.check.", Type: TypeTXT, Class: ClassIN},
+			{Name: "www.example.com.", Type: TypeTXT, Class: ClassIN},
+		},
+	} {
+		query.Questions = qs
+
+		if msg, err = new(Client).Do(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := Refused, msg.RCode; want != got {
+			t.Errorf("want rcode %d, got %d", want, got)
+		}
+		if want, got := int32(i+1), atomic.LoadInt32(&called); want != got {
+			t.Errorf("want %d handler calls, got %d", want, got)
+		}
+	}
+}
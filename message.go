@@ -5,9 +5,15 @@
 package dns
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jjeffcaii/dns/edns"
@@ -32,23 +38,31 @@ type RCode uint16
 // Taken from https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml
 const (
 	// Resource Record (RR) TYPEs
-	TypeA     Type = 1   // [RFC1035] a host address
-	TypeNS    Type = 2   // [RFC1035] an authoritative name server
-	TypeCNAME Type = 5   // [RFC1035] the canonical name for an alias
-	TypeSOA   Type = 6   // [RFC1035] marks the start of a zone of authority
-	TypeWKS   Type = 11  // [RFC1035] a well known service description
-	TypePTR   Type = 12  // [RFC1035] a domain name pointer
-	TypeHINFO Type = 13  // [RFC1035] host information
-	TypeMINFO Type = 14  // [RFC1035] mailbox or mail list information
-	TypeMX    Type = 15  // [RFC1035] mail exchange
-	TypeTXT   Type = 16  // [RFC1035] text strings
-	TypeAAAA  Type = 28  // [RFC3596] IP6 Address
-	TypeSRV   Type = 33  // [RFC2782] Server Selection
-	TypeDNAME Type = 39  // [RFC6672] DNAME
-	TypeOPT   Type = 41  // [RFC6891][RFC3225] OPT
-	TypeAXFR  Type = 252 // [RFC1035][RFC5936] transfer of an entire zone
-	TypeALL   Type = 255 // [RFC1035][RFC6895] A request for all records the server/cache has available
-	TypeCAA   Type = 257 // [RFC6844] Certification Authority Restriction
+	TypeA          Type = 1   // [RFC1035] a host address
+	TypeNS         Type = 2   // [RFC1035] an authoritative name server
+	TypeCNAME      Type = 5   // [RFC1035] the canonical name for an alias
+	TypeSOA        Type = 6   // [RFC1035] marks the start of a zone of authority
+	TypeWKS        Type = 11  // [RFC1035] a well known service description
+	TypePTR        Type = 12  // [RFC1035] a domain name pointer
+	TypeHINFO      Type = 13  // [RFC1035] host information
+	TypeMINFO      Type = 14  // [RFC1035] mailbox or mail list information
+	TypeMX         Type = 15  // [RFC1035] mail exchange
+	TypeTXT        Type = 16  // [RFC1035] text strings
+	TypeGPOS       Type = 27  // [RFC1712] Geographical Position
+	TypeAAAA       Type = 28  // [RFC3596] IP6 Address
+	TypeSRV        Type = 33  // [RFC2782] Server Selection
+	TypeATMA       Type = 34  // [ATMDOC] ATM Address
+	TypeDNAME      Type = 39  // [RFC6672] DNAME
+	TypeOPT        Type = 41  // [RFC6891][RFC3225] OPT
+	TypeRRSIG      Type = 46  // [RFC4034] RRSIG
+	TypeDNSKEY     Type = 48  // [RFC4034] DNSKEY
+	TypeSMIMEA     Type = 53  // [RFC8162] S/MIME cert association
+	TypeOPENPGPKEY Type = 61  // [RFC7929] OpenPGP Key
+	TypeCSYNC      Type = 62  // [RFC7477] Child-To-Parent Synchronization
+	TypeAXFR       Type = 252 // [RFC1035][RFC5936] transfer of an entire zone
+	TypeALL        Type = 255 // [RFC1035][RFC6895] A request for all records the server/cache has available
+	TypeURI        Type = 256 // [RFC7553] URI
+	TypeCAA        Type = 257 // [RFC6844] Certification Authority Restriction
 
 	TypeANY Type = 0
 
@@ -58,31 +72,51 @@ const (
 	ClassHS  Class = 4   // [] Hesiod (HS)
 	ClassANY Class = 255 // [RFC1035] QCLASS * (ANY)
 
+	// DNS OpCodes
+	OpCodeQuery  OpCode = 0 // [RFC1035] Query
+	OpCodeIQuery OpCode = 1 // [RFC3425] Inverse Query, obsolete
+	OpCodeStatus OpCode = 2 // [RFC1035] Status
+	OpCodeNotify OpCode = 4 // [RFC1996] Notify
+	OpCodeUpdate OpCode = 5 // [RFC2136] Update
+
 	// DNS RCODEs
-	NoError  RCode = 0 // [RFC1035] No Error
-	FormErr  RCode = 1 // [RFC1035] Format Error
-	ServFail RCode = 2 // [RFC1035] Server Failure
-	NXDomain RCode = 3 // [RFC1035] Non-Existent Domain
-	NotImp   RCode = 4 // [RFC1035] Not Implemented
-	Refused  RCode = 5 // [RFC1035] Query Refused
+	NoError   RCode = 0  // [RFC1035] No Error
+	FormErr   RCode = 1  // [RFC1035] Format Error
+	ServFail  RCode = 2  // [RFC1035] Server Failure
+	NXDomain  RCode = 3  // [RFC1035] Non-Existent Domain
+	NotImp    RCode = 4  // [RFC1035] Not Implemented
+	Refused   RCode = 5  // [RFC1035] Query Refused
+	BadVers   RCode = 16 // [RFC6891] Bad OPT Version
+	BadCookie RCode = 23 // [RFC7873] Bad/missing Server Cookie
 
 	maxPacketLen = 512
+
+	// maxEDNSPacketLen is the largest UDP payload size used with EDNS
+	// (RFC 6891, section 6.2.5).
+	maxEDNSPacketLen = 4096
 )
 
 // NewRecordByType returns a new instance of a Record for a Type.
 var NewRecordByType = map[Type]func() Record{
-	TypeA:     func() Record { return new(A) },
-	TypeNS:    func() Record { return new(NS) },
-	TypeCNAME: func() Record { return new(CNAME) },
-	TypeSOA:   func() Record { return new(SOA) },
-	TypePTR:   func() Record { return new(PTR) },
-	TypeMX:    func() Record { return new(MX) },
-	TypeTXT:   func() Record { return new(TXT) },
-	TypeAAAA:  func() Record { return new(AAAA) },
-	TypeSRV:   func() Record { return new(SRV) },
-	TypeDNAME: func() Record { return new(DNAME) },
-	TypeOPT:   func() Record { return new(OPT) },
-	TypeCAA:   func() Record { return new(CAA) },
+	TypeA:          func() Record { return new(A) },
+	TypeNS:         func() Record { return new(NS) },
+	TypeCNAME:      func() Record { return new(CNAME) },
+	TypeSOA:        func() Record { return new(SOA) },
+	TypePTR:        func() Record { return new(PTR) },
+	TypeHINFO:      func() Record { return new(HINFO) },
+	TypeMX:         func() Record { return new(MX) },
+	TypeTXT:        func() Record { return new(TXT) },
+	TypeAAAA:       func() Record { return new(AAAA) },
+	TypeSRV:        func() Record { return new(SRV) },
+	TypeDNAME:      func() Record { return new(DNAME) },
+	TypeOPT:        func() Record { return new(OPT) },
+	TypeSMIMEA:     func() Record { return new(SMIMEA) },
+	TypeOPENPGPKEY: func() Record { return new(OPENPGPKEY) },
+	TypeCSYNC:      func() Record { return new(CSYNC) },
+	TypeRRSIG:      func() Record { return new(RRSIG) },
+	TypeDNSKEY:     func() Record { return new(DNSKEY) },
+	TypeURI:        func() Record { return new(URI) },
+	TypeCAA:        func() Record { return new(CAA) },
 }
 
 var (
@@ -110,7 +144,16 @@ var (
 	errTooManyAuthorities = errors.New("too many Authorities to pack (>65535)")
 	errTooManyAdditionals = errors.New("too many Additionals to pack (>65535)")
 	errFieldOverflow      = errors.New("value too large for packed field")
-	errUnknownType        = errors.New("unknown resource type")
+	errExtraBytes         = errors.New("malformed packet, extra message bytes")
+	errNameTooLong        = errors.New("name too long")
+	errInvalidLabel       = errors.New("invalid character in label")
+	errTypeBitmap         = errors.New("invalid type bitmap")
+	errInvalidEmail       = errors.New("invalid email address")
+	errUnexpectedResponse = errors.New("unexpected response message")
+	errSectionCount       = errors.New("fewer records than the header count")
+	errTXTTooLong         = errors.New("TXT character-string too long (>255)")
+	errNoServers          = errors.New("no servers to exchange with")
+	errZeroSigTime        = errors.New("zero RRSIG signature time")
 )
 
 // Message is a DNS message.
@@ -122,12 +165,69 @@ type Message struct {
 	Truncated          bool
 	RecursionDesired   bool
 	RecursionAvailable bool
-	RCode              RCode
+	Zero               bool  // reserved, must be zero in queries and responses
+	AuthenticData      bool  // RFC 4035
+	CheckingDisabled   bool  // RFC 4035
+	RCode              RCode // extended RCODEs (RFC 6891) require an OPT record
 
 	Questions   []Question
 	Answers     []Resource
 	Authorities []Resource
 	Additionals []Resource
+
+	// CompressMaxNames limits the number of distinct names tracked for
+	// compression when packing. Names seen after the limit is reached are
+	// packed uncompressed, but may still point to earlier names. Zero is
+	// unlimited.
+	CompressMaxNames int
+}
+
+// Header is the fixed-size header of a DNS message.
+type Header struct {
+	ID                 int
+	Response           bool
+	OpCode             OpCode
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	Zero               bool
+	AuthenticData      bool
+	CheckingDisabled   bool
+	RCode              RCode // the lower 4 bits of the RCODE
+
+	QDCount int
+	ANCount int
+	NSCount int
+	ARCount int
+}
+
+// DecodeHeader decodes only the header of the message in b, such as for
+// filtering packets before decoding the whole message.
+func DecodeHeader(b []byte) (Header, error) {
+	var m Message
+	if _, err := m.unpackHeader(b); err != nil {
+		return Header{}, err
+	}
+
+	return Header{
+		ID:                 m.ID,
+		Response:           m.Response,
+		OpCode:             m.OpCode,
+		Authoritative:      m.Authoritative,
+		Truncated:          m.Truncated,
+		RecursionDesired:   m.RecursionDesired,
+		RecursionAvailable: m.RecursionAvailable,
+		Zero:               m.Zero,
+		AuthenticData:      m.AuthenticData,
+		CheckingDisabled:   m.CheckingDisabled,
+		RCode:              m.RCode,
+
+		QDCount: int(nbo.Uint16(b[4:])),
+		ANCount: int(nbo.Uint16(b[6:])),
+		NSCount: int(nbo.Uint16(b[8:])),
+		ARCount: int(nbo.Uint16(b[10:])),
+	}, nil
 }
 
 // Pack encodes m as a byte slice. If b is not nil, m is appended into b.
@@ -139,7 +239,11 @@ func (m *Message) Pack(b []byte, compress bool) ([]byte, error) {
 
 	var com Compressor
 	if compress {
-		com = compressor{tbl: make(map[string]int), offset: len(b)}
+		com = compressor{
+			tbl:    make(map[string]int),
+			offset: len(b),
+			max:    m.CompressMaxNames,
+		}
 	}
 
 	var err error
@@ -155,6 +259,10 @@ func (m *Message) Pack(b []byte, compress bool) ([]byte, error) {
 
 	for _, rs := range [3][]Resource{m.Answers, m.Authorities, m.Additionals} {
 		for _, r := range rs {
+			if _, ok := r.Record.(*OPT); ok {
+				r.TTL = optTTL(r.TTL, m.RCode)
+			}
+
 			if b, err = r.Pack(b, com); err != nil {
 				return nil, err
 			}
@@ -164,37 +272,63 @@ func (m *Message) Pack(b []byte, compress bool) ([]byte, error) {
 	return b, nil
 }
 
-// Unpack decodes m from b. Unused bytes are returned.
+// Unpack decodes m from b. Unused bytes are returned. A message with fewer
+// questions or records than the counts of its header fails to decode with an
+// error, with the questions and records decoded before the error kept in m.
 func (m *Message) Unpack(b []byte) ([]byte, error) {
 	dec := decompressor(b)
 
+	hdr := b
 	var err error
 	if b, err = m.unpackHeader(b); err != nil {
 		return nil, err
 	}
 
-	for i := 0; i < cap(m.Questions); i++ {
+	var (
+		qdcount = int(nbo.Uint16(hdr[4:]))
+		ancount = int(nbo.Uint16(hdr[6:]))
+		nscount = int(nbo.Uint16(hdr[8:]))
+		arcount = int(nbo.Uint16(hdr[10:]))
+	)
+
+	for i := 0; i < qdcount; i++ {
+		if len(b) == 0 {
+			return nil, errSectionCount
+		}
+
 		var q Question
 		if b, err = q.Unpack(b, dec); err != nil {
 			return nil, err
 		}
 		m.Questions = append(m.Questions, q)
 	}
-	for i := 0; i < cap(m.Answers); i++ {
+	for i := 0; i < ancount; i++ {
+		if len(b) == 0 {
+			return nil, errSectionCount
+		}
+
 		var r Resource
 		if b, err = r.Unpack(b, dec); err != nil {
 			return nil, err
 		}
 		m.Answers = append(m.Answers, r)
 	}
-	for i := 0; i < cap(m.Authorities); i++ {
+	for i := 0; i < nscount; i++ {
+		if len(b) == 0 {
+			return nil, errSectionCount
+		}
+
 		var r Resource
 		if b, err = r.Unpack(b, dec); err != nil {
 			return nil, err
 		}
 		m.Authorities = append(m.Authorities, r)
 	}
-	for i := 0; i < cap(m.Additionals); i++ {
+	for i := 0; i < arcount; i++ {
+		if len(b) == 0 {
+			return nil, errSectionCount
+		}
+
 		var r Resource
 		if b, err = r.Unpack(b, dec); err != nil {
 			return nil, err
@@ -202,17 +336,154 @@ func (m *Message) Unpack(b []byte) ([]byte, error) {
 		m.Additionals = append(m.Additionals, r)
 	}
 
+	if res, _ := m.opt(); res != nil {
+		m.RCode |= RCode(uint32(res.TTL/time.Second)>>24) << 4
+	}
+
 	return b, nil
 }
 
+// UnmarshalPartial decodes the message in b, and returns the message with the
+// header and records decoded before any error, such as for inspecting
+// malformed traffic. The error is nil only if b holds exactly one well-formed
+// message.
+func UnmarshalPartial(b []byte) (*Message, error) {
+	msg := new(Message)
+	return msg, unpackAll(msg, b)
+}
+
+// SOA returns the first SOA record in the authority section of m, such as
+// for computing the negative caching TTL of an NXDOMAIN or NODATA response.
+func (m *Message) SOA() (Resource, bool) {
+	for _, res := range m.Authorities {
+		if _, ok := res.Record.(*SOA); ok {
+			return res, true
+		}
+	}
+	return Resource{}, false
+}
+
+// MinTTL returns the lowest TTL of the records of m, excluding OPT records.
+// For a negative response without answers, it returns the negative caching
+// TTL: the lower of the TTL and the MINIMUM field of the SOA record, as
+// described in RFC 2308, section 5. It returns zero if m has no records.
+func (m *Message) MinTTL() time.Duration {
+	if soa, ok := m.SOA(); ok && len(m.Answers) == 0 {
+		if min := soa.Record.(*SOA).MinTTL; min < soa.TTL {
+			return min
+		}
+		return soa.TTL
+	}
+
+	var (
+		min  time.Duration
+		seen bool
+	)
+	m.EachResource(func(_ Section, res *Resource) {
+		if res.Record.Type() == TypeOPT {
+			return
+		}
+		if !seen || res.TTL < min {
+			min, seen = res.TTL, true
+		}
+	})
+	return min
+}
+
+// A Section is a resource record section of a message.
+type Section int
+
+const (
+	SectionAnswer     Section = iota + 1 // answer section
+	SectionAuthority                     // authority section
+	SectionAdditional                    // additional section
+)
+
+func (s Section) String() string {
+	switch s {
+	case SectionAnswer:
+		return "ANSWER"
+	case SectionAuthority:
+		return "AUTHORITY"
+	case SectionAdditional:
+		return "ADDITIONAL"
+	default:
+		return "Section(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// EachResource calls fn for each resource record of m, in the order of the
+// answer, authority, and additional sections. The record may be modified in
+// place by fn.
+func (m *Message) EachResource(fn func(Section, *Resource)) {
+	for i := range m.Answers {
+		fn(SectionAnswer, &m.Answers[i])
+	}
+	for i := range m.Authorities {
+		fn(SectionAuthority, &m.Authorities[i])
+	}
+	for i := range m.Additionals {
+		fn(SectionAdditional, &m.Additionals[i])
+	}
+}
+
 const (
 	headerBitQR = 1 << 15 // query/response (response=1)
 	headerBitAA = 1 << 10 // authoritative
 	headerBitTC = 1 << 9  // truncated
 	headerBitRD = 1 << 8  // recursion desired
 	headerBitRA = 1 << 7  // recursion available
+	headerBitZ  = 1 << 6  // reserved
+	headerBitAD = 1 << 5  // authentic data
+	headerBitCD = 1 << 4  // checking disabled
 )
 
+// Flags returns the 16-bit flags word of the header of m, holding the QR,
+// OPCODE, AA, TC, RD, RA, Z, AD, CD, and RCODE fields. Only the lower 4 bits of
+// the OpCode and RCode are included.
+func (m *Message) Flags() uint16 {
+	bits := uint16(m.OpCode&0x0F)<<11 | uint16(m.RCode&0x0F)
+	if m.Response {
+		bits |= headerBitQR
+	}
+	if m.Authoritative {
+		bits |= headerBitAA
+	}
+	if m.Truncated {
+		bits |= headerBitTC
+	}
+	if m.RecursionDesired {
+		bits |= headerBitRD
+	}
+	if m.RecursionAvailable {
+		bits |= headerBitRA
+	}
+	if m.Zero {
+		bits |= headerBitZ
+	}
+	if m.AuthenticData {
+		bits |= headerBitAD
+	}
+	if m.CheckingDisabled {
+		bits |= headerBitCD
+	}
+	return bits
+}
+
+// SetFlags sets the header fields of m from the 16-bit flags word bits.
+func (m *Message) SetFlags(bits uint16) {
+	m.Response = (bits & headerBitQR) > 0
+	m.OpCode = OpCode(bits>>11) & 0xF
+	m.Authoritative = (bits & headerBitAA) > 0
+	m.Truncated = (bits & headerBitTC) > 0
+	m.RecursionDesired = (bits & headerBitRD) > 0
+	m.RecursionAvailable = (bits & headerBitRA) > 0
+	m.Zero = (bits & headerBitZ) > 0
+	m.AuthenticData = (bits & headerBitAD) > 0
+	m.CheckingDisabled = (bits & headerBitCD) > 0
+	m.RCode = RCode(bits) & 0xF
+}
+
 func (m *Message) packHeader(b []byte) ([]byte, error) {
 	id := uint16(m.ID)
 	if int(id) != m.ID {
@@ -224,28 +495,16 @@ func (m *Message) packHeader(b []byte) ([]byte, error) {
 		return nil, errFieldOverflow
 	}
 
-	rcode := m.RCode & 0x0F
-	if rcode != m.RCode {
+	// The upper 8 bits of an extended RCode are packed in the OPT record.
+	if m.RCode > 0xFFF {
 		return nil, errFieldOverflow
 	}
-
-	bits := uint16(opcode)<<11 | uint16(rcode)
-	if m.Response {
-		bits |= headerBitQR
-	}
-	if m.RecursionAvailable {
-		bits |= headerBitRA
-	}
-	if m.RecursionDesired {
-		bits |= headerBitRD
-	}
-	if m.Truncated {
-		bits |= headerBitTC
-	}
-	if m.Authoritative {
-		bits |= headerBitAA
+	if res, _ := m.opt(); res == nil && m.RCode > 0xF {
+		return nil, errFieldOverflow
 	}
 
+	bits := m.Flags()
+
 	qdcount := uint16(len(m.Questions))
 	if int(qdcount) != len(m.Questions) {
 		return nil, errTooManyQuestions
@@ -262,8 +521,8 @@ func (m *Message) packHeader(b []byte) ([]byte, error) {
 	}
 
 	arcount := uint16(len(m.Additionals))
-	if int(nscount) != len(m.Authorities) {
-		return nil, errTooManyAuthorities
+	if int(arcount) != len(m.Additionals) {
+		return nil, errTooManyAdditionals
 	}
 
 	buf := [12]byte{}
@@ -290,33 +549,42 @@ func (m *Message) unpackHeader(b []byte) ([]byte, error) {
 		arcount = nbo.Uint16(b[10:])
 	)
 
-	*m = Message{
-		ID:                 id,
-		Response:           (bits & headerBitQR) > 0,
-		OpCode:             OpCode(bits>>11) & 0xF,
-		Authoritative:      (bits & headerBitAA) > 0,
-		Truncated:          (bits & headerBitTC) > 0,
-		RecursionDesired:   (bits & headerBitRD) > 0,
-		RecursionAvailable: (bits & headerBitRA) > 0,
-		RCode:              RCode(bits) & 0xF,
-	}
+	*m = Message{ID: id}
+	m.SetFlags(bits)
 
+	// the sections are allocated for no more entries than the rest of the
+	// message can hold, so that bogus counts do not allocate large slices
+	n := len(b) - 12
 	if qdcount > 0 {
-		m.Questions = make([]Question, 0, qdcount)
+		m.Questions = make([]Question, 0, minCount(qdcount, n/minQuestionLen))
 	}
 	if ancount > 0 {
-		m.Answers = make([]Resource, 0, ancount)
+		m.Answers = make([]Resource, 0, minCount(ancount, n/minResourceLen))
 	}
 	if nscount > 0 {
-		m.Authorities = make([]Resource, 0, nscount)
+		m.Authorities = make([]Resource, 0, minCount(nscount, n/minResourceLen))
 	}
 	if arcount > 0 {
-		m.Additionals = make([]Resource, 0, arcount)
+		m.Additionals = make([]Resource, 0, minCount(arcount, n/minResourceLen))
 	}
 
 	return b[12:], nil
 }
 
+// The encoded lengths of the shortest question and resource record, with the
+// root name.
+const (
+	minQuestionLen = 1 + 4
+	minResourceLen = 1 + 10
+)
+
+func minCount(count uint16, max int) int {
+	if int(count) < max {
+		return int(count)
+	}
+	return max
+}
+
 // A Question is a DNS query.
 type Question struct {
 	Name  string
@@ -384,9 +652,9 @@ func (r Resource) Pack(b []byte, com Compressor) ([]byte, error) {
 
 	rtype := r.Record.Type()
 
-	ttl := uint32(r.TTL / time.Second)
-	if time.Duration(ttl) != r.TTL/time.Second {
-		return nil, errFieldOverflow
+	ttl, err := packTTL(rtype, r.TTL)
+	if err != nil {
+		return nil, err
 	}
 
 	rlen, err := r.Record.Length(com)
@@ -409,6 +677,31 @@ func (r Resource) Pack(b []byte, com Compressor) ([]byte, error) {
 	return r.Record.Pack(b, com)
 }
 
+// maxTTL is the largest TTL packed, as recommended by RFC 2181, section 8.
+const maxTTL = 1<<31 - 1
+
+// packTTL converts the TTL of a resource to unsigned seconds. Negative TTLs
+// are packed as zero, and large TTLs as maxTTL. The TTL of an OPT pseudo-RR
+// holds flags, and must fit in 32 bits.
+func packTTL(rtype Type, ttl time.Duration) (uint32, error) {
+	secs := ttl / time.Second
+	if rtype == TypeOPT {
+		if secs < 0 || secs > 1<<32-1 {
+			return 0, errFieldOverflow
+		}
+		return uint32(secs), nil
+	}
+
+	switch {
+	case secs < 0:
+		return 0, nil
+	case secs > maxTTL:
+		return maxTTL, nil
+	default:
+		return uint32(secs), nil
+	}
+}
+
 // Unpack decodes r from b.
 func (r *Resource) Unpack(b []byte, dec Decompressor) ([]byte, error) {
 	var err error
@@ -429,12 +722,11 @@ func (r *Resource) Unpack(b []byte, dec Decompressor) ([]byte, error) {
 		return nil, errResourceLen
 	}
 
-	newfn, ok := NewRecordByType[rtype]
-	if !ok {
-		return nil, errUnknownType
+	var record Record = &UnknownRecord{RRType: rtype}
+	if newfn, ok := NewRecordByType[rtype]; ok {
+		record = newfn()
 	}
 
-	record := newfn()
 	buf, err := record.Unpack(b[:rdlen], dec)
 	if err != nil {
 		return nil, err
@@ -453,6 +745,9 @@ type Record interface {
 	Length(Compressor) (int, error)
 	Pack([]byte, Compressor) ([]byte, error)
 	Unpack([]byte, Decompressor) ([]byte, error)
+
+	// RData returns the RDATA in presentation format.
+	RData() string
 }
 
 // A A is a DNS A record.
@@ -463,6 +758,11 @@ type A struct {
 // Type returns the RR type identifier.
 func (A) Type() Type { return TypeA }
 
+// RData encodes a as RDATA in presentation format.
+func (a A) RData() string {
+	return a.A.String()
+}
+
 // Length returns the encoded RDATA size.
 func (A) Length(Compressor) (int, error) { return 4, nil }
 
@@ -495,6 +795,11 @@ type AAAA struct {
 // Type returns the RR type identifier.
 func (AAAA) Type() Type { return TypeAAAA }
 
+// RData encodes a as RDATA in presentation format.
+func (a AAAA) RData() string {
+	return a.AAAA.String()
+}
+
 // Length returns the encoded RDATA size.
 func (AAAA) Length(Compressor) (int, error) { return 16, nil }
 
@@ -527,6 +832,11 @@ type CNAME struct {
 // Type returns the RR type identifier.
 func (CNAME) Type() Type { return TypeCNAME }
 
+// RData encodes c as RDATA in presentation format.
+func (c CNAME) RData() string {
+	return c.CNAME
+}
+
 // Length returns the encoded RDATA size.
 func (c CNAME) Length(com Compressor) (int, error) {
 	return com.Length(c.CNAME)
@@ -558,6 +868,13 @@ type SOA struct {
 // Type returns the RR type identifier.
 func (SOA) Type() Type { return TypeSOA }
 
+// RData encodes s as RDATA in presentation format.
+func (s SOA) RData() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", s.NS, s.MBox, s.Serial,
+		int(s.Refresh/time.Second), int(s.Retry/time.Second),
+		int(s.Expire/time.Second), int(s.MinTTL/time.Second))
+}
+
 // Length returns the encoded RDATA size.
 func (s SOA) Length(com Compressor) (int, error) {
 	n, err := com.Length(s.NS, s.MBox)
@@ -650,6 +967,11 @@ type PTR struct {
 // Type returns the RR type identifier.
 func (PTR) Type() Type { return TypePTR }
 
+// RData encodes p as RDATA in presentation format.
+func (p PTR) RData() string {
+	return p.PTR
+}
+
 // Length returns the encoded RDATA size.
 func (p PTR) Length(com Compressor) (int, error) {
 	return com.Length(p.PTR)
@@ -667,6 +989,53 @@ func (p *PTR) Unpack(b []byte, dec Decompressor) ([]byte, error) {
 	return b, err
 }
 
+// HINFO is a DNS HINFO record.
+type HINFO struct {
+	CPU string
+	OS  string
+}
+
+// Type returns the RR type identifier.
+func (HINFO) Type() Type { return TypeHINFO }
+
+// RData encodes h as RDATA in presentation format.
+func (h HINFO) RData() string {
+	return quoteString(h.CPU) + " " + quoteString(h.OS)
+}
+
+// Length returns the encoded RDATA size.
+func (h HINFO) Length(_ Compressor) (int, error) {
+	return 2 + len(h.CPU) + len(h.OS), nil
+}
+
+// Pack encodes h as RDATA.
+func (h HINFO) Pack(b []byte, _ Compressor) ([]byte, error) {
+	for _, s := range [2]string{h.CPU, h.OS} {
+		if len(s) > 255 {
+			return nil, errSegTooLong
+		}
+
+		b = append(append(b, byte(len(s))), []byte(s)...)
+	}
+	return b, nil
+}
+
+// Unpack decodes h from RDATA in b.
+func (h *HINFO) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	var strs [2]string
+	for i := range strs {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return nil, errResourceLen
+		}
+
+		strs[i] = string(b[1 : 1+int(b[0])])
+		b = b[1+int(b[0]):]
+	}
+
+	h.CPU, h.OS = strs[0], strs[1]
+	return b, nil
+}
+
 // MX is a DNS MX record.
 type MX struct {
 	Pref int
@@ -676,6 +1045,11 @@ type MX struct {
 // Type returns the RR type identifier.
 func (MX) Type() Type { return TypeMX }
 
+// RData encodes m as RDATA in presentation format.
+func (m MX) RData() string {
+	return strconv.Itoa(m.Pref) + " " + m.MX
+}
+
 // Length returns the encoded RDATA size.
 func (m MX) Length(com Compressor) (int, error) {
 	n, err := com.Length(m.MX)
@@ -719,6 +1093,11 @@ type NS struct {
 // Type returns the RR type identifier.
 func (NS) Type() Type { return TypeNS }
 
+// RData encodes n as RDATA in presentation format.
+func (n NS) RData() string {
+	return n.NS
+}
+
 // Length returns the encoded RDATA size.
 func (n NS) Length(com Compressor) (int, error) {
 	return com.Length(n.NS)
@@ -744,6 +1123,15 @@ type TXT struct {
 // Type returns the RR type identifier.
 func (TXT) Type() Type { return TypeTXT }
 
+// RData encodes t as RDATA in presentation format.
+func (t TXT) RData() string {
+	strs := make([]string, len(t.TXT))
+	for i, txt := range t.TXT {
+		strs[i] = quoteString(txt)
+	}
+	return strings.Join(strs, " ")
+}
+
 // Length returns the encoded RDATA size.
 func (t TXT) Length(_ Compressor) (int, error) {
 	var n int
@@ -757,7 +1145,7 @@ func (t TXT) Length(_ Compressor) (int, error) {
 func (t TXT) Pack(b []byte, _ Compressor) ([]byte, error) {
 	for _, s := range t.TXT {
 		if len(s) > 255 {
-			return nil, errSegTooLong
+			return nil, errTXTTooLong
 		}
 
 		b = append(append(b, byte(len(s))), []byte(s)...)
@@ -793,6 +1181,11 @@ type SRV struct {
 // Type returns the RR type identifier.
 func (SRV) Type() Type { return TypeSRV }
 
+// RData encodes s as RDATA in presentation format.
+func (s SRV) RData() string {
+	return fmt.Sprintf("%d %d %d %s", s.Priority, s.Weight, s.Port, s.Target)
+}
+
 // Length returns the encoded RDATA size.
 func (s SRV) Length(_ Compressor) (int, error) {
 	n, err := compressor{}.Length(s.Target)
@@ -828,8 +1221,9 @@ func (s SRV) Pack(b []byte, _ Compressor) ([]byte, error) {
 	return compressor{}.Pack(append(b, buf[:]...), s.Target)
 }
 
-// Unpack decodes s from RDATA in b.
-func (s *SRV) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+// Unpack decodes s from RDATA in b. A compressed target is accepted, although
+// RFC 2782 forbids compressing it.
+func (s *SRV) Unpack(b []byte, dec Decompressor) ([]byte, error) {
 	if len(b) < 6 {
 		return nil, errResourceLen
 	}
@@ -839,7 +1233,7 @@ func (s *SRV) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 	s.Port = int(nbo.Uint16(b[4:6]))
 
 	var err error
-	s.Target, b, err = decompressor(nil).Unpack(b[6:])
+	s.Target, b, err = dec.Unpack(b[6:])
 	return b, err
 }
 
@@ -851,14 +1245,20 @@ type DNAME struct {
 // Type returns the RR type identifier.
 func (DNAME) Type() Type { return TypeDNAME }
 
+// RData encodes d as RDATA in presentation format.
+func (d DNAME) RData() string {
+	return d.DNAME
+}
+
 // Length returns the encoded RDATA size.
-func (d DNAME) Length(com Compressor) (int, error) {
-	return com.Length(d.DNAME)
+func (d DNAME) Length(_ Compressor) (int, error) {
+	return compressor{}.Length(d.DNAME)
 }
 
-// Pack encodes c as RDATA.
-func (d DNAME) Pack(b []byte, com Compressor) ([]byte, error) {
-	return com.Pack(b, d.DNAME)
+// Pack encodes d as RDATA. The target is not compressed, as required by RFC
+// 6672, section 2.5.
+func (d DNAME) Pack(b []byte, _ Compressor) ([]byte, error) {
+	return compressor{}.Pack(b, d.DNAME)
 }
 
 // Unpack decodes c from RDATA in b.
@@ -876,6 +1276,15 @@ type OPT struct {
 // Type returns the RR type identifier.
 func (o OPT) Type() Type { return TypeOPT }
 
+// RData encodes o as RDATA in presentation format.
+func (o OPT) RData() string {
+	opts := make([]string, len(o.Options))
+	for i, opt := range o.Options {
+		opts[i] = strconv.Itoa(int(opt.Code)) + ":" + hex.EncodeToString(opt.Data)
+	}
+	return strings.Join(opts, " ")
+}
+
 // Length returns the encoded RDATA size.
 func (o OPT) Length(_ Compressor) (int, error) {
 	var n int
@@ -911,6 +1320,383 @@ func (o *OPT) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 	return b, nil
 }
 
+// SMIMEA is a DNS SMIMEA record. It has the same format as a TLSA record.
+type SMIMEA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Certificate  []byte
+}
+
+// Type returns the RR type identifier.
+func (SMIMEA) Type() Type { return TypeSMIMEA }
+
+// RData encodes s as RDATA in presentation format.
+func (s SMIMEA) RData() string {
+	return fmt.Sprintf("%d %d %d %X", s.Usage, s.Selector, s.MatchingType, s.Certificate)
+}
+
+// Length returns the encoded RDATA size.
+func (s SMIMEA) Length(_ Compressor) (int, error) {
+	return 3 + len(s.Certificate), nil
+}
+
+// Pack encodes s as RDATA.
+func (s SMIMEA) Pack(b []byte, _ Compressor) ([]byte, error) {
+	return append(append(b, s.Usage, s.Selector, s.MatchingType), s.Certificate...), nil
+}
+
+// Unpack decodes s from RDATA in b.
+func (s *SMIMEA) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	if len(b) < 3 {
+		return nil, errResourceLen
+	}
+
+	s.Usage, s.Selector, s.MatchingType = b[0], b[1], b[2]
+	s.Certificate = append([]byte(nil), b[3:]...)
+
+	return nil, nil
+}
+
+// SMIMEAName returns the owner name of the SMIMEA records for an email
+// address, as described in RFC 8162, section 3.
+func SMIMEAName(email string) (string, error) {
+	return emailOwnerName(email, "_smimecert")
+}
+
+// OPENPGPKEY is a DNS OPENPGPKEY record.
+type OPENPGPKEY struct {
+	PublicKey []byte
+}
+
+// Type returns the RR type identifier.
+func (OPENPGPKEY) Type() Type { return TypeOPENPGPKEY }
+
+// RData encodes o as RDATA in presentation format.
+func (o OPENPGPKEY) RData() string {
+	return base64.StdEncoding.EncodeToString(o.PublicKey)
+}
+
+// Length returns the encoded RDATA size.
+func (o OPENPGPKEY) Length(_ Compressor) (int, error) {
+	return len(o.PublicKey), nil
+}
+
+// Pack encodes o as RDATA.
+func (o OPENPGPKEY) Pack(b []byte, _ Compressor) ([]byte, error) {
+	return append(b, o.PublicKey...), nil
+}
+
+// Unpack decodes o from RDATA in b.
+func (o *OPENPGPKEY) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	o.PublicKey = append([]byte(nil), b...)
+
+	return nil, nil
+}
+
+// OPENPGPKEYName returns the owner name of the OPENPGPKEY records for an
+// email address, as described in RFC 7929, section 3.
+func OPENPGPKEYName(email string) (string, error) {
+	return emailOwnerName(email, "_openpgpkey")
+}
+
+// emailOwnerName returns the owner name for email under the label:
+// the truncated SHA-256 hash of the local-part, the label, and the domain.
+func emailOwnerName(email, label string) (string, error) {
+	i := strings.LastIndexByte(email, '@')
+	if i <= 0 || i == len(email)-1 {
+		return "", errInvalidEmail
+	}
+
+	local, domain := email[:i], email[i+1:]
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+
+	sum := sha256.Sum256([]byte(local))
+	return hex.EncodeToString(sum[:28]) + "." + label + "." + domain, nil
+}
+
+// CSYNC is a DNS CSYNC record.
+type CSYNC struct {
+	SOASerial uint32
+	Flags     uint16
+	Types     []Type
+}
+
+// CSYNC flags.
+const (
+	CSYNCImmediate  = 1 << 0
+	CSYNCSOAMinimum = 1 << 1
+)
+
+// Type returns the RR type identifier.
+func (CSYNC) Type() Type { return TypeCSYNC }
+
+// RData encodes c as RDATA in presentation format.
+func (c CSYNC) RData() string {
+	strs := []string{strconv.FormatUint(uint64(c.SOASerial), 10), strconv.Itoa(int(c.Flags))}
+	for _, t := range c.Types {
+		strs = append(strs, typeName(t))
+	}
+	return strings.Join(strs, " ")
+}
+
+// Length returns the encoded RDATA size.
+func (c CSYNC) Length(_ Compressor) (int, error) {
+	return 6 + typeBitmapLen(c.Types), nil
+}
+
+// Pack encodes c as RDATA.
+func (c CSYNC) Pack(b []byte, _ Compressor) ([]byte, error) {
+	buf := [6]byte{}
+	nbo.PutUint32(buf[:4], c.SOASerial)
+	nbo.PutUint16(buf[4:], c.Flags)
+
+	return packTypeBitmap(append(b, buf[:]...), c.Types), nil
+}
+
+// Unpack decodes c from RDATA in b.
+func (c *CSYNC) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	if len(b) < 6 {
+		return nil, errResourceLen
+	}
+
+	c.SOASerial = nbo.Uint32(b[:4])
+	c.Flags = nbo.Uint16(b[4:6])
+
+	var err error
+	c.Types, err = unpackTypeBitmap(b[6:])
+	return nil, err
+}
+
+// RRSIG is a DNS RRSIG record, as defined in RFC 4034, section 3.
+type RRSIG struct {
+	TypeCovered Type
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL time.Duration
+	Expiration  time.Time
+	Inception   time.Time
+	KeyTag      uint16
+	SignerName  string // Not compressed as per RFC 4034.
+	Signature   []byte
+}
+
+// Type returns the RR type identifier.
+func (RRSIG) Type() Type { return TypeRRSIG }
+
+// RData encodes r as RDATA in presentation format.
+func (r RRSIG) RData() string {
+	const layout = "20060102150405"
+
+	return fmt.Sprintf("%s %d %d %d %s %s %d %s %s", typeName(r.TypeCovered),
+		r.Algorithm, r.Labels, int64(r.OriginalTTL/time.Second),
+		r.Expiration.UTC().Format(layout), r.Inception.UTC().Format(layout),
+		r.KeyTag, r.SignerName, base64.StdEncoding.EncodeToString(r.Signature))
+}
+
+// Length returns the encoded RDATA size.
+func (r RRSIG) Length(_ Compressor) (int, error) {
+	n, err := compressor{}.Length(r.SignerName)
+	if err != nil {
+		return 0, err
+	}
+	return 18 + n + len(r.Signature), nil
+}
+
+// Pack encodes r as RDATA. The Expiration and Inception times must be set,
+// and within the range of 32-bit seconds since the Unix epoch.
+func (r RRSIG) Pack(b []byte, _ Compressor) ([]byte, error) {
+	ttl := r.OriginalTTL / time.Second
+	if ttl < 0 || int64(uint32(ttl)) != int64(ttl) {
+		return nil, errFieldOverflow
+	}
+
+	var times [2]uint32
+	for i, t := range []time.Time{r.Expiration, r.Inception} {
+		if t.IsZero() {
+			return nil, errZeroSigTime
+		}
+		if sec := t.Unix(); sec < 0 || int64(uint32(sec)) != sec {
+			return nil, errFieldOverflow
+		}
+		times[i] = uint32(t.Unix())
+	}
+
+	buf := [18]byte{}
+	nbo.PutUint16(buf[:2], uint16(r.TypeCovered))
+	buf[2] = r.Algorithm
+	buf[3] = r.Labels
+	nbo.PutUint32(buf[4:8], uint32(ttl))
+	nbo.PutUint32(buf[8:12], times[0])
+	nbo.PutUint32(buf[12:16], times[1])
+	nbo.PutUint16(buf[16:], r.KeyTag)
+
+	b, err := compressor{}.Pack(append(b, buf[:]...), r.SignerName)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, r.Signature...), nil
+}
+
+// Unpack decodes r from RDATA in b. The signature times are read as seconds
+// since the Unix epoch, without the serial number arithmetic of RFC 4034,
+// section 3.1.5, so times past 2106 do not round-trip.
+func (r *RRSIG) Unpack(b []byte, dec Decompressor) ([]byte, error) {
+	if len(b) < 18 {
+		return nil, errResourceLen
+	}
+
+	r.TypeCovered = Type(nbo.Uint16(b[:2]))
+	r.Algorithm = b[2]
+	r.Labels = b[3]
+	r.OriginalTTL = time.Duration(nbo.Uint32(b[4:8])) * time.Second
+	r.Expiration = time.Unix(int64(nbo.Uint32(b[8:12])), 0).UTC()
+	r.Inception = time.Unix(int64(nbo.Uint32(b[12:16])), 0).UTC()
+	r.KeyTag = nbo.Uint16(b[16:18])
+
+	var err error
+	if r.SignerName, b, err = dec.Unpack(b[18:]); err != nil {
+		return nil, err
+	}
+	r.Signature = append([]byte(nil), b...)
+
+	return nil, nil
+}
+
+// DNSKEY is a DNS DNSKEY record, as defined in RFC 4034, section 2.
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// DNSKEY flags.
+const (
+	DNSKEYSecureEntryPoint = 1 << 0
+	DNSKEYRevoke           = 1 << 7
+	DNSKEYZone             = 1 << 8
+)
+
+// Type returns the RR type identifier.
+func (DNSKEY) Type() Type { return TypeDNSKEY }
+
+// RData encodes d as RDATA in presentation format.
+func (d DNSKEY) RData() string {
+	return fmt.Sprintf("%d %d %d %s", d.Flags, d.Protocol, d.Algorithm,
+		base64.StdEncoding.EncodeToString(d.PublicKey))
+}
+
+// Length returns the encoded RDATA size.
+func (d DNSKEY) Length(_ Compressor) (int, error) {
+	return 4 + len(d.PublicKey), nil
+}
+
+// Pack encodes d as RDATA.
+func (d DNSKEY) Pack(b []byte, _ Compressor) ([]byte, error) {
+	buf := [4]byte{}
+	nbo.PutUint16(buf[:2], d.Flags)
+	buf[2] = d.Protocol
+	buf[3] = d.Algorithm
+
+	return append(append(b, buf[:]...), d.PublicKey...), nil
+}
+
+// Unpack decodes d from RDATA in b.
+func (d *DNSKEY) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errResourceLen
+	}
+
+	d.Flags = nbo.Uint16(b[:2])
+	d.Protocol = b[2]
+	d.Algorithm = b[3]
+	d.PublicKey = append([]byte(nil), b[4:]...)
+
+	return nil, nil
+}
+
+// URI is a DNS URI record.
+type URI struct {
+	Priority uint16
+	Weight   uint16
+	Target   string // The URI, not a domain name, as per RFC 7553.
+}
+
+// Type returns the RR type identifier.
+func (URI) Type() Type { return TypeURI }
+
+// RData encodes u as RDATA in presentation format.
+func (u URI) RData() string {
+	return fmt.Sprintf("%d %d %s", u.Priority, u.Weight, quoteString(u.Target))
+}
+
+// Length returns the encoded RDATA size.
+func (u URI) Length(_ Compressor) (int, error) {
+	return 4 + len(u.Target), nil
+}
+
+// Pack encodes u as RDATA.
+func (u URI) Pack(b []byte, _ Compressor) ([]byte, error) {
+	buf := [4]byte{}
+	nbo.PutUint16(buf[:2], u.Priority)
+	nbo.PutUint16(buf[2:], u.Weight)
+
+	return append(append(b, buf[:]...), u.Target...), nil
+}
+
+// Unpack decodes u from RDATA in b.
+func (u *URI) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errResourceLen
+	}
+
+	u.Priority = nbo.Uint16(b[:2])
+	u.Weight = nbo.Uint16(b[2:4])
+	u.Target = string(b[4:])
+
+	return nil, nil
+}
+
+// UnknownRecord is a DNS record of a type without a registered Record
+// implementation, such as GPOS. The RDATA is kept opaque, as described in
+// RFC 3597.
+type UnknownRecord struct {
+	RRType Type
+	Data   []byte
+}
+
+// Type returns the RR type identifier.
+func (u UnknownRecord) Type() Type { return u.RRType }
+
+// RData encodes u as RDATA in the generic presentation format of RFC 3597,
+// section 5.
+func (u UnknownRecord) RData() string {
+	if len(u.Data) == 0 {
+		return `\# 0`
+	}
+	return `\# ` + strconv.Itoa(len(u.Data)) + " " + hex.EncodeToString(u.Data)
+}
+
+// Length returns the encoded RDATA size.
+func (u UnknownRecord) Length(_ Compressor) (int, error) {
+	return len(u.Data), nil
+}
+
+// Pack encodes u as RDATA.
+func (u UnknownRecord) Pack(b []byte, _ Compressor) ([]byte, error) {
+	return append(b, u.Data...), nil
+}
+
+// Unpack decodes u from RDATA in b.
+func (u *UnknownRecord) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	u.Data = append([]byte(nil), b...)
+
+	return nil, nil
+}
+
 // type CAA is a DNS CAA record.
 type CAA struct {
 	IssuerCritical bool
@@ -922,6 +1708,15 @@ type CAA struct {
 // Type returns the RR type identifier.
 func (CAA) Type() Type { return TypeCAA }
 
+// RData encodes c as RDATA in presentation format.
+func (c CAA) RData() string {
+	var flags int
+	if c.IssuerCritical {
+		flags = 128
+	}
+	return fmt.Sprintf("%d %s %s", flags, c.Tag, quoteString(c.Value))
+}
+
 // Length returns the encoded RDATA size.
 func (c CAA) Length(_ Compressor) (int, error) {
 	return 2 + len(c.Tag) + len(c.Value), nil
@@ -973,3 +1768,52 @@ func (c *CAA) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 
 	return nil, nil
 }
+
+// quoteString returns s as a quoted character-string in presentation format,
+// escaping quotes, backslashes, and non-printable bytes.
+func quoteString(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < ' ' || c > '~':
+			b = append(b, fmt.Sprintf("\\%03d", c)...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(append(b, '"'))
+}
+
+// typeName returns the mnemonic of t, or the generic TYPEnnn name of RFC
+// 3597 for unknown types.
+func typeName(t Type) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+var typeNames = map[Type]string{
+	TypeA:          "A",
+	TypeNS:         "NS",
+	TypeCNAME:      "CNAME",
+	TypeSOA:        "SOA",
+	TypePTR:        "PTR",
+	TypeHINFO:      "HINFO",
+	TypeMX:         "MX",
+	TypeTXT:        "TXT",
+	TypeAAAA:       "AAAA",
+	TypeSRV:        "SRV",
+	TypeDNAME:      "DNAME",
+	TypeOPT:        "OPT",
+	TypeRRSIG:      "RRSIG",
+	TypeDNSKEY:     "DNSKEY",
+	TypeSMIMEA:     "SMIMEA",
+	TypeOPENPGPKEY: "OPENPGPKEY",
+	TypeCSYNC:      "CSYNC",
+	TypeURI:        "URI",
+	TypeCAA:        "CAA",
+}
@@ -3,6 +3,7 @@ package dns
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"net"
 	"reflect"
 	"strings"
@@ -767,6 +768,299 @@ func TestMessagePackUnpack(t *testing.T) {
 				0x7F, 0x00, 0x00, 0x01, // 127.0.0.1
 			},
 		},
+		{
+			name: "hinfo.example.com.	IN	HINFO",
+
+			msg: Message{
+				ID:       0x01,
+				Response: true,
+				Questions: []Question{
+					{
+						Name:  "hinfo.example.com.",
+						Type:  TypeHINFO,
+						Class: ClassIN,
+					},
+				},
+				Answers: []Resource{
+					{
+						Name:   "hinfo.example.com.",
+						Class:  ClassIN,
+						TTL:    60 * time.Second,
+						Record: &HINFO{CPU: "RFC8482"},
+					},
+				},
+			},
+
+			compress: true,
+
+			raw: []byte{
+				0x00, 0x01, // ID=0x0001
+				0x80, 0x00, // QR=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x01, // ANCOUNT=1
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				// hinfo.example.com.	IN	HINFO
+				0x05, 'h', 'i', 'n', 'f', 'o',
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+				0x00,
+				0x00, 0x0D, 0x00, 0x01,
+
+				// hinfo.example.com.	60	IN	HINFO	"RFC8482" ""
+				0xC0, 0x0C,
+				0x00, 0x0D, 0x00, 0x01, // TYPE=HINFO,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x09, // RDLENGTH=9
+
+				0x07, 'R', 'F', 'C', '8', '4', '8', '2', // CPU
+				0x00, // OS
+			},
+		},
+		{
+			name: "example.com.	IN	CSYNC",
+
+			msg: Message{
+				ID:       0x01,
+				Response: true,
+				Questions: []Question{
+					{
+						Name:  "example.com.",
+						Type:  TypeCSYNC,
+						Class: ClassIN,
+					},
+				},
+				Answers: []Resource{
+					{
+						Name:  "example.com.",
+						Class: ClassIN,
+						TTL:   60 * time.Second,
+						Record: &CSYNC{
+							SOASerial: 66,
+							Flags:     CSYNCImmediate | CSYNCSOAMinimum,
+							Types:     []Type{TypeA, TypeAAAA},
+						},
+					},
+				},
+			},
+
+			compress: true,
+
+			raw: []byte{
+				0x00, 0x01, // ID=0x0001
+				0x80, 0x00, // QR=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x01, // ANCOUNT=1
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				// example.com.	IN	CSYNC
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+				0x00,
+				0x00, 0x3E, 0x00, 0x01,
+
+				// example.com.	60	IN	CSYNC	66 3 A AAAA
+				0xC0, 0x0C,
+				0x00, 0x3E, 0x00, 0x01, // TYPE=CSYNC,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x0C, // RDLENGTH=12
+
+				0x00, 0x00, 0x00, 0x42, // SOA Serial=66
+				0x00, 0x03, // Flags=immediate|soaminimum
+				0x00, 0x04, 0x40, 0x00, 0x00, 0x08, // Window 0: A, AAAA
+			},
+		},
+		{
+			name: "example.com.	IN	RRSIG",
+
+			msg: Message{
+				ID:       0x01,
+				Response: true,
+				Questions: []Question{
+					{
+						Name:  "example.com.",
+						Type:  TypeRRSIG,
+						Class: ClassIN,
+					},
+				},
+				Answers: []Resource{
+					{
+						Name:  "example.com.",
+						Class: ClassIN,
+						TTL:   60 * time.Second,
+						Record: &RRSIG{
+							TypeCovered: TypeA,
+							Algorithm:   8,
+							Labels:      2,
+							OriginalTTL: time.Hour,
+							Expiration:  time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+							Inception:   time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC),
+							KeyTag:      12345,
+							SignerName:  "example.com.",
+							Signature:   []byte{0xde, 0xad, 0xbe, 0xef},
+						},
+					},
+				},
+			},
+
+			compress: true,
+
+			raw: []byte{
+				0x00, 0x01, // ID=0x0001
+				0x80, 0x00, // QR=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x01, // ANCOUNT=1
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				// example.com.	IN	RRSIG
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+				0x00,
+				0x00, 0x2E, 0x00, 0x01,
+
+				// example.com.	60	IN	RRSIG	A 8 2 3600 20210101000000 20201201000000 12345 example.com. 3q2+7w==
+				0xC0, 0x0C,
+				0x00, 0x2E, 0x00, 0x01, // TYPE=RRSIG,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x23, // RDLENGTH=35
+
+				0x00, 0x01, // Type Covered=A
+				0x08,                   // Algorithm=8
+				0x02,                   // Labels=2
+				0x00, 0x00, 0x0E, 0x10, // Original TTL=3600
+				0x5F, 0xEE, 0x66, 0x00, // Signature Expiration=20210101000000
+				0x5F, 0xC5, 0x87, 0x80, // Signature Inception=20201201000000
+				0x30, 0x39, // Key Tag=12345
+
+				// Signer's Name, not compressed
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+				0x00,
+
+				0xDE, 0xAD, 0xBE, 0xEF, // Signature
+			},
+		},
+		{
+			name: "www.example.com.	IN	CNAME	web.example.net.",
+
+			msg: Message{
+				ID:       0x01,
+				Response: true,
+				Questions: []Question{
+					{
+						Name:  "www.example.com.",
+						Type:  TypeA,
+						Class: ClassIN,
+					},
+				},
+				Answers: []Resource{
+					{
+						Name:   "www.example.com.",
+						Class:  ClassIN,
+						TTL:    60 * time.Second,
+						Record: &CNAME{CNAME: "web.example.net."},
+					},
+					{
+						Name:   "web.example.net.",
+						Class:  ClassIN,
+						TTL:    60 * time.Second,
+						Record: &A{A: net.IPv4(192, 0, 2, 1).To4()},
+					},
+				},
+			},
+
+			compress: true,
+
+			raw: []byte{
+				0x00, 0x01, // ID=0x0001
+				0x80, 0x00, // QR=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x02, // ANCOUNT=2
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				// www.example.com.	IN	A
+				0x03, 'w', 'w', 'w',
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+				0x00,
+				0x00, 0x01, 0x00, 0x01,
+
+				// www.example.com.	60	IN	CNAME	web.example.net.
+				0xC0, 0x0C,
+				0x00, 0x05, 0x00, 0x01, // TYPE=CNAME,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x11, // RDLENGTH=17
+
+				// out of zone target at offset 0x2D
+				0x03, 'w', 'e', 'b',
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'n', 'e', 't',
+				0x00,
+
+				// web.example.net.	60	IN	A	192.0.2.1
+				0xC0, 0x2D,
+				0x00, 0x01, 0x00, 0x01, // TYPE=A,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x04, // RDLENGTH=4
+				0xC0, 0x00, 0x02, 0x01,
+			},
+		},
+		{
+			name: "example.com.	IN	DNAME	dname.example.com.",
+
+			msg: Message{
+				ID:       0x01,
+				Response: true,
+				Questions: []Question{
+					{
+						Name:  "example.com.",
+						Type:  TypeDNAME,
+						Class: ClassIN,
+					},
+				},
+				Answers: []Resource{
+					{
+						Name:   "example.com.",
+						Class:  ClassIN,
+						TTL:    60 * time.Second,
+						Record: &DNAME{DNAME: "dname.example.com."},
+					},
+				},
+			},
+
+			compress: true,
+
+			raw: []byte{
+				0x00, 0x01, // ID=0x0001
+				0x80, 0x00, // QR=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x01, // ANCOUNT=1
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				// example.com.	IN	DNAME
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+				0x00,
+				0x00, 0x27, 0x00, 0x01,
+
+				// example.com.	60	IN	DNAME	dname.example.com.
+				0xC0, 0x0C,
+				0x00, 0x27, 0x00, 0x01, // TYPE=DNAME,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x13, // RDLENGTH=19
+
+				// the target is not compressed (RFC 6672, section 2.5)
+				0x05, 'd', 'n', 'a', 'm', 'e',
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+				0x03, 'c', 'o', 'm',
+				0x00,
+			},
+		},
 		{
 			name: ".	IN	AAAA + OPT",
 
@@ -904,6 +1198,64 @@ func TestMessageCompress(t *testing.T) {
 				0x00, 0x01, 0x00, 0x01,
 			},
 		},
+		{
+			name: "shared-suffix-answers",
+
+			msg: Message{
+				Response: true,
+				Questions: []Question{
+					{Name: "example.com.", Type: TypeALL, Class: ClassIN},
+				},
+				Answers: []Resource{
+					{Name: "example.com.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(127, 0, 0, 1).To4()}},
+					{Name: "www.example.com.", Class: ClassIN, TTL: time.Minute, Record: &CNAME{CNAME: "example.com."}},
+					{Name: "example.com.", Class: ClassIN, TTL: time.Minute, Record: &MX{Pref: 10, MX: "mail.example.com."}},
+					{Name: "smtp.mail.example.com.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(127, 0, 0, 2).To4()}},
+				},
+			},
+
+			raw: []byte{
+				0x00, 0x00, // ID=0x0000
+				0x80, 0x00, // QR=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x04, // ANCOUNT=4
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				// example.com.	IN	ANY
+				0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+				0x00, 0xFF, 0x00, 0x01,
+
+				// example.com.	60	IN	A	127.0.0.1
+				0xC0, 0x0C,
+				0x00, 0x01, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x3C,
+				0x00, 0x04,
+				0x7F, 0x00, 0x00, 0x01,
+
+				// www.example.com.	60	IN	CNAME	example.com.
+				0x03, 'w', 'w', 'w', 0xC0, 0x0C,
+				0x00, 0x05, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x3C,
+				0x00, 0x02,
+				0xC0, 0x0C,
+
+				// example.com.	60	IN	MX	10 mail.example.com.
+				0xC0, 0x0C,
+				0x00, 0x0F, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x3C,
+				0x00, 0x09,
+				0x00, 0x0A,
+				0x04, 'm', 'a', 'i', 'l', 0xC0, 0x0C,
+
+				// smtp.mail.example.com.	60	IN	A	127.0.0.2
+				0x04, 's', 'm', 't', 'p', 0xC0, 0x4D,
+				0x00, 0x01, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x3C,
+				0x00, 0x04,
+				0x7F, 0x00, 0x00, 0x02,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -937,17 +1289,84 @@ func TestMessageCompress(t *testing.T) {
 	}
 }
 
-var (
-	rawGoogleCom = []byte{
-		0x6, 'g', 'o', 'o', 'g', 'l', 'e',
-		0x3, 'c', 'o', 'm',
-		0x0,
-	}
-)
+func TestMessageCompressMaxNames(t *testing.T) {
+	t.Parallel()
 
-func BenchmarkMessagePack(b *testing.B) {
-	b.Run("small-message", func(b *testing.B) {
-		msg := smallTestMsg()
+	msg := Message{
+		Questions: []Question{
+			{
+				Name:  "aaa.",
+				Type:  TypeA,
+				Class: ClassIN,
+			},
+			{
+				Name:  "bbb.aaa.",
+				Type:  TypeA,
+				Class: ClassIN,
+			},
+			{
+				Name:  "ccc.bbb.aaa.",
+				Type:  TypeA,
+				Class: ClassIN,
+			},
+		},
+		CompressMaxNames: 1,
+	}
+
+	raw, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		0x00, 0x00, // ID=0x0000
+		0x00, 0x00, // QR=0
+		0x00, 0x03, // QDCOUNT=3
+		0x00, 0x00, // ANCOUNT=0
+		0x00, 0x00, // NSCOUNT=0
+		0x00, 0x00, // ARCOUNT=0
+
+		// aaa.	IN	A
+		0x03, 'a', 'a', 'a',
+		0x00,
+		0x00, 0x01, 0x00, 0x01,
+
+		// bbb.aaa.	IN	A
+		0x03, 'b', 'b', 'b',
+		0xC0, 0x0C,
+		0x00, 0x01, 0x00, 0x01,
+
+		// ccc.bbb.aaa.	IN	A
+		0x03, 'c', 'c', 'c',
+		0x03, 'b', 'b', 'b',
+		0xC0, 0x0C,
+		0x00, 0x01, 0x00, 0x01,
+	}
+
+	if got := raw; !bytes.Equal(want, got) {
+		t.Errorf("want raw message %+v, got %+v", want, got)
+	}
+
+	res := new(Message)
+	if _, err := res.Unpack(raw); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := msg.Questions, res.Questions; !reflect.DeepEqual(want, got) {
+		t.Errorf("want questions %+v, got %+v", want, got)
+	}
+}
+
+var (
+	rawGoogleCom = []byte{
+		0x6, 'g', 'o', 'o', 'g', 'l', 'e',
+		0x3, 'c', 'o', 'm',
+		0x0,
+	}
+)
+
+func BenchmarkMessagePack(b *testing.B) {
+	b.Run("small-message", func(b *testing.B) {
+		msg := smallTestMsg()
 
 		for _, bufsize := range []int{0, 512} {
 			bufsize := bufsize
@@ -1339,3 +1758,925 @@ func TestInvalidCAA(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordPackUnpack(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		rec Record
+	}{
+		{
+			name: "OPENPGPKEY",
+
+			rec: &OPENPGPKEY{PublicKey: []byte{0x99, 0x01, 0x0d, 0x04, 0x5f, 0x1b}},
+		},
+		{
+			name: "SMIMEA",
+
+			rec: &SMIMEA{
+				Usage:        3,
+				Selector:     1,
+				MatchingType: 1,
+				Certificate:  []byte{0xde, 0xad, 0xbe, 0xef},
+			},
+		},
+		{
+			name: "URI",
+
+			rec: &URI{
+				Priority: 10,
+				Weight:   1,
+				Target:   "https://example.com/api?version=2&region=eu",
+			},
+		},
+		{
+			name: "TXT",
+
+			rec: &TXT{TXT: []string{"v=spf1", "include:_spf.google.com", "", strings.Repeat("x", 255)}},
+		},
+		{name: "A", rec: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+		{name: "NS", rec: &NS{NS: "ns.example.com."}},
+		{name: "CNAME", rec: &CNAME{CNAME: "www.example.com."}},
+		{
+			name: "SOA",
+
+			rec: &SOA{
+				NS:      "ns.example.com.",
+				MBox:    "hostmaster.example.com.",
+				Serial:  2021010101,
+				Refresh: 2 * time.Hour,
+				Retry:   15 * time.Minute,
+				Expire:  7 * 24 * time.Hour,
+				MinTTL:  5 * time.Minute,
+			},
+		},
+		{name: "PTR", rec: &PTR{PTR: "host.example.com."}},
+		{name: "HINFO", rec: &HINFO{CPU: "amd64", OS: "linux"}},
+		{name: "MX", rec: &MX{Pref: 10, MX: "mail.example.com."}},
+		{name: "AAAA", rec: &AAAA{AAAA: net.ParseIP("2001:db8::1")}},
+		{
+			name: "SRV",
+
+			rec: &SRV{Priority: 10, Weight: 5, Port: 5060, Target: "sip.example.com."},
+		},
+		{name: "DNAME", rec: &DNAME{DNAME: "example.net."}},
+		{
+			name: "OPT",
+
+			rec: &OPT{Options: []edns.Option{{Code: edns.OptionCodeCookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}}},
+		},
+		{
+			name: "CSYNC",
+
+			rec: &CSYNC{SOASerial: 66, Flags: CSYNCImmediate, Types: []Type{TypeA, TypeNS, TypeAAAA}},
+		},
+		{
+			name: "RRSIG",
+
+			rec: &RRSIG{
+				TypeCovered: TypeAAAA,
+				Algorithm:   13,
+				Labels:      2,
+				OriginalTTL: 5 * time.Minute,
+				Expiration:  time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				Inception:   time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC),
+				KeyTag:      2371,
+				SignerName:  "example.com.",
+				Signature:   []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+			},
+		},
+		{
+			name: "DNSKEY",
+
+			rec: &DNSKEY{
+				Flags:     DNSKEYZone | DNSKEYSecureEntryPoint,
+				Protocol:  3,
+				Algorithm: 13,
+				PublicKey: []byte{0x99, 0x01, 0x0d, 0x04},
+			},
+		},
+		{
+			name: "CAA",
+
+			rec: &CAA{IssuerCritical: true, Tag: "issue", Value: "ca.example.net"},
+		},
+	}
+
+	// every registered record type round trips
+	covered := make(map[Type]bool, len(tests))
+	for _, test := range tests {
+		covered[test.rec.Type()] = true
+	}
+	for typ := range NewRecordByType {
+		if !covered[typ] {
+			t.Errorf("no pack and unpack test for record type %d", typ)
+		}
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg := Message{
+				ID:       0x01,
+				Response: true,
+				Answers: []Resource{
+					{
+						Name:   "example.com.",
+						Class:  ClassIN,
+						TTL:    60 * time.Second,
+						Record: test.rec,
+					},
+				},
+			}
+
+			buf, err := msg.Pack(nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got Message
+			if _, err := got.Unpack(buf); err != nil {
+				t.Fatal(err)
+			}
+			if want := msg; !reflect.DeepEqual(want, got) {
+				t.Errorf("want message %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestRRSIGPackTimes(t *testing.T) {
+	t.Parallel()
+
+	valid := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+
+		expiration, inception time.Time
+
+		err error
+	}{
+		{name: "zero-expiration", inception: valid, err: errZeroSigTime},
+		{name: "zero-inception", expiration: valid, err: errZeroSigTime},
+		{name: "before-epoch", expiration: valid, inception: time.Unix(-1, 0), err: errFieldOverflow},
+		{name: "after-2106", expiration: time.Unix(1<<32, 0), inception: valid, err: errFieldOverflow},
+		{name: "valid", expiration: valid, inception: valid},
+	}
+
+	for _, test := range tests {
+		rec := &RRSIG{
+			TypeCovered: TypeA,
+			Expiration:  test.expiration,
+			Inception:   test.inception,
+			SignerName:  "example.com.",
+		}
+
+		_, err := rec.Pack(nil, compressor{})
+		if want, got := test.err, err; want != got {
+			t.Errorf("%s: want error %v, got %v", test.name, want, got)
+		}
+	}
+}
+
+func TestEmailOwnerName(t *testing.T) {
+	t.Parallel()
+
+	// RFC 7929, section 3
+	hash := "c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6"
+
+	name, err := OPENPGPKEYName("hugh@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := hash+"._openpgpkey.example.com.", name; want != got {
+		t.Errorf("want OPENPGPKEY name %q, got %q", want, got)
+	}
+
+	if name, err = SMIMEAName("hugh@example.com."); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := hash+"._smimecert.example.com.", name; want != got {
+		t.Errorf("want SMIMEA name %q, got %q", want, got)
+	}
+
+	if _, err := OPENPGPKEYName("example.com"); err != errInvalidEmail {
+		t.Errorf("want error %q, got %v", errInvalidEmail, err)
+	}
+}
+
+func TestMessageSOA(t *testing.T) {
+	t.Parallel()
+
+	soa := Resource{
+		Name:  "example.com.",
+		Class: ClassIN,
+		TTL:   time.Hour,
+		Record: &SOA{
+			NS:     "ns1.example.com.",
+			MBox:   "hostmaster.example.com.",
+			MinTTL: 5 * time.Minute,
+		},
+	}
+
+	msg := &Message{
+		Response: true,
+		RCode:    NXDomain,
+		Authorities: []Resource{
+			{
+				Name:   "example.com.",
+				Class:  ClassIN,
+				TTL:    time.Hour,
+				Record: &NS{NS: "ns1.example.com."},
+			},
+			soa,
+		},
+	}
+
+	res, ok := msg.SOA()
+	if !ok {
+		t.Fatal("want SOA record, got none")
+	}
+	if want, got := soa, res; !reflect.DeepEqual(want, got) {
+		t.Errorf("want SOA record %+v, got %+v", want, got)
+	}
+
+	msg.Authorities = msg.Authorities[:1]
+
+	if res, ok := msg.SOA(); ok {
+		t.Errorf("want no SOA record, got %+v", res)
+	}
+}
+
+func TestMessageFlags(t *testing.T) {
+	t.Parallel()
+
+	var msg Message
+	msg.SetFlags(0x8000 | 0x2<<11 | 0x0400 | 0x0100 | 0x0080 | 0x0020 | 0x0010 | 0x0003)
+
+	want := Message{
+		Response:           true,
+		OpCode:             2,
+		Authoritative:      true,
+		RecursionDesired:   true,
+		RecursionAvailable: true,
+		AuthenticData:      true,
+		CheckingDisabled:   true,
+		RCode:              NXDomain,
+	}
+	if !reflect.DeepEqual(want, msg) {
+		t.Errorf("want message %+v, got %+v", want, msg)
+	}
+
+	msg.Truncated, msg.Zero, msg.AuthenticData = true, true, false
+
+	if want, got := uint16(0x97D3), msg.Flags(); want != got {
+		t.Errorf("want flags %#04x, got %#04x", want, got)
+	}
+
+	buf, err := msg.Pack(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := msg.Flags(), nbo.Uint16(buf[2:4]); want != got {
+		t.Errorf("want packed flags %#04x, got %#04x", want, got)
+	}
+
+	var got Message
+	if _, err := got.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, got) {
+		t.Errorf("want message %+v, got %+v", msg, got)
+	}
+}
+
+func TestMessageExtendedRCode(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Response: true,
+		RCode:    BadVers,
+	}
+
+	if _, err := msg.Pack(nil, false); err != errFieldOverflow {
+		t.Errorf("want error %q without OPT record, got %v", errFieldOverflow, err)
+	}
+
+	msg.Additionals = []Resource{
+		{Name: ".", Class: 1232, Record: new(OPT)},
+	}
+
+	buf, err := msg.Pack(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := uint16(0x8000), nbo.Uint16(buf[2:4]); want != got {
+		t.Errorf("want header flags %#04x, got %#04x", want, got)
+	}
+
+	var got Message
+	if _, err := got.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := BadVers, got.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if want, got := time.Duration(1<<24)*time.Second, got.Additionals[0].TTL; want != got {
+		t.Errorf("want OPT TTL %v, got %v", want, got)
+	}
+}
+
+func TestRecordRData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rec  Record
+		want string
+	}{
+		{&A{A: net.IPv4(192, 0, 2, 1).To4()}, "192.0.2.1"},
+		{&AAAA{AAAA: net.ParseIP("2001:db8:0:0:0:0:0:1")}, "2001:db8::1"},
+		{&CNAME{CNAME: "www.example.com."}, "www.example.com."},
+		{
+			&SOA{
+				NS:      "ns1.example.com.",
+				MBox:    "hostmaster.example.com.",
+				Serial:  2020010101,
+				Refresh: time.Hour,
+				Retry:   15 * time.Minute,
+				Expire:  7 * 24 * time.Hour,
+				MinTTL:  5 * time.Minute,
+			},
+			"ns1.example.com. hostmaster.example.com. 2020010101 3600 900 604800 300",
+		},
+		{&PTR{PTR: "host.example.com."}, "host.example.com."},
+		{&HINFO{CPU: "RFC8482"}, `"RFC8482" ""`},
+		{&MX{Pref: 10, MX: "mx.example.com."}, "10 mx.example.com."},
+		{&NS{NS: "ns1.example.com."}, "ns1.example.com."},
+		{&TXT{TXT: []string{"v=spf1 -all", `say "hi"\`, "\x00"}}, `"v=spf1 -all" "say \"hi\"\\" "\000"`},
+		{&SRV{Priority: 1, Weight: 2, Port: 5060, Target: "sip.example.com."}, "1 2 5060 sip.example.com."},
+		{&DNAME{DNAME: "example.net."}, "example.net."},
+		{&OPT{Options: []edns.Option{{Code: 10, Data: []byte{0xca, 0xfe}}}}, "10:cafe"},
+		{&SMIMEA{Usage: 3, Selector: 1, MatchingType: 1, Certificate: []byte{0xde, 0xad}}, "3 1 1 DEAD"},
+		{&OPENPGPKEY{PublicKey: []byte("key")}, "a2V5"},
+		{&CSYNC{SOASerial: 66, Flags: 3, Types: []Type{TypeA, TypeNS, TypeAAAA}}, "66 3 A NS AAAA"},
+		{
+			&RRSIG{
+				TypeCovered: TypeA,
+				Algorithm:   8,
+				Labels:      2,
+				OriginalTTL: time.Hour,
+				Expiration:  time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				Inception:   time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC),
+				KeyTag:      12345,
+				SignerName:  "example.com.",
+				Signature:   []byte("sig"),
+			},
+			"A 8 2 3600 20210101000000 20201201000000 12345 example.com. c2ln",
+		},
+		{&DNSKEY{Flags: 257, Protocol: 3, Algorithm: 8, PublicKey: []byte("key")}, "257 3 8 a2V5"},
+		{&URI{Priority: 10, Weight: 1, Target: "ftp://ftp1.example.com/public"}, `10 1 "ftp://ftp1.example.com/public"`},
+		{&CAA{IssuerCritical: true, Tag: "issue", Value: "ca.example.net"}, `128 issue "ca.example.net"`},
+	}
+
+	for _, test := range tests {
+		if want, got := test.want, test.rec.RData(); want != got {
+			t.Errorf("%s: want RDATA %q, got %q", typeName(test.rec.Type()), want, got)
+		}
+	}
+}
+
+func TestMessageMultipleQuestions(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		ID:       0x01,
+		Response: true,
+		Questions: []Question{
+			{Name: "www.example.com.", Type: TypeA, Class: ClassIN},
+			{Name: "www.example.com.", Type: TypeAAAA, Class: ClassIN},
+			{Name: "mail.example.com.", Type: TypeMX, Class: ClassIN},
+		},
+		Answers: []Resource{
+			{Name: "www.example.com.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+			{Name: "www.example.com.", Class: ClassIN, TTL: time.Minute, Record: &AAAA{AAAA: net.ParseIP("2001:db8::1")}},
+			{Name: "mail.example.com.", Class: ClassIN, TTL: time.Minute, Record: &MX{Pref: 10, MX: "mx.example.com."}},
+		},
+	}
+
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdr, err := DecodeHeader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, hdr.QDCount; want != got {
+		t.Errorf("want QDCOUNT %d, got %d", want, got)
+	}
+	if want, got := 3, hdr.ANCount; want != got {
+		t.Errorf("want ANCOUNT %d, got %d", want, got)
+	}
+
+	var got Message
+	if _, err := got.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := msg; !reflect.DeepEqual(want, got) {
+		t.Errorf("want message %+v, got %+v", want, got)
+	}
+
+	// every truncation of the message fails to decode
+	for n := 0; n < len(buf); n++ {
+		var m Message
+		if _, err := m.Unpack(buf[:n]); err == nil {
+			t.Errorf("want error decoding %d of %d bytes, got none", n, len(buf))
+		}
+	}
+}
+
+func TestMessageUnpackCounts(t *testing.T) {
+	t.Parallel()
+
+	raw := []byte{
+		0x00, 0x01, // ID=0x0001
+		0x00, 0x00, // QR=0
+		0xFF, 0xFF, // QDCOUNT=65535
+		0xFF, 0xFF, // ANCOUNT=65535
+		0xFF, 0xFF, // NSCOUNT=65535
+		0xFF, 0xFF, // ARCOUNT=65535
+
+		// example.com.	IN	A
+		0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+		0x03, 'c', 'o', 'm',
+		0x00,
+		0x00, 0x01, 0x00, 0x01,
+	}
+
+	msg, err := UnmarshalPartial(raw)
+	if want, got := errSectionCount, err; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+	if want, got := []Question{{Name: "example.com.", Type: TypeA, Class: ClassIN}}, msg.Questions; !reflect.DeepEqual(want, got) {
+		t.Errorf("want questions %+v, got %+v", want, got)
+	}
+
+	// the sections are allocated for what the message can hold, rather
+	// than for the header counts
+	if n := len(raw) - 12; cap(msg.Questions) > n/minQuestionLen || cap(msg.Answers) > n/minResourceLen {
+		t.Errorf("want sections allocated for %d bytes, got capacities %d and %d", n, cap(msg.Questions), cap(msg.Answers))
+	}
+}
+
+func TestMessagePackCounts(t *testing.T) {
+	t.Parallel()
+
+	rrs := make([]Resource, 1<<16)
+	for i := range rrs {
+		rrs[i] = Resource{Name: ".", Class: ClassIN, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}
+	}
+
+	tests := []struct {
+		msg Message
+		err error
+	}{
+		{Message{Questions: make([]Question, 1<<16)}, errTooManyQuestions},
+		{Message{Answers: rrs}, errTooManyAnswers},
+		{Message{Authorities: rrs}, errTooManyAuthorities},
+		{Message{Additionals: rrs}, errTooManyAdditionals},
+	}
+
+	for _, test := range tests {
+		if _, err := test.msg.Pack(nil, true); err != test.err {
+			t.Errorf("want error %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestMessageUnpackMalformed(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		ID: 0x01,
+		Questions: []Question{
+			{Name: "www.example.com.", Type: TypeA, Class: ClassIN},
+			{Name: "mail.example.com.", Type: TypeMX, Class: ClassIN},
+		},
+		Answers: []Resource{
+			{Name: "www.example.com.", Class: ClassIN, TTL: time.Minute, Record: &CNAME{CNAME: "web.example.com."}},
+			{Name: "mail.example.com.", Class: ClassIN, TTL: time.Minute, Record: &MX{Pref: 10, MX: "mx.example.com."}},
+			{Name: "example.com.", Class: ClassIN, TTL: time.Minute, Record: &TXT{TXT: []string{"v=spf1 -all"}}},
+		},
+	}
+
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decoding truncated and corrupted copies of the message must return
+	// an error or a message, but never panic
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		b := append([]byte(nil), buf[:rnd.Intn(len(buf)+1)]...)
+		for j := rnd.Intn(4); j > 0 && len(b) > 0; j-- {
+			b[rnd.Intn(len(b))] = byte(rnd.Intn(256))
+		}
+
+		var m Message
+		m.Unpack(b)
+		UnmarshalPartial(b)
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	t.Parallel()
+
+	raw := []byte{
+		0xBE, 0xEF, // ID=0xBEEF
+		0x85, 0xA3, // QR=1,AA=1,RD=1,RA=1,AD=1,RCODE=3
+		0x00, 0x01, // QDCOUNT=1
+		0x00, 0x02, // ANCOUNT=2
+		0x00, 0x03, // NSCOUNT=3
+		0x00, 0x04, // ARCOUNT=4
+
+		0xFF, 0xFF, // garbage after the header
+	}
+
+	h, err := DecodeHeader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Header{
+		ID:                 0xBEEF,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   true,
+		RecursionAvailable: true,
+		AuthenticData:      true,
+		RCode:              NXDomain,
+
+		QDCount: 1,
+		ANCount: 2,
+		NSCount: 3,
+		ARCount: 4,
+	}
+	if !reflect.DeepEqual(want, h) {
+		t.Errorf("want header %+v, got %+v", want, h)
+	}
+
+	if _, err := DecodeHeader(raw[:11]); err != errResourceLen {
+		t.Errorf("want error %q for short header, got %v", errResourceLen, err)
+	}
+}
+
+func TestResourceTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		ttl time.Duration
+		raw uint32
+	}{
+		{name: "negative", ttl: -time.Second, raw: 0},
+		{name: "sub-second", ttl: time.Millisecond, raw: 0},
+		{name: "hour", ttl: time.Hour, raw: 3600},
+		{name: "max", ttl: maxTTL * time.Second, raw: maxTTL},
+		{name: "over-max", ttl: (1<<32 - 1) * time.Second, raw: maxTTL},
+	}
+
+	for _, test := range tests {
+		res := Resource{
+			Name:   "example.com.",
+			Class:  ClassIN,
+			TTL:    test.ttl,
+			Record: &A{A: net.IPv4(127, 0, 0, 1).To4()},
+		}
+
+		buf, err := res.Pack(nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if want, got := test.raw, nbo.Uint32(buf[len(buf)-10:]); want != got {
+			t.Errorf("%s: want packed TTL %d, got %d", test.name, want, got)
+		}
+	}
+
+	// the full 32 bits are unpacked
+	raw := []byte{
+		0x00,                   // .
+		0x00, 0x01, 0x00, 0x01, // TYPE=A,CLASS=IN
+		0xFF, 0xFF, 0xFF, 0xFF, // TTL=2^32-1
+		0x00, 0x04, // RDLENGTH=4
+		127, 0, 0, 1,
+	}
+
+	var res Resource
+	if _, err := res.Unpack(raw, decompressor(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := (1<<32-1)*time.Second, res.TTL; want != got {
+		t.Errorf("want unpacked TTL %v, got %v", want, got)
+	}
+}
+
+func TestMessageUnpackSectionCounts(t *testing.T) {
+	t.Parallel()
+
+	raw := []byte{
+		0x00, 0x01, // ID=1
+		0x81, 0x00, // QR=1,RD=1
+		0x00, 0x00, // QDCOUNT=0
+		0x00, 0x02, // ANCOUNT=2
+		0x00, 0x00, // NSCOUNT=0
+		0x00, 0x00, // ARCOUNT=0
+
+		0x00,       // NAME=.
+		0x00, 0x01, // TYPE=A
+		0x00, 0x01, // CLASS=IN
+		0x00, 0x00, 0x00, 0x3C, // TTL=60
+		0x00, 0x04, // RDLENGTH=4
+		0x7F, 0x00, 0x00, 0x01, // RDATA=127.0.0.1
+	}
+
+	if _, err := new(Message).Unpack(raw); err != errSectionCount {
+		t.Errorf("want error %q for missing answer, got %v", errSectionCount, err)
+	}
+
+	// the record is not lumped into the additional section
+	raw[7], raw[11] = 0x00, 0x00
+	if err := unpackAll(new(Message), raw); err != errExtraBytes {
+		t.Errorf("want error %q for undeclared record, got %v", errExtraBytes, err)
+	}
+}
+
+func TestMessageEachResource(t *testing.T) {
+	t.Parallel()
+
+	msg := &Message{
+		Questions: []Question{
+			{Name: "localhost.", Type: TypeA, Class: ClassIN},
+		},
+		Answers: []Resource{
+			{Name: "a.localhost.", Record: &A{A: net.IPv4(127, 0, 0, 1).To4()}},
+			{Name: "b.localhost.", Record: &A{A: net.IPv4(127, 0, 0, 2).To4()}},
+		},
+		Authorities: []Resource{
+			{Name: "localhost.", Record: &NS{NS: "ns.localhost."}},
+		},
+		Additionals: []Resource{
+			{Name: "ns.localhost.", Record: &A{A: net.IPv4(127, 0, 0, 53).To4()}},
+			{Name: ".", Record: new(OPT)},
+		},
+	}
+
+	type visit struct {
+		section Section
+		name    string
+	}
+
+	var got []visit
+	msg.EachResource(func(section Section, res *Resource) {
+		got = append(got, visit{section, res.Name})
+		res.TTL = time.Minute
+	})
+
+	want := []visit{
+		{SectionAnswer, "a.localhost."},
+		{SectionAnswer, "b.localhost."},
+		{SectionAuthority, "localhost."},
+		{SectionAdditional, "ns.localhost."},
+		{SectionAdditional, "."},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want visits %v, got %v", want, got)
+	}
+
+	if want, got := time.Minute, msg.Authorities[0].TTL; want != got {
+		t.Errorf("want modified TTL %s, got %s", want, got)
+	}
+	if want, got := "ADDITIONAL", SectionAdditional.String(); want != got {
+		t.Errorf("want section %q, got %q", want, got)
+	}
+}
+
+func TestMessageMinTTL(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	tests := []struct {
+		name string
+
+		msg *Message
+
+		ttl time.Duration
+	}{
+		{
+			name: "answers",
+
+			msg: &Message{
+				Answers: []Resource{
+					{Name: "a.localhost.", TTL: 300 * time.Second, Record: &A{A: localhost}},
+					{Name: "b.localhost.", TTL: 60 * time.Second, Record: &A{A: localhost}},
+				},
+				Additionals: []Resource{
+					{Name: "c.localhost.", TTL: 120 * time.Second, Record: &A{A: localhost}},
+					{Name: ".", Record: new(OPT)},
+				},
+			},
+
+			ttl: 60 * time.Second,
+		},
+		{
+			name: "negative-soa-minimum",
+
+			msg: &Message{
+				RCode: NXDomain,
+				Authorities: []Resource{
+					{Name: "localhost.", TTL: time.Hour, Record: &SOA{MinTTL: 5 * time.Minute}},
+				},
+			},
+
+			ttl: 5 * time.Minute,
+		},
+		{
+			name: "negative-soa-ttl",
+
+			msg: &Message{
+				Authorities: []Resource{
+					{Name: "localhost.", TTL: time.Minute, Record: &SOA{MinTTL: time.Hour}},
+				},
+			},
+
+			ttl: time.Minute,
+		},
+		{
+			name: "empty",
+
+			msg: new(Message),
+		},
+	}
+
+	for _, test := range tests {
+		if want, got := test.ttl, test.msg.MinTTL(); want != got {
+			t.Errorf("%s: want min TTL %s, got %s", test.name, want, got)
+		}
+	}
+}
+
+func TestUnmarshalPartial(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	msg := &Message{
+		ID:       0x1234,
+		Response: true,
+		Questions: []Question{
+			{Name: "localhost.", Type: TypeA, Class: ClassIN},
+		},
+		Answers: []Resource{
+			{Name: "localhost.", Class: ClassIN, TTL: time.Minute, Record: &A{A: localhost}},
+			{Name: "localhost.", Class: ClassIN, TTL: time.Minute, Record: &A{A: localhost}},
+		},
+	}
+
+	raw, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := UnmarshalPartial(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(full.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+
+	// truncated in the RDATA of the second answer
+	partial, err := UnmarshalPartial(raw[:len(raw)-2])
+	if err != errResourceLen {
+		t.Errorf("want error %q, got %v", errResourceLen, err)
+	}
+	if partial == nil {
+		t.Fatal("want partial message")
+	}
+
+	if want, got := 0x1234, partial.ID; want != got {
+		t.Errorf("want ID %#x, got %#x", want, got)
+	}
+	if !partial.Response {
+		t.Error("want response header bit")
+	}
+	if want, got := msg.Questions, partial.Questions; !reflect.DeepEqual(want, got) {
+		t.Errorf("want questions %+v, got %+v", want, got)
+	}
+	if want, got := msg.Answers[:1], partial.Answers; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answers %+v, got %+v", want, got)
+	}
+}
+
+func TestTXTTooLong(t *testing.T) {
+	t.Parallel()
+
+	txt := &TXT{TXT: []string{"v=spf1", strings.Repeat("x", 256)}}
+	if _, err := txt.Pack(nil, nil); err != errTXTTooLong {
+		t.Errorf("want error %q, got %v", errTXTTooLong, err)
+	}
+
+	raw, err := (&TXT{TXT: []string{"v=spf1", "include:_spf.google.com"}}).Pack(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "\x06v=spf1\x17include:_spf.google.com", string(raw); want != got {
+		t.Errorf("want raw TXT %q, got %q", want, got)
+	}
+}
+
+func TestSRVCompressedTarget(t *testing.T) {
+	t.Parallel()
+
+	raw := []byte{
+		0x00, 0x09, 0x80, 0x00, // ID=9, QR=1
+		0x00, 0x01, 0x00, 0x01, // QDCOUNT=1, ANCOUNT=1
+		0x00, 0x00, 0x00, 0x00, // NSCOUNT=0, ARCOUNT=0
+
+		0x04, '_', 's', 'i', 'p', 0x04, '_', 't', 'c', 'p',
+		0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x21, 0x00, 0x01, // TYPE=SRV,CLASS=IN
+
+		0xC0, 0x0C, // _sip._tcp.example.com.
+		0x00, 0x21, 0x00, 0x01, // TYPE=SRV,CLASS=IN
+		0x00, 0x00, 0x00, 0x3C, // TTL=60
+		0x00, 0x0C,
+
+		0x00, 0x0A, 0x00, 0x05, 0x13, 0xC4,
+		0x03, 's', 'i', 'p', 0xC0, 0x16, // sip.example.com.
+	}
+
+	msg := new(Message)
+	if _, err := msg.Unpack(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &SRV{Priority: 10, Weight: 5, Port: 5060, Target: "sip.example.com."}
+	if got := msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want SRV %+v, got %+v", want, got)
+	}
+}
+
+func TestUnknownRecord(t *testing.T) {
+	t.Parallel()
+
+	rdata := []byte{
+		0x08, '-', '3', '2', '.', '6', '8', '8', '2', // longitude
+		0x08, '1', '1', '6', '.', '8', '6', '5', '2', // latitude
+		0x04, '1', '0', '.', '0', // altitude
+	}
+
+	raw := append([]byte{
+		0x00, 0x1B, 0x80, 0x00, // ID=27, QR=1
+		0x00, 0x01, 0x00, 0x01, // QDCOUNT=1, ANCOUNT=1
+		0x00, 0x00, 0x00, 0x00, // NSCOUNT=0, ARCOUNT=0
+
+		0x04, 'g', 'p', 'o', 's', 0x00,
+		0x00, 0x1B, 0x00, 0x01, // TYPE=GPOS,CLASS=IN
+
+		0xC0, 0x0C, // gpos.
+		0x00, 0x1B, 0x00, 0x01, // TYPE=GPOS,CLASS=IN
+		0x00, 0x00, 0x00, 0x3C, // TTL=60
+		0x00, byte(len(rdata)),
+	}, rdata...)
+
+	msg := new(Message)
+	if _, err := msg.Unpack(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &UnknownRecord{RRType: TypeGPOS, Data: rdata}
+	if got := msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Fatalf("want record %+v, got %+v", want, got)
+	}
+	if want, got := `\# 23 082d33322e36383832083131362e383635320431302e30`, want.RData(); want != got {
+		t.Errorf("want RDATA %q, got %q", want, got)
+	}
+
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := raw, buf; !bytes.Equal(want, got) {
+		t.Errorf("want re-encoded message %x, got %x", want, got)
+	}
+}
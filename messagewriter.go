@@ -16,6 +16,9 @@ type MessageWriter interface {
 
 	// Answer adds a record to the answers section.
 	Answer(string, time.Duration, Record)
+	// AnswerAll adds records with the same name and TTL to the answers
+	// section.
+	AnswerAll(string, time.Duration, ...Record)
 	// Authority adds a record to the authority section.
 	Authority(string, time.Duration, Record)
 	// Additional adds a record to the additional section
@@ -30,6 +33,19 @@ type MessageWriter interface {
 	// For large messages sent over a UDP connection, an ErrTruncatedMessage
 	// error is returned if the message was truncated.
 	Reply(context.Context) error
+
+	// SendRaw sends a pre-encoded response message in place of the
+	// response built by the writer. The message ID is patched to match the
+	// query.
+	//
+	// Like Reply, messages too large for a UDP connection are truncated, and
+	// an ErrTruncatedMessage error is returned if the TC bit was set.
+	//
+	// The writers of the handlers of a ResolveMux, which answer a single
+	// question of the query each, cannot send a raw message for the whole
+	// query, and return ErrUnsupportedOp; the handler then adds its records
+	// to the response instead.
+	SendRaw([]byte) error
 }
 
 type messageWriter struct {
@@ -44,6 +60,12 @@ func (w *messageWriter) Answer(fqdn string, ttl time.Duration, rec Record) {
 	w.msg.Answers = append(w.msg.Answers, w.rr(fqdn, ttl, rec))
 }
 
+func (w *messageWriter) AnswerAll(fqdn string, ttl time.Duration, recs ...Record) {
+	for _, rec := range recs {
+		w.Answer(fqdn, ttl, rec)
+	}
+}
+
 func (w *messageWriter) Authority(fqdn string, ttl time.Duration, rec Record) {
 	w.msg.Authorities = append(w.msg.Authorities, w.rr(fqdn, ttl, rec))
 }
@@ -53,9 +75,14 @@ func (w *messageWriter) Additional(fqdn string, ttl time.Duration, rec Record) {
 }
 
 func (w *messageWriter) rr(fqdn string, ttl time.Duration, rec Record) Resource {
+	class := ClassIN
+	if rec.Type() == TypeOPT {
+		class = Class(maxEDNSPacketLen) // the UDP payload size of the responder
+	}
+
 	return Resource{
 		Name:   fqdn,
-		Class:  ClassIN,
+		Class:  class,
 		TTL:    ttl,
 		Record: rec,
 	}
@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	errReverseAddr = errors.New("invalid IP address for reverse name")
+	errNSEC3Name   = errors.New("invalid name for NSEC3 hash")
+)
+
+// JoinName returns the fully-qualified domain name made up of labels, in
+// presentation format. Dots and backslashes within a label are escaped with a
+// backslash, and other non-printable bytes as \DDD. Empty labels are skipped,
+// as only the root label is empty, and a name without labels is the root name
+// ".".
+func JoinName(labels ...string) string {
+	var b strings.Builder
+	for _, label := range labels {
+		if label == "" {
+			continue
+		}
+
+		for i := 0; i < len(label); i++ {
+			switch c := label[i]; {
+			case c == '.' || c == '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case c < '!' || c > '~':
+				b.WriteByte('\\')
+				b.WriteString(strconv.Itoa(int(c) + 1000)[1:])
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('.')
+	}
+	if b.Len() == 0 {
+		return "."
+	}
+	return b.String()
+}
+
+// SplitName returns the labels of name in presentation format, with escapes
+// decoded. The trailing dot is optional, and the root name has no labels.
+func SplitName(name string) []string {
+	var (
+		labels []string
+		start  int
+	)
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '\\':
+			i++
+		case '.':
+			labels = append(labels, unescapeLabel(name[start:i]))
+			start = i + 1
+		}
+	}
+	if start < len(name) {
+		labels = append(labels, unescapeLabel(name[start:]))
+	}
+	if len(labels) == 1 && labels[0] == "" {
+		return nil
+	}
+	return labels
+}
+
+func unescapeLabel(s string) string {
+	label, err := unescapeZoneString(s)
+	if err != nil {
+		return s
+	}
+	return label
+}
+
+// ReverseAddr returns the owner name of the PTR record of ip, under
+// in-addr.arpa. for IPv4 addresses (RFC 1035, section 3.5) and ip6.arpa. for
+// IPv6 addresses (RFC 3596, section 2.5).
+func ReverseAddr(ip net.IP) (string, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return JoinName(
+			strconv.Itoa(int(ip4[3])),
+			strconv.Itoa(int(ip4[2])),
+			strconv.Itoa(int(ip4[1])),
+			strconv.Itoa(int(ip4[0])),
+			"in-addr", "arpa",
+		), nil
+	}
+
+	ip6 := ip.To16()
+	if ip6 == nil {
+		return "", errReverseAddr
+	}
+
+	const hexDigits = "0123456789abcdef"
+
+	b := make([]byte, 0, 4*len(ip6)+len("ip6.arpa."))
+	for i := len(ip6) - 1; i >= 0; i-- {
+		b = append(b, hexDigits[ip6[i]&0xF], '.', hexDigits[ip6[i]>>4], '.')
+	}
+	return string(append(b, "ip6.arpa."...)), nil
+}
+
+// nsec3Encoding is the unpadded, lowercase Base32 encoding with extended hex
+// alphabet of NSEC3 owner names (RFC 5155, section 3.3).
+var nsec3Encoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// NSEC3HashName returns the owner name of the NSEC3 record that matches name
+// in zone, using the SHA-1 hash algorithm with the salt and number of
+// additional iterations, as defined in RFC 5155, section 5.
+func NSEC3HashName(name, zone string, salt []byte, iterations uint16) (string, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	if err := checkName(name, false); err != nil {
+		return "", errNSEC3Name
+	}
+
+	// The hash is over the canonical wire format of the name, with
+	// uppercase letters lowercased (RFC 4034, section 6.2).
+	x, err := compressor{}.Pack(nil, strings.ToLower(name))
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	h.Write(x)
+	h.Write(salt)
+	sum := h.Sum(nil)
+	for i := uint16(0); i < iterations; i++ {
+		h.Reset()
+		h.Write(sum)
+		h.Write(salt)
+		sum = h.Sum(sum[:0])
+	}
+
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	if zone == "." {
+		return nsec3Encoding.EncodeToString(sum) + ".", nil
+	}
+	return nsec3Encoding.EncodeToString(sum) + "." + zone, nil
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestJoinSplitName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		labels []string
+	}{
+		{".", nil},
+		{"example.com.", []string{"example", "com"}},
+		{`a\.b.example.com.`, []string{"a.b", "example", "com"}},
+		{`back\\slash.example.`, []string{`back\slash`, "example"}},
+		{`sp\032ace.example.`, []string{"sp ace", "example"}},
+		{"_sip._tcp.example.com.", []string{"_sip", "_tcp", "example", "com"}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if want, got := test.name, JoinName(test.labels...); want != got {
+				t.Errorf("want joined name %q, got %q", want, got)
+			}
+			if want, got := test.labels, SplitName(test.name); !reflect.DeepEqual(want, got) {
+				t.Errorf("want labels %q, got %q", want, got)
+			}
+		})
+	}
+
+	if want, got := []string{"a.b", "example"}, SplitName(`a\046b.example`); !reflect.DeepEqual(want, got) {
+		t.Errorf("want labels %q, got %q", want, got)
+	}
+
+	if want, got := "www.example.", JoinName("www", "", "example", ""); want != got {
+		t.Errorf("want joined name %q, got %q", want, got)
+	}
+	if want, got := ".", JoinName(""); want != got {
+		t.Errorf("want joined name %q, got %q", want, got)
+	}
+}
+
+func TestReverseAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ip   net.IP
+		name string
+	}{
+		{net.IPv4(192, 0, 2, 1), "1.2.0.192.in-addr.arpa."},
+		{net.ParseIP("2001:db8::567:89ab"), "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+
+	for _, test := range tests {
+		name, err := ReverseAddr(test.ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := test.name, name; want != got {
+			t.Errorf("want reverse name %q, got %q", want, got)
+		}
+	}
+
+	if _, err := ReverseAddr(nil); err != errReverseAddr {
+		t.Errorf("want error %q, got %v", errReverseAddr, err)
+	}
+}
+
+func TestNSEC3HashName(t *testing.T) {
+	t.Parallel()
+
+	// RFC 5155, appendix A.
+	salt := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+
+	tests := []struct {
+		name, hashed string
+	}{
+		{"example.", "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom.example."},
+		{"a.example.", "35mthgpgcu1qg68fab165klnsnk3dpvl.example."},
+		{"ns1.example.", "2t7b4g4vsa5smi47k61mv5bv1a22bojr.example."},
+		{"xx.example", "t644ebqk9bibcna874givr6joj62mlhv.example."},
+		{"A.EXAMPLE.", "35mthgpgcu1qg68fab165klnsnk3dpvl.example."},
+	}
+
+	for _, test := range tests {
+		hashed, err := NSEC3HashName(test.name, "example.", salt, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := test.hashed, hashed; want != got {
+			t.Errorf("%s: want hashed name %q, got %q", test.name, want, got)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package dns
 
 import (
 	"io"
+	"math/rand"
+	"net"
+	"os"
 	"sync"
 	"time"
 )
@@ -9,11 +12,26 @@ import (
 type pipeline struct {
 	Conn
 
+	// reassign rewrites the ID of a message that conflicts with an inflight
+	// query instead of failing with ErrConflictingID.
+	reassign bool
+
+	// packet skips malformed messages instead of failing the pipeline, as
+	// befits a packet-oriented connection.
+	packet bool
+
+	// from, if non-nil, returns the source address of the last received
+	// message. A message from an address other than the destination of its
+	// query is discarded.
+	from func() net.Addr
+
 	rmu, wmu sync.Mutex
 
 	mu       sync.Mutex
 	inflight map[int]pipelineTx
 	readerr  error
+
+	donec chan struct{} // closed when the connection fails
 }
 
 func (p *pipeline) alive() bool {
@@ -23,6 +41,29 @@ func (p *pipeline) alive() bool {
 	return p.readerr == nil
 }
 
+// healthy reports whether the pipeline is alive. If no queries are inflight,
+// it waits up to timeout for the connection to fail, such as when the peer
+// has closed it but the failure has not been observed yet.
+func (p *pipeline) healthy(timeout time.Duration) bool {
+	p.mu.Lock()
+	idle, alive := len(p.inflight) == 0, p.readerr == nil
+	p.mu.Unlock()
+
+	if !idle || !alive {
+		return alive
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.donec:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 func (p *pipeline) conn() Conn {
 	return &pipelineConn{
 		pipeline: p,
@@ -40,13 +81,22 @@ func (p *pipeline) run() {
 
 		p.rmu.Lock()
 		if err = p.Recv(&msg); err != nil {
+			if _, ok := err.(net.Error); p.packet && !ok {
+				p.rmu.Unlock()
+				continue
+			}
 			break
 		}
 		p.rmu.Unlock()
 
 		p.mu.Lock()
 		tx, ok := p.inflight[msg.ID]
-		delete(p.inflight, msg.ID)
+		if ok && p.from != nil && !sameAddr(tx.addr, p.from()) {
+			ok = false
+		}
+		if ok {
+			delete(p.inflight, msg.ID)
+		}
 		p.mu.Unlock()
 
 		if !ok {
@@ -65,6 +115,8 @@ func (p *pipeline) run() {
 	}
 	p.mu.Unlock()
 
+	close(p.donec)
+
 	for _, tx := range txs {
 		go tx.deliver(msgerr{err: err})
 	}
@@ -76,20 +128,37 @@ type pipelineConn struct {
 	aborto sync.Once
 	tx     pipelineTx
 
+	// w, if non-nil, sends messages in place of the pipeline Conn.
+	w Conn
+
+	id, origID int
+
 	readDeadline, writeDeadline time.Time
 }
 
 func (c *pipelineConn) Close() error {
 	c.aborto.Do(c.tx.abort)
+	c.unregister()
 	return nil
 }
 
 func (c *pipelineConn) Recv(msg *Message) error {
+	var timeoutc <-chan time.Time
+	if !c.readDeadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.readDeadline))
+		defer timer.Stop()
+
+		timeoutc = timer.C
+	}
+
 	var me msgerr
 	select {
 	case me = <-c.tx.msgerrc:
 	case <-c.tx.abortc:
 		return io.ErrUnexpectedEOF
+	case <-timeoutc:
+		c.unregister()
+		return os.ErrDeadlineExceeded
 	}
 
 	if err := me.err; err != nil {
@@ -97,22 +166,34 @@ func (c *pipelineConn) Recv(msg *Message) error {
 	}
 
 	*msg = *me.msg // shallow copy
+	msg.ID = c.origID
 	return nil
 }
 
 func (c *pipelineConn) Send(msg *Message) error {
-	if err := c.register(msg); err != nil {
+	id, err := c.register(msg)
+	if err != nil {
 		return err
 	}
+	if id != msg.ID {
+		m := *msg // shallow copy
+		m.ID = id
+		msg = &m
+	}
+
+	conn := c.Conn
+	if c.w != nil {
+		conn = c.w
+	}
 
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
 
-	if err := c.Conn.SetWriteDeadline(c.writeDeadline); err != nil {
+	if err := conn.SetWriteDeadline(c.writeDeadline); err != nil {
 		return err
 	}
 
-	return c.Conn.Send(msg)
+	return conn.Send(msg)
 }
 
 func (c *pipelineConn) SetDeadline(t time.Time) error {
@@ -131,21 +212,47 @@ func (c *pipelineConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
-func (c *pipelineConn) register(msg *Message) error {
+func (c *pipelineConn) register(msg *Message) (int, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, ok := c.inflight[msg.ID]; ok {
-		return ErrConflictingID
+	id := msg.ID
+	if _, ok := c.inflight[id]; ok {
+		if !c.reassign || len(c.inflight) > idMask {
+			return 0, ErrConflictingID
+		}
+
+		for ok {
+			id = rand.Intn(idMask + 1)
+			_, ok = c.inflight[id]
+		}
 	}
 
-	c.inflight[msg.ID] = c.tx
-	return nil
+	tx := c.tx
+	if c.w != nil {
+		tx.addr = c.w.RemoteAddr()
+	}
+
+	c.id, c.origID = id, msg.ID
+	c.inflight[id] = tx
+	return id, nil
+}
+
+// unregister removes the inflight query of c, if it has not been answered.
+func (c *pipelineConn) unregister() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tx, ok := c.inflight[c.id]; ok && tx.msgerrc == c.tx.msgerrc {
+		delete(c.inflight, c.id)
+	}
 }
 
 type pipelineTx struct {
 	msgerrc chan msgerr
 	abortc  chan struct{}
+
+	addr net.Addr // destination of the query, if sent with WriteTo
 }
 
 func (p pipelineTx) abort() { close(p.abortc) }
@@ -156,3 +263,18 @@ func (p pipelineTx) deliver(me msgerr) {
 	case <-p.abortc:
 	}
 }
+
+// sameAddr reports whether a and b are the same address. UDP addresses are
+// compared by IP and port, so that IPv4 and IPv4-mapped IPv6 forms match.
+func sameAddr(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	ua, aok := a.(*net.UDPAddr)
+	ub, bok := b.(*net.UDPAddr)
+	if aok && bok {
+		return ua.IP.Equal(ub.IP) && ua.Port == ub.Port
+	}
+	return a.Network() == b.Network() && a.String() == b.String()
+}
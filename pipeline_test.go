@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -87,3 +88,97 @@ func TestPipeline(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPipelineReassignConflictingIDs(t *testing.T) {
+	t.Parallel()
+
+	stallc := make(chan struct{})
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		q := r.Questions[0]
+		if q == questions["A"] {
+			<-stallc
+		}
+		w.Answer(q.Name, time.Minute, answers[q])
+	}))
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tport := &Transport{ReassignConflictingIDs: true}
+
+	conn1, err := tport.DialAddr(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn1.Send(&Message{ID: 7, Questions: []Question{questions["A"]}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn2, err := tport.DialAddr(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn2.Send(&Message{ID: 7, Questions: []Question{questions["AAAA"]}}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := new(Message)
+	if err := conn2.Recv(msg); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 7, msg.ID; want != got {
+		t.Errorf("want response message ID %d, got %d", want, got)
+	}
+	if want, got := answers[questions["AAAA"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want AAAA answer %v, got %v", want, got)
+	}
+
+	close(stallc)
+
+	msg = new(Message)
+	if err := conn1.Recv(msg); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 7, msg.ID; want != got {
+		t.Errorf("want response message ID %d, got %d", want, got)
+	}
+	if want, got := answers[questions["A"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want A answer %v, got %v", want, got)
+	}
+}
+
+func TestPipelineConflictingID(t *testing.T) {
+	t.Parallel()
+
+	stallc := make(chan struct{})
+	defer close(stallc)
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		<-stallc
+	}))
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tport := new(Transport)
+
+	conn1, err := tport.DialAddr(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn1.Send(&Message{ID: 7, Questions: []Question{questions["A"]}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn2, err := tport.DialAddr(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ErrConflictingID, conn2.Send(&Message{ID: 7, Questions: []Question{questions["AAAA"]}}); want != got {
+		t.Errorf("want error %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,232 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	errCNAMELoop  = errors.New("CNAME loop")
+	errCNAMEDepth = errors.New("too many CNAME records")
+)
+
+// DefaultMaxCNAMEDepth is the default number of CNAME records a Resolver
+// follows for a lookup.
+const DefaultMaxCNAMEDepth = 8
+
+// Resolver looks up names with a Client, in the manner of the lookup methods
+// of a net.Resolver. CNAME records are followed, and their targets queried if
+// the response does not include the records of the target.
+//
+// A Resolver is not a Handler, unlike the Resolver field of a Client, which
+// answers queries in place of the Servers of the Client.
+type Resolver struct {
+	// Client sends the queries to its Servers.
+	Client *Client
+
+	// MaxCNAMEDepth is the maximum number of CNAME records followed for a
+	// lookup. If zero, DefaultMaxCNAMEDepth is used.
+	MaxCNAMEDepth int
+
+	// Cache, if set, answers the queries of every lookup method before they
+	// are sent with Client, and caches the responses. Concurrent lookups of
+	// the same name share a single query.
+	Cache *Cache
+
+	// DNS64Prefix, if set, is the NAT64 prefix of the IPv6 addresses
+	// synthesized by LookupIPAddr for names with IPv4 addresses and no IPv6
+	// addresses, as described in RFC 6147. DefaultDNS64Prefix is the
+	// Well-Known Prefix.
+	DNS64Prefix *net.IPNet
+}
+
+// LookupHost looks up the IPv4 and IPv6 addresses of host, and returns them as
+// strings.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		hosts = append(hosts, addr.String())
+	}
+	return hosts, nil
+}
+
+// LookupIPAddr looks up the IPv4 and IPv6 addresses of host.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var (
+		addrs []net.IPAddr
+		errs  []error
+
+		v4   []net.IP
+		aaaa bool
+	)
+	for _, qtype := range []Type{TypeA, TypeAAAA} {
+		_, recs, err := r.lookup(ctx, host, qtype)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, rec := range recs {
+			switch rec := rec.(type) {
+			case *A:
+				addrs = append(addrs, net.IPAddr{IP: rec.A})
+				v4 = append(v4, rec.A)
+			case *AAAA:
+				addrs = append(addrs, net.IPAddr{IP: rec.AAAA})
+				aaaa = true
+			}
+		}
+	}
+
+	// the NODATA response to the AAAA query is synthesized from the A
+	// records
+	if r.DNS64Prefix != nil && len(errs) == 0 && !aaaa {
+		for _, ip := range v4 {
+			addrs = append(addrs, net.IPAddr{IP: embedIPv4(r.DNS64Prefix, ip)})
+		}
+	}
+
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// LookupCNAME returns the canonical name of host, after following zero or more
+// CNAME records.
+func (r *Resolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	cname, _, err := r.lookup(ctx, host, TypeA)
+	return cname, err
+}
+
+// lookup returns the records of type qtype of host, or of the target of its
+// CNAME chain, along with the final name of the chain.
+func (r *Resolver) lookup(ctx context.Context, host string, qtype Type) (string, []Record, error) {
+	maxDepth := r.MaxCNAMEDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxCNAMEDepth
+	}
+
+	name := host
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	seen := map[string]bool{strings.ToLower(name): true}
+	for depth := 0; ; {
+		msg, err := r.query(ctx, name, qtype)
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch msg.RCode {
+		case NoError:
+		case NXDomain:
+			return "", nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		default:
+			return "", nil, &net.DNSError{Err: "server misbehaving", Name: host}
+		}
+
+		// follow the CNAME chain through the answers
+		queried := name
+		for {
+			target, ok := cnameOf(msg.Answers, name)
+			if !ok {
+				break
+			}
+			if depth++; depth > maxDepth {
+				return "", nil, errCNAMEDepth
+			}
+			if seen[strings.ToLower(target)] {
+				return "", nil, errCNAMELoop
+			}
+			seen[strings.ToLower(target)] = true
+			name = target
+		}
+
+		var recs []Record
+		for _, rr := range msg.Answers {
+			if rr.Record.Type() == qtype && strings.EqualFold(rr.Name, name) {
+				recs = append(recs, rr.Record)
+			}
+		}
+
+		// the records of a CNAME target missing from the response are
+		// queried for the target
+		if len(recs) > 0 || name == queried {
+			return name, recs, nil
+		}
+	}
+}
+
+// query sends the query for name and qtype with r.Client, or answers it from
+// r.Cache.
+func (r *Resolver) query(ctx context.Context, name string, qtype Type) (*Message, error) {
+	if r.Cache == nil {
+		return r.Client.Lookup(ctx, name, qtype)
+	}
+
+	query := &Query{
+		Message: &Message{
+			RecursionDesired: true,
+			Questions: []Question{
+				{Name: name, Type: qtype, Class: ClassIN},
+			},
+		},
+	}
+
+	w := &resolverWriter{
+		messageWriter: &messageWriter{
+			msg: response(query.Message),
+		},
+	}
+
+	// the upstream query shared by concurrent lookups is sent by r.Client,
+	// so that it outlives the lookup that started it
+	err := r.Cache.serve(ctx, w, query, r.Client)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response(w.msg), nil
+}
+
+// resolverWriter is the MessageWriter of the queries of a Resolver answered by
+// its Cache. Upstream queries are sent by the Client of the Resolver.
+type resolverWriter struct {
+	*messageWriter
+}
+
+func (w *resolverWriter) Recur(context.Context) (*Message, error) {
+	return nil, ErrUnsupportedOp
+}
+
+func (w *resolverWriter) Reply(context.Context) error {
+	return ErrUnsupportedOp
+}
+
+func (w *resolverWriter) SendRaw([]byte) error {
+	return ErrUnsupportedOp
+}
+
+// cnameOf returns the target of the CNAME record of name in rrs.
+func cnameOf(rrs []Resource, name string) (string, bool) {
+	for _, rr := range rrs {
+		if rec, ok := rr.Record.(*CNAME); ok && strings.EqualFold(rr.Name, name) {
+			return rec.CNAME, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,352 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	cnames := map[string]string{
+		"www.example.":   "web.example.",
+		"alias.example.": "www.example.",
+		"loop1.example.": "loop2.example.",
+		"loop2.example.": "loop1.example.",
+		"self.example.":  "SELF.example.",
+	}
+	for i := 0; i < 10; i++ {
+		cnames["d"+strconv.Itoa(i)+".example."] = "d" + strconv.Itoa(i+1) + ".example."
+	}
+
+	addrs := map[string][]Record{
+		"web.example.": {
+			&A{A: net.IPv4(192, 0, 2, 1).To4()},
+			&AAAA{AAAA: net.ParseIP("2001:db8::1")},
+		},
+		"d10.example.": {
+			&A{A: net.IPv4(192, 0, 2, 10).To4()},
+		},
+	}
+
+	// the server answers a single CNAME record, without the records of the
+	// target, so that the resolver queries each target of a chain
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		q := r.Questions[0]
+		if cname, ok := cnames[q.Name]; ok {
+			w.Answer(q.Name, time.Minute, &CNAME{CNAME: cname})
+			return
+		}
+
+		recs, ok := addrs[q.Name]
+		if !ok {
+			w.Status(NXDomain)
+			return
+		}
+		for _, rec := range recs {
+			if rec.Type() == q.Type {
+				w.Answer(q.Name, time.Minute, rec)
+			}
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rlv := &Resolver{
+		Client: &Client{Servers: []net.Addr{addr}},
+	}
+
+	web := []net.IPAddr{
+		{IP: net.IPv4(192, 0, 2, 1).To4()},
+		{IP: net.ParseIP("2001:db8::1")},
+	}
+
+	tests := []struct {
+		host string
+
+		addrs []net.IPAddr
+		cname string
+		err   error
+	}{
+		{
+			host: "web.example",
+
+			addrs: web,
+			cname: "web.example.",
+		},
+		{
+			host: "alias.example.",
+
+			addrs: web,
+			cname: "web.example.",
+		},
+		{
+			host: "d2.example.",
+
+			addrs: []net.IPAddr{{IP: net.IPv4(192, 0, 2, 10).To4()}},
+			cname: "d10.example.",
+		},
+		{
+			host: "d0.example.",
+
+			err: errCNAMEDepth,
+		},
+		{
+			host: "loop1.example.",
+
+			err: errCNAMELoop,
+		},
+		{
+			host: "self.example.",
+
+			err: errCNAMELoop,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.host, func(t *testing.T) {
+			t.Parallel()
+
+			addrs, err := rlv.LookupIPAddr(context.Background(), test.host)
+			if want, got := test.err, err; want != got {
+				t.Fatalf("want LookupIPAddr error %v, got %v", want, got)
+			}
+			if want, got := test.addrs, addrs; !reflect.DeepEqual(want, got) {
+				t.Errorf("want addrs %v, got %v", want, got)
+			}
+
+			cname, err := rlv.LookupCNAME(context.Background(), test.host)
+			if want, got := test.err, err; want != got {
+				t.Fatalf("want LookupCNAME error %v, got %v", want, got)
+			}
+			if want, got := test.cname, cname; want != got {
+				t.Errorf("want CNAME %q, got %q", want, got)
+			}
+		})
+	}
+
+	t.Run("not-found", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := rlv.LookupIPAddr(context.Background(), "missing.example.")
+		if derr, ok := err.(*net.DNSError); !ok || !derr.IsNotFound {
+			t.Errorf("want not found DNS error, got %v", err)
+		}
+	})
+}
+
+func TestResolverCache(t *testing.T) {
+	t.Parallel()
+
+	var nquery int32
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&nquery, 1)
+		time.Sleep(50 * time.Millisecond)
+
+		switch q := r.Questions[0]; q.Type {
+		case TypeA:
+			w.Answer(q.Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		case TypeAAAA:
+			w.Answer(q.Name, time.Minute, &AAAA{AAAA: net.IPv6loopback})
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rlv := &Resolver{
+		Client: &Client{Servers: []net.Addr{addr}},
+		Cache:  new(Cache),
+	}
+
+	var g errgroup.Group
+	for i := 0; i < 2; i++ {
+		g.Go(func() error {
+			hosts, err := rlv.LookupHost(context.Background(), "app.local.")
+			if err != nil {
+				return err
+			}
+			if want, got := []string{"127.0.0.1", "::1"}, hosts; !reflect.DeepEqual(want, got) {
+				return fmt.Errorf("want hosts %q, got %q", want, got)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// one upstream query for each of the A and AAAA lookups
+	if want, got := int32(2), atomic.LoadInt32(&nquery); want != got {
+		t.Errorf("want %d upstream queries, got %d", want, got)
+	}
+
+	if _, err := rlv.LookupIPAddr(context.Background(), "app.local."); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int32(2), atomic.LoadInt32(&nquery); want != got {
+		t.Errorf("want %d upstream queries after a cached lookup, got %d", want, got)
+	}
+}
+
+func TestResolverCacheCanceled(t *testing.T) {
+	t.Parallel()
+
+	var nquery int32
+	startc, releasec := make(chan struct{}, 1), make(chan struct{})
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&nquery, 1)
+		startc <- struct{}{}
+		<-releasec
+
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rlv := &Resolver{
+		Client: &Client{Servers: []net.Addr{addr}},
+		Cache:  new(Cache),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	firstc := make(chan error, 1)
+	go func() {
+		_, err := rlv.LookupCNAME(ctx, "slow.local.")
+		firstc <- err
+	}()
+	<-startc
+
+	secondc := make(chan error, 1)
+	go func() {
+		cname, err := rlv.LookupCNAME(context.Background(), "slow.local.")
+		if err == nil && cname != "slow.local." {
+			err = fmt.Errorf("want CNAME %q, got %q", "slow.local.", cname)
+		}
+		secondc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the first lookup returns when canceled, without canceling the query
+	// shared with the second lookup
+	cancel()
+	select {
+	case err := <-firstc:
+		if want, got := context.Canceled, err; want != got {
+			t.Errorf("want canceled lookup error %v, got %v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("canceled lookup did not return")
+	}
+
+	close(releasec)
+	if err := <-secondc; err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int32(1), atomic.LoadInt32(&nquery); want != got {
+		t.Errorf("want %d upstream queries, got %d", want, got)
+	}
+}
+
+func TestResolverDNS64(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&Zone{
+		Origin: "localhost.",
+		TTL:    time.Hour,
+		SOA:    localhostZone.SOA,
+		RRs: RRSet{
+			"v4only": {
+				TypeA: {&A{net.IPv4(10, 42, 0, 4).To4()}},
+			},
+			"dual": {
+				TypeA:    {&A{net.IPv4(10, 42, 0, 6).To4()}},
+				TypeAAAA: {&AAAA{net.ParseIP("dead:beef::6")}},
+			},
+		},
+	})
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, prefix, err := net.ParseCIDR("2001:db8:64::/96")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		prefix *net.IPNet
+		host   string
+
+		addrs []string
+	}{
+		{
+			name:   "configured-prefix",
+			prefix: prefix,
+			host:   "v4only.localhost.",
+			addrs:  []string{"10.42.0.4", "2001:db8:64::a2a:4"},
+		},
+		{
+			name:   "well-known-prefix",
+			prefix: DefaultDNS64Prefix,
+			host:   "v4only.localhost.",
+			addrs:  []string{"10.42.0.4", "64:ff9b::a2a:4"},
+		},
+		{
+			name:  "disabled",
+			host:  "v4only.localhost.",
+			addrs: []string{"10.42.0.4"},
+		},
+		{
+			name:   "native-aaaa",
+			prefix: prefix,
+			host:   "dual.localhost.",
+			addrs:  []string{"10.42.0.6", "dead:beef::6"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			rlv := &Resolver{
+				Client:      &Client{Servers: []net.Addr{addr}},
+				DNS64Prefix: test.prefix,
+			}
+
+			hosts, err := rlv.LookupHost(context.Background(), test.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := test.addrs, hosts; !reflect.DeepEqual(want, got) {
+				t.Errorf("want addrs %q, got %q", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+// +build linux,!mips,!mipsle,!mips64,!mips64le,!sparc64
+
+package dns
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// soReuseport is the SO_REUSEPORT socket option, which is not defined by
+// package syscall on Linux. The value differs on MIPS and SPARC.
+const soReuseport = 0xF
+
+// ListenUDPReuseport listens on the UDP network address addr with the
+// SO_REUSEPORT socket option, so that more than one socket may be bound to the
+// same address. The kernel distributes incoming packets among the sockets.
+//
+// To scale a UDP server across CPU cores, Serve each of N sockets with
+// ServePacket:
+//
+//	for i := 0; i < runtime.NumCPU(); i++ {
+//		conn, err := dns.ListenUDPReuseport(addr)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		go srv.ServePacket(ctx, conn)
+//	}
+func ListenUDPReuseport(addr string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReuseport, 1)
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	return lc.ListenPacket(context.Background(), "udp", addr)
+}
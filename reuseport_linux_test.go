@@ -0,0 +1,64 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !sparc64
+// +build linux,!mips,!mipsle,!mips64,!mips64le,!sparc64
+
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListenUDPReuseport(t *testing.T) {
+	t.Parallel()
+
+	conn1, err := ListenUDPReuseport("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+
+	addr := conn1.LocalAddr().String()
+
+	conn2, err := ListenUDPReuseport(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	var counts [2]int32
+	for i, conn := range []net.PacketConn{conn1, conn2} {
+		i, conn := i, conn
+
+		go func() {
+			buf := make([]byte, 512)
+			for {
+				if _, _, err := conn.ReadFrom(buf); err != nil {
+					return
+				}
+				atomic.AddInt32(&counts[i], 1)
+			}
+		}()
+	}
+
+	// packets are distributed by source address, so send from many ports
+	for i := 0; i < 256; i++ {
+		if atomic.LoadInt32(&counts[0]) > 0 && atomic.LoadInt32(&counts[1]) > 0 {
+			return
+		}
+
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Errorf("want packets on both sockets, got %d and %d", atomic.LoadInt32(&counts[0]), atomic.LoadInt32(&counts[1]))
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"context"
+	"time"
+)
+
+// ScheduleHandler serves queries with a handler chosen by the time of the
+// query, such as for answers that are only served during a maintenance
+// window.
+type ScheduleHandler struct {
+	// Default handles queries outside of every schedule. If nil, those
+	// queries are answered with a "Query Refused" message.
+	Default Handler
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+
+	tbl []scheduleEntry
+}
+
+type scheduleEntry struct {
+	active func(time.Time) bool
+	h      Handler
+}
+
+// Handle registers the handler for queries at times active reports true. The
+// schedules are evaluated in the order they were registered.
+func (s *ScheduleHandler) Handle(active func(time.Time) bool, h Handler) {
+	s.tbl = append(s.tbl, scheduleEntry{active: active, h: h})
+}
+
+// ServeDNS dispatches the query to the handler of the first schedule active at
+// the current time.
+func (s *ScheduleHandler) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now()
+
+	h := s.Default
+	for _, e := range s.tbl {
+		if e.active(t) {
+			h = e.h
+			break
+		}
+	}
+	if h == nil {
+		h = HandlerFunc(Refuse)
+	}
+
+	h.ServeDNS(ctx, w, r)
+}
+
+// DailyWindow returns a schedule active from start until end past midnight of
+// each day, in the location of the time. A window with an end before its start
+// spans midnight.
+func DailyWindow(start, end time.Duration) func(time.Time) bool {
+	return func(t time.Time) bool {
+		y, m, d := t.Date()
+		since := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+
+		if end < start {
+			return since >= start || since < end
+		}
+		return since >= start && since < end
+	}
+}
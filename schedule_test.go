@@ -0,0 +1,138 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduleHandler(t *testing.T) {
+	t.Parallel()
+
+	answerA := func(ip net.IP) Handler {
+		return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: ip.To4()})
+		})
+	}
+
+	var (
+		mu  sync.Mutex
+		now time.Time
+	)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return now
+	}
+
+	sched := &ScheduleHandler{
+		Default: answerA(net.IPv4(192, 0, 2, 1)),
+		Now:     clock,
+	}
+	sched.Handle(DailyWindow(2*time.Hour, 4*time.Hour), answerA(net.IPv4(192, 0, 2, 99)))
+
+	srv := mustServer(sched)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		now time.Time
+
+		want net.IP
+	}{
+		{
+			name: "inside-window",
+
+			now: time.Date(2021, 3, 14, 3, 30, 0, 0, time.UTC),
+
+			want: net.IPv4(192, 0, 2, 99).To4(),
+		},
+		{
+			name: "window-start",
+
+			now: time.Date(2021, 3, 14, 2, 0, 0, 0, time.UTC),
+
+			want: net.IPv4(192, 0, 2, 99).To4(),
+		},
+		{
+			name: "window-end",
+
+			now: time.Date(2021, 3, 14, 4, 0, 0, 0, time.UTC),
+
+			want: net.IPv4(192, 0, 2, 1).To4(),
+		},
+		{
+			name: "outside-window",
+
+			now: time.Date(2021, 3, 14, 12, 0, 0, 0, time.UTC),
+
+			want: net.IPv4(192, 0, 2, 1).To4(),
+		},
+	}
+
+	// the clock is shared by the queries, so the tests run in order
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mu.Lock()
+			now = test.now
+			mu.Unlock()
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{
+						{Name: "www.example.com.", Type: TypeA, Class: ClassIN},
+					},
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 1, len(msg.Answers); want != got {
+				t.Fatalf("want %d answer, got %d", want, got)
+			}
+			if want, got := test.want, msg.Answers[0].Record.(*A).A; !reflect.DeepEqual(want, got) {
+				t.Errorf("want A record %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestDailyWindow(t *testing.T) {
+	t.Parallel()
+
+	overnight := DailyWindow(22*time.Hour, 2*time.Hour)
+
+	tests := []struct {
+		hour, min int
+
+		active bool
+	}{
+		{hour: 21, min: 59},
+		{hour: 22, active: true},
+		{hour: 23, min: 30, active: true},
+		{hour: 0, active: true},
+		{hour: 1, min: 59, active: true},
+		{hour: 2},
+		{hour: 12},
+	}
+
+	for _, test := range tests {
+		now := time.Date(2021, 3, 14, test.hour, test.min, 0, 0, time.UTC)
+		if want, got := test.active, overnight(now); want != got {
+			t.Errorf("%s: want active %t, got %t", now.Format("15:04"), want, got)
+		}
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"hash/fnv"
 	"io"
 	"log"
+	"math/rand"
 	"net"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/jjeffcaii/dns/edns"
 )
 
 // A Server defines parameters for running a DNS server. The zero value for
@@ -18,15 +24,144 @@ type Server struct {
 	TLSConfig *tls.Config // optional TLS config, used by ListenAndServeTLS
 
 	// Forwarder relays a recursive query. If nil, recursive queries are
-	// answered with a "Query Refused" message.
+	// answered with a "Query Refused" message. The query is relayed with
+	// the EDNS options of the client, and the options of the response are
+	// relayed back, except for the cookie, padding, and TCP keepalive
+	// options that only apply to the upstream hop.
 	Forwarder RoundTripper
 
+	// RecursionAvailable sets the Recursion Available (RA) bit of
+	// responses, for servers that offer recursion. Handlers may override it
+	// with the Recursion method of the MessageWriter.
+	RecursionAvailable bool
+
+	// UDPWorkers is the number of goroutines handling queries read by
+	// ServePacket. If zero, a new goroutine is started for each query.
+	UDPWorkers int
+
+	// StrictNames answers queries with malformed question names, such as
+	// empty or oversized labels, oversized names, or labels that are not
+	// letters, digits, and hyphens, with a "Format Error" message. Other
+	// malformed queries are also answered with a format error, rather than
+	// dropped, if the message header is readable.
+	StrictNames bool
+
+	// AnyPolicy controls how questions for all records (QTYPE "*") are
+	// answered, over both UDP and TCP. The zero value passes them to
+	// Handler.
+	AnyPolicy AnyPolicy
+
+	// NoOPTReflection disables adding a minimal OPT record to the response
+	// of an EDNS query when the handler did not add one. By default, the
+	// response to every query with an OPT record carries one, so that
+	// clients do not infer that EDNS is unsupported.
+	NoOPTReflection bool
+
+	// RequireCookie answers EDNS queries without a valid DNS Cookie (RFC
+	// 7873) with a "Bad/missing Server Cookie" (BADCOOKIE) message, holding
+	// a fresh server cookie for the client to retry with. Queries without
+	// EDNS are refused.
+	RequireCookie bool
+
+	// CookieSecret is the secret key for server cookies. If nil, a random
+	// key is generated.
+	CookieSecret []byte
+
+	// ForceMaxUDPSize, if non-zero, is the size in bytes above which UDP
+	// responses are truncated, in place of the UDP payload size advertised
+	// by the query. It is meant for testing the truncation handling of
+	// clients.
+	ForceMaxUDPSize uint16
+
+	// MinimizeRRSetTTLs sets the TTL of every record in a response RRset to
+	// the lowest TTL of the set, as described in RFC 2181, section 5.2.
+	MinimizeRRSetTTLs bool
+
+	// StickyShuffle shuffles the records of each answer RRset in an order
+	// seeded by a hash of the client IP address, so that a client is always
+	// answered in the same order while the order varies across clients, such
+	// as for sticky load balancing.
+	StickyShuffle bool
+
+	// MaxRRsetSize, if non-zero, is the maximum number of records of an
+	// RRset included in a UDP response. Responses with records left out have
+	// the TC bit set, so that the client retries over TCP for the full set.
+	MaxRRsetSize int
+
+	// NegativeTTL, if non-zero, is the TTL of the SOA record in the authority
+	// section of negative (NXDOMAIN and NODATA) responses, such as those of a
+	// Zone or relayed by a Recursor, in place of the TTL added by the
+	// handler. A response is negative if its RCODE is NXDOMAIN or it has no
+	// answers; the SOA record of a positive response is left as is. The
+	// MINIMUM field of the SOA record is left as is, and resolvers cache the
+	// negative response for the lower of the two (RFC 2308, section 5).
+	NegativeTTL time.Duration
+
+	// AllowedClasses are the classes of the questions answered by the
+	// server, and queries with questions of other classes are refused. If
+	// empty, only IN class queries are answered. QCLASS * (ANY) and the CH
+	// class TXT "version.bind." query are always passed to the handler.
+	AllowedClasses []Class
+
+	// MinResponseLatency, if non-zero, is the minimum duration between
+	// reading a query and sending its response, so that the response time
+	// does not reveal whether the answer was cached. Each response is delayed
+	// in the goroutine serving its query. With UDPWorkers set, delayed
+	// responses are sent from a new goroutine, so that the worker serves
+	// other queries meanwhile.
+	MinResponseLatency time.Duration
+
+	// OnTruncate, if non-nil, is called with the query whenever a UDP
+	// response to it is truncated (the TC bit is set). A high rate of
+	// truncation is often a sign of EDNS problems.
+	OnTruncate func(*Query)
+
+	// OnStreamClose, if non-nil, is called with the remote address and the
+	// number of queries read from a TCP, TLS or WebSocket connection once
+	// serving it ends, such as for tuning the reuse of persistent
+	// connections. It is called on every return, whether the client closed
+	// the connection, reading from it failed, or the server shut down.
+	OnStreamClose func(addr net.Addr, queries int)
+
+	// ShutdownTimeout is the maximum duration Serve, ServeTLS, and
+	// ServePacket wait for the active queries to be answered once their
+	// context is canceled, before returning. If zero, they wait for every
+	// active query.
+	ShutdownTimeout time.Duration
+
 	// ErrorLog specifies an optional logger for errors accepting connections,
 	// reading data, and unpacking messages.
 	// If nil, logging is done via the log package's standard logger.
 	ErrorLog *log.Logger
+
+	cookieOnce sync.Once
+	cookieKey  []byte
+
+	shutmu   sync.Mutex
+	stops    map[interface{}]func() // stop reading new queries
+	nactive  int                    // queries being handled
+	shutdown bool
 }
 
+// AnyPolicy is a policy for answering questions for all records (QTYPE "*").
+type AnyPolicy int
+
+const (
+	// AnyPolicyFull passes ANY questions to the handler.
+	AnyPolicyFull AnyPolicy = iota
+
+	// AnyPolicyMinimal answers ANY questions with a single synthesized HINFO
+	// record, as described in RFC 8482, section 4.2.
+	AnyPolicyMinimal
+
+	// AnyPolicyRefuse answers queries with an ANY question with a "Refused"
+	// message, without calling the handler.
+	AnyPolicyRefuse
+)
+
+// anyMinimalTTL is the TTL of the synthesized RFC 8482 HINFO record.
+const anyMinimalTTL = time.Hour
+
 // ListenAndServe listens on both the TCP and UDP network address s.Addr and
 // then calls Serve or ServePacket to handle queries on incoming connections.
 // If srv.Addr is blank, ":domain" is used. ListenAndServe always returns a
@@ -80,17 +215,28 @@ func (s *Server) ListenAndServeTLS(ctx context.Context) error {
 //
 // See RFC 1035, section 4.2.2 "TCP usage" for transport encoding of messages.
 //
+// Canceling ctx shuts down the whole server, as by Shutdown, including the
+// other listeners and connections served by s, and Serve returns the context
+// error once the active queries are answered. To stop serving ln alone, close
+// it instead. Handlers are called with a context carrying the values of ctx
+// that is only canceled when Serve returns.
+//
 // Serve always returns a non-nil error.
 func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 	defer ln.Close()
 
+	defer s.watch(ctx, ln, func() { ln.Close() })()
+
+	hctx, cancel := context.WithCancel(detachedContext{ctx})
+	defer cancel()
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			return err
+			return s.serveErr(ctx, err)
 		}
 
-		go s.serveStream(ctx, conn)
+		go s.serveStream(hctx, conn)
 	}
 }
 
@@ -100,42 +246,91 @@ func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 //
 // See RFC 1035, section 4.2.1 "UDP usage" for transport encoding of messages.
 //
+// Canceling ctx shuts down the server, as for Serve.
+//
 // ServePacket always returns a non-nil error.
 func (s *Server) ServePacket(ctx context.Context, conn net.PacketConn) error {
 	defer conn.Close()
 
+	// replies are written to conn until the active queries are answered, so
+	// only reading is stopped
+	defer s.watch(ctx, conn, func() { conn.SetReadDeadline(aLongTimeAgo) })()
+
+	hctx, cancel := context.WithCancel(detachedContext{ctx})
+	defer cancel()
+
+	handle := func(pw *packetWriter, req *Query) { go s.serve(hctx, pw, req) }
+	if s.UDPWorkers > 0 {
+		type packet struct {
+			pw  *packetWriter
+			req *Query
+		}
+
+		pktc := make(chan packet, s.UDPWorkers)
+		defer close(pktc)
+
+		for i := 0; i < s.UDPWorkers; i++ {
+			go func() {
+				for pkt := range pktc {
+					s.serve(hctx, pkt.pw, pkt.req)
+				}
+			}()
+		}
+
+		handle = func(pw *packetWriter, req *Query) { pktc <- packet{pw, req} }
+	}
+
 	for {
 		buf := make([]byte, maxPacketLen)
 		n, addr, err := conn.ReadFrom(buf)
 		if err != nil {
-			return err
+			return s.serveErr(ctx, err)
 		}
 
 		req := &Query{
 			Message:    new(Message),
 			RemoteAddr: addr,
+			received:   time.Now(),
 		}
 
-		if buf, err = req.Message.Unpack(buf[:n]); err != nil {
-			s.logf("dns unpack: %s", err.Error())
-			continue
-		}
-		if len(buf) != 0 {
-			s.logf("dns unpack: malformed packet, extra message bytes")
-			continue
+		pw := &packetWriter{
+			messageWriter: new(messageWriter),
+
+			addr:   addr,
+			conn:   conn,
+			maxLen: s.maxUDPSize(nil),
 		}
 
-		pw := &packetWriter{
-			messageWriter: &messageWriter{
-				msg: response(req.Message),
-			},
+		if err := s.unpack(req.Message, buf[:n]); err != nil {
+			s.logf("dns unpack: %s", err.Error())
 
-			addr: addr,
-			conn: conn,
+			if pw.msg = s.formErr(buf[:n]); pw.msg != nil {
+				go s.reply(hctx, pw)
+			}
+			continue
 		}
+		pw.msg = reply(req.Message)
+		pw.maxLen = s.maxUDPSize(req.Message)
 
-		go s.handle(ctx, pw, req)
+		s.beginQuery()
+		handle(pw, req)
+	}
+}
+
+// maxUDPSize returns the size in bytes above which UDP responses to the query
+// message are truncated: the UDP payload size advertised by the query, up to
+// 4096 bytes. A nil query has the 512 byte limit.
+func (s *Server) maxUDPSize(query *Message) int {
+	if s.ForceMaxUDPSize > 0 {
+		return int(s.ForceMaxUDPSize)
+	}
+	if query == nil {
+		return maxPacketLen
+	}
+	if size := query.UDPSize(); size < maxEDNSPacketLen {
+		return size
 	}
+	return maxEDNSPacketLen
 }
 
 // ServeTLS accepts incoming connections on the Listener ln, creating a new
@@ -145,15 +340,22 @@ func (s *Server) ServePacket(ctx context.Context, conn net.PacketConn) error {
 //
 // See RFC 7858, section 3.3 for transport encoding of messages.
 //
+// Canceling ctx shuts down the server, as for Serve.
+//
 // ServeTLS always returns a non-nil error.
 func (s *Server) ServeTLS(ctx context.Context, ln net.Listener) error {
 	ln = tls.NewListener(ln, s.TLSConfig.Clone())
 	defer ln.Close()
 
+	defer s.watch(ctx, ln, func() { ln.Close() })()
+
+	hctx, cancel := context.WithCancel(detachedContext{ctx})
+	defer cancel()
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			return err
+			return s.serveErr(ctx, err)
 		}
 
 		go func(conn net.Conn) {
@@ -162,7 +364,7 @@ func (s *Server) ServeTLS(ctx context.Context, ln net.Listener) error {
 				return
 			}
 
-			s.serveStream(ctx, conn)
+			s.serveStream(hctx, conn)
 		}(conn)
 	}
 }
@@ -173,11 +375,32 @@ func (s *Server) serveStream(ctx context.Context, conn net.Conn) {
 
 		lbuf [2]byte
 		mu   sync.Mutex
+
+		nquery int
+		active sync.WaitGroup
 	)
 
+	if s.OnStreamClose != nil {
+		defer func() { s.OnStreamClose(conn.RemoteAddr(), nquery) }()
+	}
+
+	// on shutdown, the connection is closed once its queries are answered
+	// (RFC 7766, section 6.2.3)
+	if !s.track(conn, func() { conn.SetReadDeadline(aLongTimeAgo) }) {
+		conn.Close()
+		return
+	}
+	defer s.untrack(conn)
+	defer func() {
+		if s.shuttingDown() {
+			active.Wait()
+			conn.Close()
+		}
+	}()
+
 	for {
-		if _, err := rbuf.Read(lbuf[:]); err != nil {
-			if err != io.EOF {
+		if _, err := io.ReadFull(rbuf, lbuf[:]); err != nil {
+			if err != io.EOF && !s.shuttingDown() {
 				s.logf("dns read: %s", err.Error())
 			}
 			return
@@ -185,46 +408,181 @@ func (s *Server) serveStream(ctx context.Context, conn net.Conn) {
 
 		buf := make([]byte, int(nbo.Uint16(lbuf[:])))
 		if _, err := io.ReadFull(rbuf, buf); err != nil {
-			s.logf("dns read: %s", err.Error())
+			if !s.shuttingDown() {
+				s.logf("dns read: %s", err.Error())
+			}
 			return
 		}
 
+		nquery++
+
 		req := &Query{
 			Message:    new(Message),
 			RemoteAddr: conn.RemoteAddr(),
+			received:   time.Now(),
 		}
 
-		var err error
-		if buf, err = req.Message.Unpack(buf); err != nil {
-			s.logf("dns unpack: %s", err.Error())
-			continue
+		sw := streamWriter{
+			messageWriter: new(messageWriter),
+
+			mu:   &mu,
+			conn: conn,
 		}
-		if len(buf) != 0 {
-			s.logf("dns unpack: malformed packet, extra message bytes")
+
+		if err := s.unpack(req.Message, buf); err != nil {
+			s.logf("dns unpack: %s", err.Error())
+
+			if sw.msg = s.formErr(buf); sw.msg != nil {
+				go s.reply(ctx, sw)
+			}
 			continue
 		}
+		sw.msg = reply(req.Message)
 
-		sw := streamWriter{
-			messageWriter: &messageWriter{
-				msg: response(req.Message),
-			},
+		s.beginQuery()
+		active.Add(1)
+		go func() {
+			defer active.Done()
 
-			mu:   &mu,
-			conn: conn,
+			s.serve(ctx, sw, req)
+		}()
+	}
+}
+
+// unpack decodes the query message msg from b.
+func (s *Server) unpack(msg *Message, b []byte) error {
+	// reject responses before decoding the whole message
+	h, err := DecodeHeader(b)
+	if err != nil {
+		return err
+	}
+	if h.Response {
+		return errUnexpectedResponse
+	}
+
+	if err := unpackAll(msg, b); err != nil {
+		return err
+	}
+
+	if s.StrictNames {
+		for _, q := range msg.Questions {
+			if err := checkName(q.Name, true); err != nil {
+				return err
+			}
 		}
+	}
+	return nil
+}
 
-		go s.handle(ctx, sw, req)
+// formErr returns a "Format Error" response for the malformed query message
+// b, or nil if the query should be dropped instead.
+func (s *Server) formErr(b []byte) *Message {
+	if !s.StrictNames {
+		return nil
+	}
+
+	var msg Message
+	if _, err := msg.unpackHeader(b); err != nil || msg.Response {
+		return nil
+	}
+
+	return &Message{
+		ID:               msg.ID,
+		Response:         true,
+		OpCode:           msg.OpCode,
+		RecursionDesired: msg.RecursionDesired,
+		RCode:            FormErr,
+	}
+}
+
+func (s *Server) reply(ctx context.Context, w MessageWriter) {
+	if err := w.Reply(ctx); err != nil {
+		s.logf("dns: %s", err.Error())
 	}
 }
 
 func (s *Server) handle(ctx context.Context, w MessageWriter, r *Query) {
+	// the values are shared by the copies of r passed to nested handlers
+	r.initValues()
+
 	sw := &serverWriter{
 		MessageWriter: w,
+		ctx:           ctx,
 		forwarder:     s.Forwarder,
 		query:         r,
+		ontruncate:    s.OnTruncate,
+		reflectOPT:    !s.NoOPTReflection,
+		minTTLs:       s.MinimizeRRSetTTLs,
+		negativeTTL:   s.NegativeTTL,
+	}
+
+	if s.StickyShuffle {
+		sw.shuffle = clientRand(r.RemoteAddr)
+	}
+	if s.MinResponseLatency > 0 {
+		received := r.received
+		if received.IsZero() {
+			received = time.Now()
+		}
+		sw.notBefore = received.Add(s.MinResponseLatency)
+
+		// the UDP worker is freed while the response is delayed
+		if _, ok := w.(*packetWriter); ok && s.UDPWorkers > 0 {
+			sw.async = func(send func() error) {
+				s.beginQuery()
+				go func() {
+					defer s.endQuery()
+
+					if err := send(); err != nil {
+						s.logf("dns: %s", err.Error())
+					}
+				}()
+			}
+		}
+	}
+	if pw, ok := w.(*packetWriter); ok && s.MaxRRsetSize > 0 {
+		sw.packet, sw.maxRRset = pw, s.MaxRRsetSize
 	}
 
-	s.Handler.ServeDNS(ctx, sw, r)
+	sw.Recursion(s.RecursionAvailable)
+
+	if s.RequireCookie {
+		ok, cookie := s.checkCookie(r)
+		if !ok {
+			s.badCookie(ctx, sw, r, cookie)
+			return
+		}
+		sw.cookie = cookie
+	}
+
+	if !s.classesAllowed(r.Questions) {
+		sw.Status(Refused)
+		if err := sw.Reply(ctx); err != nil {
+			s.logf("dns: %s", err.Error())
+		}
+		return
+	}
+
+	nq := len(r.Questions)
+	switch s.AnyPolicy {
+	case AnyPolicyMinimal:
+		r = minimizeAny(sw, r)
+	case AnyPolicyRefuse:
+		if hasAny(r.Questions) {
+			sw.Status(Refused)
+			if err := sw.Reply(ctx); err != nil {
+				s.logf("dns: %s", err.Error())
+			}
+			return
+		}
+	}
+
+	// queries without questions, such as cookie-only queries (RFC 7873,
+	// section 5.4), are passed to the handler, unless every question was
+	// answered by minimizeAny
+	if nq == 0 || len(r.Questions) > 0 {
+		s.Handler.ServeDNS(ctx, sw, r)
+	}
 
 	if !sw.replied {
 		if err := sw.Reply(ctx); err != nil {
@@ -233,6 +591,89 @@ func (s *Server) handle(ctx context.Context, w MessageWriter, r *Query) {
 	}
 }
 
+// classesAllowed reports whether the classes of the questions qs are allowed
+// by AllowedClasses.
+func (s *Server) classesAllowed(qs []Question) bool {
+	allowed := s.AllowedClasses
+	if len(allowed) == 0 {
+		allowed = []Class{ClassIN}
+	}
+
+next:
+	for _, q := range qs {
+		if q.Class == ClassANY || isVersionBind(q) {
+			continue
+		}
+		for _, class := range allowed {
+			if q.Class == class {
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// isVersionBind reports whether q is the CH class query for the server
+// software version.
+func isVersionBind(q Question) bool {
+	return q.Class == ClassCH && q.Type == TypeTXT && strings.EqualFold(q.Name, "version.bind.")
+}
+
+// badCookie answers a query without a valid server cookie with a BADCOOKIE
+// message holding the cookie option, or refuses a query without EDNS, which
+// cannot carry the extended RCODE.
+func (s *Server) badCookie(ctx context.Context, w *serverWriter, r *Query, cookie *edns.Option) {
+	if res, _ := r.opt(); res == nil {
+		w.Status(Refused)
+	} else {
+		w.Status(BadCookie)
+
+		opt := new(OPT)
+		if cookie != nil {
+			opt.Options = []edns.Option{*cookie}
+		}
+		w.Additional(".", 0, opt)
+	}
+
+	if err := w.Reply(ctx); err != nil {
+		s.logf("dns: %s", err.Error())
+	}
+}
+
+// hasAny reports whether any of the questions qs is for all records.
+func hasAny(qs []Question) bool {
+	for _, q := range qs {
+		if q.Type == TypeALL {
+			return true
+		}
+	}
+	return false
+}
+
+// minimizeAny answers the ANY questions of r with an RFC 8482 HINFO record,
+// and returns the query for the remaining questions.
+func minimizeAny(w MessageWriter, r *Query) *Query {
+	qs := make([]Question, 0, len(r.Questions))
+	for _, q := range r.Questions {
+		if q.Type != TypeALL {
+			qs = append(qs, q)
+			continue
+		}
+
+		w.Answer(q.Name, anyMinimalTTL, &HINFO{CPU: "RFC8482"})
+	}
+	if len(qs) == len(r.Questions) {
+		return r
+	}
+
+	msg := new(Message)
+	*msg = *r.Message // shallow copy
+	msg.Questions = qs
+
+	return r.withMessage(msg)
+}
+
 func (s *Server) logf(format string, args ...interface{}) {
 	printf := log.Printf
 	if s.ErrorLog != nil {
@@ -245,8 +686,9 @@ func (s *Server) logf(format string, args ...interface{}) {
 type packetWriter struct {
 	*messageWriter
 
-	addr net.Addr
-	conn net.PacketConn
+	addr   net.Addr
+	conn   net.PacketConn
+	maxLen int // truncation size of responses
 }
 
 func (w packetWriter) Recur(ctx context.Context) (*Message, error) {
@@ -259,7 +701,20 @@ func (w packetWriter) Reply(ctx context.Context) error {
 		return err
 	}
 
-	if len(buf) > maxPacketLen {
+	if len(buf) > w.maxLen {
+		return w.truncate(buf)
+	}
+
+	_, err = w.conn.WriteTo(buf, w.addr)
+	return err
+}
+
+func (w packetWriter) SendRaw(b []byte) error {
+	buf, err := patchID(nil, b, w.msg.ID)
+	if err != nil {
+		return err
+	}
+	if len(buf) > w.maxLen {
 		return w.truncate(buf)
 	}
 
@@ -268,14 +723,17 @@ func (w packetWriter) Reply(ctx context.Context) error {
 }
 
 func (w packetWriter) truncate(buf []byte) error {
-	var err error
-	if buf, err = truncate(buf, maxPacketLen); err != nil {
+	buf, tc, err := truncate(buf, w.maxLen)
+	if err != nil {
 		return err
 	}
 
 	if _, err := w.conn.WriteTo(buf, w.addr); err != nil {
 		return err
 	}
+	if !tc {
+		return nil
+	}
 	return ErrTruncatedMessage
 }
 
@@ -305,24 +763,78 @@ func (w streamWriter) Reply(ctx context.Context) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	_, err = w.conn.Write(buf)
-	return err
+	return writeFull(w.conn, buf)
+}
+
+func (w streamWriter) SendRaw(b []byte) error {
+	buf, err := patchID(make([]byte, 2, 2+len(b)), b, w.msg.ID)
+	if err != nil {
+		return err
+	}
+
+	blen := uint16(len(buf) - 2)
+	if int(blen) != len(buf)-2 {
+		return ErrOversizedMessage
+	}
+	nbo.PutUint16(buf[:2], blen)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return writeFull(w.conn, buf)
+}
+
+// patchID appends the encoded message b to buf, with the message ID replaced
+// by id.
+func patchID(buf, b []byte, id int) ([]byte, error) {
+	if len(b) < 12 {
+		return nil, errResourceLen
+	}
+
+	off := len(buf)
+	buf = append(buf, b...)
+	nbo.PutUint16(buf[off:off+2], uint16(id))
+	return buf, nil
 }
 
 type serverWriter struct {
 	MessageWriter
 
-	forwarder RoundTripper
-	query     *Query
+	ctx context.Context // of the query
+
+	forwarder  RoundTripper
+	query      *Query
+	ontruncate func(*Query)
+	reflectOPT bool
+	minTTLs    bool
+	shuffle    *rand.Rand   // source of the answer order, if StickyShuffle
+	cookie     *edns.Option // COOKIE option of the reflected OPT record
+
+	packet   *packetWriter // UDP writer of the response, if maxRRset is set
+	maxRRset int
+
+	notBefore time.Time          // earliest time to send the response
+	async     func(func() error) // sends a delayed response, if set
+
+	negativeTTL time.Duration // TTL of negative SOA records, if NegativeTTL
+	rcode       RCode         // of the response, once set
 
 	replied bool
+	opt     *OPT // OPT record of the response, once added
+
+	// records held until Reply when minTTLs, shuffle, maxRRset or
+	// negativeTTL is set
+	answers, authorities, additionals []pendingRR
+}
+
+type pendingRR struct {
+	fqdn string
+	ttl  time.Duration
+	rec  Record
 }
 
 func (w serverWriter) Recur(ctx context.Context) (*Message, error) {
-	query := &Query{
-		Message:    request(w.query.Message),
-		RemoteAddr: w.query.RemoteAddr,
-	}
+	query := w.query.withMessage(request(w.query.Message))
 
 	qs := make([]Question, 0, len(w.query.Questions))
 	for _, q := range w.query.Questions {
@@ -332,13 +844,350 @@ func (w serverWriter) Recur(ctx context.Context) (*Message, error) {
 	}
 	query.Questions = qs
 
-	return w.forward(ctx, query)
+	msg, err := w.forward(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return relayed(msg), nil
+}
+
+// hopByHopOptions are the EDNS options that only apply between a client and
+// the server it queried, which are not relayed from an upstream response.
+var hopByHopOptions = map[edns.OptionCode]bool{
+	edns.OptionCodeCookie:           true, // RFC 7873, section 5.1
+	edns.OptionCodeEDNSTCPKeepAlive: true, // RFC 7828, section 3.3.2
+	edns.OptionCodePadding:          true, // RFC 7830, section 3
+}
+
+// relayed returns the upstream response msg without its hop-by-hop EDNS
+// options, so that the other options, such as extended errors or the NSID,
+// are relayed to the client.
+func relayed(msg *Message) *Message {
+	res, opt := msg.opt()
+	if opt == nil {
+		return msg
+	}
+
+	opts := make([]edns.Option, 0, len(opt.Options))
+	for _, o := range opt.Options {
+		if !hopByHopOptions[o.Code] {
+			opts = append(opts, o)
+		}
+	}
+	if len(opts) == len(opt.Options) {
+		return msg
+	}
+
+	rr := *res
+	rr.Record = &OPT{Options: opts}
+
+	out := new(Message)
+	*out = *msg // shallow copy
+	out.Additionals = make([]Resource, len(msg.Additionals))
+	for i, add := range msg.Additionals {
+		if add.Record == opt {
+			add = rr
+		}
+		out.Additionals[i] = add
+	}
+	return out
+}
+
+func (w *serverWriter) Answer(fqdn string, ttl time.Duration, rec Record) {
+	fqdn = w.questionCase(fqdn)
+
+	if w.buffered() {
+		w.answers = append(w.answers, pendingRR{fqdn, ttl, rec})
+		return
+	}
+
+	w.MessageWriter.Answer(fqdn, ttl, rec)
+}
+
+// questionCase returns the name of the query question matching fqdn, so that
+// answer owner names echo the case of the question, such as for clients
+// relying on 0x20 encoding. Other names are returned unchanged.
+func (w *serverWriter) questionCase(fqdn string) string {
+	for _, q := range w.query.Questions {
+		if q.Name != fqdn && strings.EqualFold(q.Name, fqdn) {
+			return q.Name
+		}
+	}
+	return fqdn
+}
+
+func (w *serverWriter) AnswerAll(fqdn string, ttl time.Duration, recs ...Record) {
+	for _, rec := range recs {
+		w.Answer(fqdn, ttl, rec)
+	}
+}
+
+func (w *serverWriter) Status(rcode RCode) {
+	w.rcode = rcode
+	w.MessageWriter.Status(rcode)
 }
 
-func (w serverWriter) Reply(ctx context.Context) error {
+func (w *serverWriter) Authority(fqdn string, ttl time.Duration, rec Record) {
+	if w.buffered() {
+		w.authorities = append(w.authorities, pendingRR{fqdn, ttl, rec})
+		return
+	}
+
+	w.MessageWriter.Authority(fqdn, ttl, rec)
+}
+
+// Additional adds a record to the additional section. The options of an OPT
+// record added after the first one are merged into the first, so that the
+// response holds a single OPT record (RFC 6891, section 6.1.1).
+func (w *serverWriter) Additional(fqdn string, ttl time.Duration, rec Record) {
+	if opt, ok := rec.(*OPT); ok {
+		if w.opt != nil {
+			w.opt.Options = mergeOptions(w.opt.Options, opt.Options)
+			return
+		}
+
+		w.opt = &OPT{Options: append([]edns.Option(nil), opt.Options...)}
+		rec = w.opt
+	}
+
+	if w.buffered() {
+		w.additionals = append(w.additionals, pendingRR{fqdn, ttl, rec})
+		return
+	}
+
+	w.MessageWriter.Additional(fqdn, ttl, rec)
+}
+
+// buffered reports whether records are held until Reply.
+func (w *serverWriter) buffered() bool {
+	return w.minTTLs || w.shuffle != nil || w.maxRRset > 0 || w.negativeTTL > 0
+}
+
+// flush writes the held records, with the TTLs of each RRset minimized, the
+// answer RRsets shuffled, the size of RRsets limited and the TTL of negative
+// SOA records overridden as configured. It reports whether records were left
+// out of the response.
+func (w *serverWriter) flush() (truncated bool) {
+	// a response is negative if the name does not exist (NXDOMAIN) or it
+	// has no answers (NODATA), as opposed to a positive response with a SOA
+	// record in the authority section (RFC 2308, section 2)
+	if w.negativeTTL > 0 && (w.rcode == NXDomain || len(w.answers) == 0) {
+		for i, rr := range w.authorities {
+			if rr.rec.Type() == TypeSOA {
+				w.authorities[i].ttl = w.negativeTTL
+			}
+		}
+	}
+	if w.minTTLs {
+		minimizeTTLs(w.answers)
+		minimizeTTLs(w.authorities)
+		minimizeTTLs(w.additionals)
+	}
+	if w.shuffle != nil {
+		shuffleRRsets(w.answers, w.shuffle)
+	}
+	if w.maxRRset > 0 {
+		var tc [3]bool
+		w.answers, tc[0] = limitRRsets(w.answers, w.maxRRset)
+		w.authorities, tc[1] = limitRRsets(w.authorities, w.maxRRset)
+		w.additionals, tc[2] = limitRRsets(w.additionals, w.maxRRset)
+
+		truncated = tc[0] || tc[1] || tc[2]
+	}
+
+	for _, rr := range w.answers {
+		w.MessageWriter.Answer(rr.fqdn, rr.ttl, rr.rec)
+	}
+	for _, rr := range w.authorities {
+		w.MessageWriter.Authority(rr.fqdn, rr.ttl, rr.rec)
+	}
+	for _, rr := range w.additionals {
+		w.MessageWriter.Additional(rr.fqdn, rr.ttl, rr.rec)
+	}
+
+	w.answers, w.authorities, w.additionals = nil, nil, nil
+	return truncated
+}
+
+// minimizeTTLs sets the TTL of each record in rrs to the lowest TTL of the
+// records with the same name and type. The TTL of an OPT record is not a TTL
+// and is left unchanged.
+func minimizeTTLs(rrs []pendingRR) []pendingRR {
+	type rrset struct {
+		name  string
+		rtype Type
+	}
+
+	ttls := make(map[rrset]time.Duration, len(rrs))
+	for _, rr := range rrs {
+		k := rrset{strings.ToLower(rr.fqdn), rr.rec.Type()}
+		if ttl, ok := ttls[k]; !ok || rr.ttl < ttl {
+			ttls[k] = rr.ttl
+		}
+	}
+
+	for i, rr := range rrs {
+		if rr.rec.Type() != TypeOPT {
+			rrs[i].ttl = ttls[rrset{strings.ToLower(rr.fqdn), rr.rec.Type()}]
+		}
+	}
+	return rrs
+}
+
+// limitRRsets removes the records past the first max records of each RRset
+// in rrs, and reports whether any were removed. OPT records are kept.
+func limitRRsets(rrs []pendingRR, max int) ([]pendingRR, bool) {
+	type rrset struct {
+		name  string
+		rtype Type
+	}
+
+	var (
+		counts = make(map[rrset]int, len(rrs))
+		kept   = rrs[:0]
+	)
+	for _, rr := range rrs {
+		if rr.rec.Type() != TypeOPT {
+			k := rrset{strings.ToLower(rr.fqdn), rr.rec.Type()}
+			if counts[k]++; counts[k] > max {
+				continue
+			}
+		}
+		kept = append(kept, rr)
+	}
+	return kept, len(kept) < len(rrs)
+}
+
+// shuffleRRsets shuffles the records of each contiguous run of records with
+// the same name and type in rrs, in the order drawn from rnd.
+func shuffleRRsets(rrs []pendingRR, rnd *rand.Rand) {
+	for low := 0; low < len(rrs)-1; {
+		high := low + 1
+		for ; high < len(rrs) && sameRRset(rrs[low], rrs[high]); high++ {
+		}
+
+		set := rrs[low:high]
+		rnd.Shuffle(len(set), func(i, j int) { set[i], set[j] = set[j], set[i] })
+		low = high
+	}
+}
+
+func sameRRset(a, b pendingRR) bool {
+	return a.rec.Type() == b.rec.Type() && strings.EqualFold(a.fqdn, b.fqdn)
+}
+
+// clientRand returns a source of random numbers seeded by a hash of the IP
+// address of addr, so that the same client always draws the same sequence.
+func clientRand(addr net.Addr) *rand.Rand {
+	h := fnv.New64a()
+	if addr != nil {
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		h.Write([]byte(host))
+	}
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+func (w *serverWriter) Reply(ctx context.Context) error {
 	w.replied = true
 
-	return w.MessageWriter.Reply(ctx)
+	if w.reflectOPT && w.opt == nil {
+		if _, opt := w.query.opt(); opt != nil {
+			w.Additional(".", 0, new(OPT))
+		}
+	}
+	if w.cookie != nil && w.opt != nil {
+		opts := make([]edns.Option, 0, len(w.opt.Options)+1)
+		for _, o := range w.opt.Options {
+			if o.Code != edns.OptionCodeCookie {
+				opts = append(opts, o)
+			}
+		}
+		w.opt.Options = append(opts, *w.cookie)
+	}
+
+	var truncated bool
+	if w.buffered() {
+		truncated = w.flush()
+	}
+	if truncated {
+		w.packet.msg.Truncated = true
+	}
+
+	return w.delay(ctx, func() error {
+		err := w.MessageWriter.Reply(ctx)
+		if err == nil && truncated {
+			err = ErrTruncatedMessage
+		}
+		if err == ErrTruncatedMessage && w.ontruncate != nil {
+			w.ontruncate(w.query)
+		}
+		return err
+	})
+}
+
+func (w *serverWriter) SendRaw(b []byte) error {
+	w.replied = true
+
+	if w.async != nil {
+		b = append([]byte(nil), b...) // sent after SendRaw returns
+	}
+	return w.delay(w.ctx, func() error { return w.MessageWriter.SendRaw(b) })
+}
+
+// delay calls send once the notBefore time has passed. If the response is sent
+// by w.async, delay returns nil without waiting, and errors are logged.
+func (w *serverWriter) delay(ctx context.Context, send func() error) error {
+	if w.async != nil && time.Until(w.notBefore) > 0 {
+		w.async(func() error {
+			if err := w.wait(ctx); err != nil {
+				return err
+			}
+			return send()
+		})
+		return nil
+	}
+
+	if err := w.wait(ctx); err != nil {
+		return err
+	}
+	return send()
+}
+
+// wait blocks until the earliest time to send the response, or until ctx is
+// done.
+func (w *serverWriter) wait(ctx context.Context) error {
+	d := time.Until(w.notBefore)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mergeOptions returns the EDNS options opts with the options of more whose
+// codes are not already in opts.
+func mergeOptions(opts, more []edns.Option) []edns.Option {
+next:
+	for _, o := range more {
+		for _, have := range opts {
+			if have.Code == o.Code {
+				continue next
+			}
+		}
+		opts = append(opts, o)
+	}
+	return opts
 }
 
 func response(msg *Message) *Message {
@@ -350,6 +1199,16 @@ func response(msg *Message) *Message {
 	return res
 }
 
+// reply returns an empty response message for the query message read by the
+// server. The records of the query, such as its OPT record, are not echoed;
+// the OPT record of the response is added by the serverWriter.
+func reply(query *Message) *Message {
+	res := response(query)
+	res.Answers, res.Authorities, res.Additionals = nil, nil, nil
+
+	return res
+}
+
 var refuser = &Client{
 	Transport: nopDialer{},
 	Resolver:  HandlerFunc(Refuse),
@@ -366,5 +1225,14 @@ func (w serverWriter) forward(ctx context.Context, query *Query) (*Message, erro
 type nopDialer struct{}
 
 func (nopDialer) DialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
-	return nil, nil
+	return nopConn{}, nil
+}
+
+// nopConn is a Conn that is never read or written, for a Client with a
+// Resolver that answers every query.
+type nopConn struct {
+	Conn
 }
+
+func (nopConn) Close() error                { return nil }
+func (nopConn) SetDeadline(time.Time) error { return nil }
@@ -2,9 +2,14 @@ package dns
 
 import (
 	"context"
+	"io/ioutil"
+	"log"
 	"net"
 	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -27,7 +32,7 @@ func TestServerListenAndServe(t *testing.T) {
 		RemoteAddr: addrUDP,
 		Message: &Message{
 			Questions: []Question{
-				{Name: "test.local.", Type: TypeA},
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
 			},
 		},
 	}
@@ -79,7 +84,7 @@ func TestServerMessageTruncation(t *testing.T) {
 		RemoteAddr: addrUDP,
 		Message: &Message{
 			Questions: []Question{
-				{Name: "test.local.", Type: TypeA},
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
 			},
 		},
 	}
@@ -115,6 +120,137 @@ func TestServerMessageTruncation(t *testing.T) {
 	}
 }
 
+func TestServerEDNS0Truncation(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		for i := 1; i < 63; i++ {
+			w.Answer(strings.Repeat("a", i)+".localhost.", time.Minute, &A{A: localhost})
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []uint16{512, 1232, 4096} {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "test.local.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+		query.SetEDNS0(size, false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		msg, err := new(Client).Do(ctx, query)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := msg.Pack(nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the full response is about 2.5KB
+		if want, got := len(buf) > 2048, !msg.Truncated; want != got {
+			t.Errorf("want untruncated %t with payload size %d, got %t", want, size, got)
+		}
+		if len(buf) > int(size) {
+			t.Errorf("want response of at most %d bytes, got %d", size, len(buf))
+		}
+	}
+}
+
+func TestServerOnTruncate(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	truncc := make(chan *Query, 2)
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			if r.Questions[0].Name == "small.local." {
+				w.Answer("small.local.", time.Minute, &A{A: localhost})
+				return
+			}
+
+			for i := 1; i < 63; i++ {
+				w.Answer(strings.Repeat("a", i)+".localhost.", time.Minute, &A{A: localhost})
+			}
+		}),
+		OnTruncate: func(q *Query) { truncc <- q },
+	}
+	mustStart(srv)
+
+	addrUDP, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrTCP, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr net.Addr
+		name string
+
+		truncated bool
+	}{
+		{addr: addrUDP, name: "test.local.", truncated: true},
+		{addr: addrUDP, name: "small.local."},
+		{addr: addrTCP, name: "test.local."},
+		{addr: addrUDP, name: "test.local.", truncated: true},
+	}
+
+	for _, test := range tests {
+		query := &Query{
+			RemoteAddr: test.addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: test.name, Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		msg, err := new(Client).Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := test.truncated, msg.Truncated; want != got {
+			t.Errorf("%s %s: want truncated %t, got %t", test.addr.Network(), test.name, want, got)
+		}
+
+		if !test.truncated {
+			continue
+		}
+
+		select {
+		case q := <-truncc:
+			if want, got := test.name, q.Questions[0].Name; want != got {
+				t.Errorf("want truncated question %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("truncation hook not called")
+		}
+	}
+
+	select {
+	case q := <-truncc:
+		t.Errorf("unexpected truncation hook call for %+v", q.Questions)
+	default:
+	}
+}
+
 func TestServerForward(t *testing.T) {
 	t.Run("nil forwarder", func(t *testing.T) {
 		t.Parallel()
@@ -130,7 +266,7 @@ func TestServerForward(t *testing.T) {
 			RemoteAddr: addrUDP,
 			Message: &Message{
 				Questions: []Question{
-					{Name: "test.local.", Type: TypeA},
+					{Name: "test.local.", Type: TypeA, Class: ClassIN},
 				},
 			},
 		}
@@ -170,7 +306,7 @@ func TestServerForward(t *testing.T) {
 			RemoteAddr: addrUDP,
 			Message: &Message{
 				Questions: []Question{
-					{Name: "test.local.", Type: TypeA},
+					{Name: "test.local.", Type: TypeA, Class: ClassIN},
 				},
 			},
 		}
@@ -185,54 +321,1715 @@ func TestServerForward(t *testing.T) {
 	})
 }
 
-func mustServer(handler Handler) *Server {
-	srv := &Server{
-		Addr:    mustUnusedAddr(),
-		Handler: handler,
+func TestServerAnyPolicy(t *testing.T) {
+	t.Parallel()
+
+	recs := []Record{
+		&A{A: net.IPv4(127, 0, 0, 1).To4()},
+		&AAAA{AAAA: net.IPv6loopback},
+		&TXT{TXT: []string{"multi"}},
 	}
 
-	mustStart(srv)
-	return srv
+	handler := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		for _, q := range r.Questions {
+			for _, rec := range recs {
+				if q.Type == rec.Type() || q.Type == TypeALL {
+					w.Answer(q.Name, time.Minute, rec)
+				}
+			}
+		}
+	})
+
+	var full []Resource
+	for _, rec := range recs {
+		full = append(full, Resource{Name: "multi.localhost.", Class: ClassIN, TTL: time.Minute, Record: rec})
+	}
+
+	tests := []struct {
+		name string
+
+		policy AnyPolicy
+
+		rcode   RCode
+		answers []Resource
+	}{
+		{
+			name: "full",
+
+			policy: AnyPolicyFull,
+
+			rcode:   NoError,
+			answers: full,
+		},
+		{
+			name: "minimal",
+
+			policy: AnyPolicyMinimal,
+
+			rcode: NoError,
+			answers: []Resource{
+				{
+					Name:   "multi.localhost.",
+					Class:  ClassIN,
+					TTL:    anyMinimalTTL,
+					Record: &HINFO{CPU: "RFC8482"},
+				},
+			},
+		},
+		{
+			name: "refuse",
+
+			policy: AnyPolicyRefuse,
+
+			rcode: Refused,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		srv := &Server{
+			Addr:      mustUnusedAddr(),
+			Handler:   handler,
+			AnyPolicy: test.policy,
+		}
+		mustStart(srv)
+
+		for _, network := range []string{"udp", "tcp"} {
+			network := network
+
+			t.Run(test.name+"/"+network, func(t *testing.T) {
+				t.Parallel()
+
+				addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var raddr net.Addr = addr
+				if network == "tcp" {
+					raddr = &net.TCPAddr{IP: addr.IP, Port: addr.Port}
+				}
+
+				query := &Query{
+					RemoteAddr: raddr,
+					Message: &Message{
+						Questions: []Question{
+							{Name: "multi.localhost.", Type: TypeALL, Class: ClassIN},
+						},
+					},
+				}
+
+				msg, err := new(Client).Do(context.Background(), query)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if want, got := test.rcode, msg.RCode; want != got {
+					t.Errorf("want rcode %d, got %d", want, got)
+				}
+				if want, got := test.answers, msg.Answers; !reflect.DeepEqual(want, got) {
+					t.Errorf("want answers %+v, got %+v", want, got)
+				}
+				if len(msg.Authorities) > 0 || len(msg.Additionals) > 0 {
+					t.Errorf("want no authority or additional records, got %+v", msg)
+				}
+			})
+		}
+	}
 }
 
-func mustStart(srv *Server) {
+func TestServerMultipleQuestions(t *testing.T) {
+	t.Parallel()
 
-	ln, err := net.Listen("tcp", srv.Addr)
+	qc := make(chan []Question, 1)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		qc <- r.Questions
+
+		EachQuestion(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			if want, got := 1, len(r.Questions); want != got {
+				t.Errorf("want %d question, got %d", want, got)
+			}
+
+			(&answerHandler{answers}).ServeDNS(ctx, w, r)
+		})).ServeDNS(ctx, w, r)
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 
-	conn, err := net.ListenPacket("udp", srv.Addr)
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"], questions["AAAA"]},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 
-	go srv.Serve(context.Background(), ln)
-	go srv.ServePacket(context.Background(), conn)
+	if want, got := query.Questions, <-qc; !reflect.DeepEqual(want, got) {
+		t.Errorf("want handler questions %+v, got %+v", want, got)
+	}
+	if want, got := 2, len(msg.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	for i, q := range query.Questions {
+		if want, got := answers[q], msg.Answers[i].Record; !reflect.DeepEqual(want, got) {
+			t.Errorf("want answer %+v, got %+v", want, got)
+		}
+	}
 }
 
-func mustUnusedAddr() string {
-	for {
-		lnTCP, err := net.Listen("tcp", ":0")
-		if err != nil {
-			panic(err)
-		}
+func TestServerNoQuestions(t *testing.T) {
+	t.Parallel()
 
-		lnUDP, err := net.ListenPacket("udp", lnTCP.Addr().String())
-		if err != nil {
-			if err := lnTCP.Close(); err != nil {
-				panic(err)
-			}
-			continue
+	var ncall int32
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			atomic.AddInt32(&ncall, 1)
+		}),
+		AnyPolicy: AnyPolicyMinimal,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		questions []Question
+
+		called bool
+	}{
+		{
+			name: "no-questions",
+
+			called: true,
+		},
+		{
+			name: "minimized-any",
+
+			questions: []Question{
+				{Name: "multi.localhost.", Type: TypeALL, Class: ClassIN},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: test.questions,
+			},
 		}
+		query.SetEDNS0(maxEDNSPacketLen, false)
 
-		if err := lnTCP.Close(); err != nil {
-			panic(err)
+		before := atomic.LoadInt32(&ncall)
+		if _, err := new(Client).Do(context.Background(), query); err != nil {
+			t.Fatal(err)
 		}
-		if err := lnUDP.Close(); err != nil {
-			panic(err)
+
+		if want, got := test.called, atomic.LoadInt32(&ncall) > before; want != got {
+			t.Errorf("%s: want handler called %t, got %t", test.name, want, got)
 		}
+	}
+}
 
-		return lnTCP.Addr().String()
+func TestServerQuestionCase(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer("app.localhost.", time.Minute, &CNAME{CNAME: "www.localhost."})
+		w.Answer("www.localhost.", time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+
+	for _, network := range []string{"udp", "tcp"} {
+		network := network
+
+		t.Run(network, func(t *testing.T) {
+			t.Parallel()
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var raddr net.Addr = addr
+			if network == "tcp" {
+				raddr = &net.TCPAddr{IP: addr.IP, Port: addr.Port}
+			}
+
+			query := &Query{
+				RemoteAddr: raddr,
+				Message: &Message{
+					Questions: []Question{
+						{Name: "aPp.LocalHost.", Type: TypeA, Class: ClassIN},
+					},
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := "aPp.LocalHost.", msg.Questions[0].Name; want != got {
+				t.Errorf("want question name %q, got %q", want, got)
+			}
+			if want, got := 2, len(msg.Answers); want != got {
+				t.Fatalf("want %d answers, got %d", want, got)
+			}
+			for i, want := range []string{"aPp.LocalHost.", "www.localhost."} {
+				if got := msg.Answers[i].Name; want != got {
+					t.Errorf("want answer %d name %q, got %q", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestServerReferral(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer("sub.localhost.", time.Minute, &TXT{TXT: []string{"delegated"}})
+		w.Authority("sub.localhost.", time.Hour, &NS{NS: "ns1.sub.localhost."})
+		w.Authority("sub.localhost.", time.Hour, &NS{NS: "ns2.sub.localhost."})
+		w.Additional("ns1.sub.localhost.", time.Hour, &A{A: net.IPv4(127, 0, 0, 53).To4()})
+	}))
+
+	for _, network := range []string{"udp", "tcp"} {
+		network := network
+
+		t.Run(network, func(t *testing.T) {
+			t.Parallel()
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var raddr net.Addr = addr
+			if network == "tcp" {
+				raddr = &net.TCPAddr{IP: addr.IP, Port: addr.Port}
+			}
+
+			query := &Query{
+				RemoteAddr: raddr,
+				Message: &Message{
+					Questions: []Question{
+						{Name: "sub.localhost.", Type: TypeTXT, Class: ClassIN},
+					},
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := []Resource{
+				{Name: "sub.localhost.", Class: ClassIN, TTL: time.Minute, Record: &TXT{TXT: []string{"delegated"}}},
+			}, msg.Answers; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answers %+v, got %+v", want, got)
+			}
+			if want, got := []Resource{
+				{Name: "sub.localhost.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "ns1.sub.localhost."}},
+				{Name: "sub.localhost.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "ns2.sub.localhost."}},
+			}, msg.Authorities; !reflect.DeepEqual(want, got) {
+				t.Errorf("want authorities %+v, got %+v", want, got)
+			}
+			if want, got := []Resource{
+				{Name: "ns1.sub.localhost.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(127, 0, 0, 53).To4()}},
+			}, msg.Additionals; !reflect.DeepEqual(want, got) {
+				t.Errorf("want additionals %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestServerSendRaw(t *testing.T) {
+	t.Parallel()
+
+	upstream := &Message{
+		ID:        0xBEEF,
+		Response:  true,
+		Questions: []Question{questions["A"]},
+		Answers: []Resource{
+			{
+				Name:   "A.dev.",
+				Class:  ClassIN,
+				TTL:    60 * time.Second,
+				Record: answers[questions["A"]],
+			},
+		},
+	}
+
+	raw, err := upstream.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 2)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		errc <- w.SendRaw(raw)
+	}))
+
+	addrUDP, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrTCP, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
 	}
+
+	for _, addr := range []net.Addr{addrUDP, addrTCP} {
+		conn, err := new(Transport).DialAddr(context.Background(), addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := conn.Send(&Message{ID: 42, Questions: []Question{questions["A"]}}); err != nil {
+			t.Fatal(err)
+		}
+
+		var msg Message
+		if err := conn.Recv(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+
+		want := *upstream
+		want.ID = 42
+		if got := msg; !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want message %+v, got %+v", addr.Network(), want, got)
+		}
+
+		conn.Close()
+	}
+
+	if want, got := 0xBEEF, int(nbo.Uint16(raw)); want != got {
+		t.Errorf("want raw message ID %#x unchanged, got %#x", want, got)
+	}
+}
+
+func TestServerSendRawTruncated(t *testing.T) {
+	t.Parallel()
+
+	upstream := &Message{
+		ID:        0xBEEF,
+		Response:  true,
+		Questions: []Question{questions["A"]},
+	}
+	for i := 0; i < 64; i++ {
+		upstream.Answers = append(upstream.Answers, Resource{
+			Name:   "A.dev.",
+			Class:  ClassIN,
+			TTL:    60 * time.Second,
+			Record: &A{A: net.IPv4(10, 42, 0, byte(i)).To4()},
+		})
+	}
+
+	raw, err := upstream.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		errc <- w.SendRaw(raw)
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := new(Transport).DialAddr(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	if err := conn.Send(&Message{ID: 42, Questions: []Question{questions["A"]}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if err := conn.Recv(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ErrTruncatedMessage, <-errc; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+
+	if want, got := 42, msg.ID; want != got {
+		t.Errorf("want message ID %d, got %d", want, got)
+	}
+	if !msg.Truncated {
+		t.Error("want truncated response")
+	}
+	if n := len(msg.Answers); n == 0 || n >= len(upstream.Answers) {
+		t.Errorf("want some of %d answers, got %d", len(upstream.Answers), n)
+	}
+}
+
+func TestServerStrictNames(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr:        mustUnusedAddr(),
+		Handler:     &answerHandler{answers},
+		StrictNames: true,
+	}
+	mustStart(srv)
+
+	conn, err := net.Dial("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	raw := []byte{
+		0x12, 0x34, // ID=0x1234
+		0x01, 0x00, // RD=1
+		0x00, 0x01, // QDCOUNT=1
+		0x00, 0x00, // ANCOUNT=0
+		0x00, 0x00, // NSCOUNT=0
+		0x00, 0x00, // ARCOUNT=0
+
+		0x46, // 70 byte label
+	}
+	raw = append(raw, strings.Repeat("a", 70)...)
+	raw = append(raw,
+		0x03, 'd', 'e', 'v',
+		0x00,
+		0x00, 0x01, 0x00, 0x01, // TYPE=A,CLASS=IN
+	)
+
+	if _, err := conn.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, maxPacketLen)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if _, err := msg.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 0x1234, msg.ID; want != got {
+		t.Errorf("want message ID %#x, got %#x", want, got)
+	}
+	if want, got := FormErr, msg.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+}
+
+func TestServerOPTReflection(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+	handler := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer("test.local.", time.Minute, &A{A: localhost})
+	})
+
+	tests := []struct {
+		name string
+
+		noReflection bool
+		edns         bool
+
+		opt bool
+	}{
+		{name: "edns query", edns: true, opt: true},
+		{name: "plain query"},
+		{name: "reflection disabled", edns: true, noReflection: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				Addr:            mustUnusedAddr(),
+				Handler:         handler,
+				NoOPTReflection: test.noReflection,
+			}
+			mustStart(srv)
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{
+						{Name: "test.local.", Type: TypeA, Class: ClassIN},
+					},
+				},
+			}
+			if test.edns {
+				query.Additionals = []Resource{
+					{Name: ".", Class: Class(4096), Record: new(OPT)},
+				}
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, opt := msg.opt()
+			if want, got := test.opt, opt != nil; want != got {
+				t.Errorf("want OPT record %t, got %t", want, got)
+			}
+			if want, got := 1, len(msg.Answers); want != got {
+				t.Errorf("want %d answers, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestServerStreamShortWrites(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			for i := 0; i < 64; i++ {
+				w.Answer("example.com.", time.Minute, &TXT{TXT: []string{strings.Repeat("x", 32)}})
+			}
+		}),
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+
+	go srv.serveStream(context.Background(), &throttledConn{Conn: c2, n: 3})
+
+	client := &StreamConn{
+		Conn: &throttledConn{Conn: c1, n: 3},
+	}
+
+	query := &Message{
+		ID: 0x1234,
+		Questions: []Question{
+			{Name: "example.com.", Type: TypeTXT, Class: ClassIN},
+		},
+	}
+	if err := client.Send(query); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if err := client.Recv(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 0x1234, msg.ID; want != got {
+		t.Errorf("want message ID %#x, got %#x", want, got)
+	}
+	if want, got := 64, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+}
+
+func TestServerOnStreamClose(t *testing.T) {
+	t.Parallel()
+
+	type closed struct {
+		addr    net.Addr
+		queries int
+	}
+
+	closec := make(chan closed, 1)
+	srv := &Server{
+		Handler: &answerHandler{answers},
+		OnStreamClose: func(addr net.Addr, queries int) {
+			closec <- closed{addr, queries}
+		},
+	}
+
+	c1, c2 := net.Pipe()
+
+	go srv.serveStream(context.Background(), c2)
+
+	client := &StreamConn{Conn: c1}
+	for id := 1; id <= 3; id++ {
+		query := &Message{
+			ID:        id,
+			Questions: []Question{questions["A"]},
+		}
+		if err := client.Send(query); err != nil {
+			t.Fatal(err)
+		}
+
+		var msg Message
+		if err := client.Recv(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := id, msg.ID; want != got {
+			t.Errorf("want message ID %d, got %d", want, got)
+		}
+	}
+
+	select {
+	case <-closec:
+		t.Fatal("OnStreamClose called before the connection was closed")
+	default:
+	}
+
+	c1.Close()
+
+	select {
+	case c := <-closec:
+		if want, got := 3, c.queries; want != got {
+			t.Errorf("want %d queries on the connection, got %d", want, got)
+		}
+		if want, got := c2.RemoteAddr(), c.addr; want != got {
+			t.Errorf("want remote address %v, got %v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnStreamClose not called after the connection was closed")
+	}
+}
+
+func TestServerDropsResponses(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr:        mustUnusedAddr(),
+		Handler:     &answerHandler{answers},
+		StrictNames: true,
+		ErrorLog:    log.New(ioutil.Discard, "", 0),
+	}
+	mustStart(srv)
+
+	conn, err := net.Dial("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := &Message{
+		ID:        0x1234,
+		Response:  true,
+		Questions: []Question{questions["A"]},
+	}
+
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	if n, err := conn.Read(buf); err == nil {
+		t.Errorf("want response message dropped, got %d byte reply", n)
+	}
+}
+
+func TestServerRecursionAvailable(t *testing.T) {
+	t.Parallel()
+
+	for _, ra := range []bool{true, false} {
+		srv := &Server{
+			Addr:               mustUnusedAddr(),
+			Handler:            localhostZone,
+			RecursionAvailable: ra,
+		}
+		mustStart(srv)
+
+		addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		msg, err := new(Client).Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := ra, msg.RecursionAvailable; want != got {
+			t.Errorf("want RA %t, got %t", want, got)
+		}
+		if !msg.Authoritative {
+			t.Error("want authoritative response")
+		}
+	}
+}
+
+func mustServer(handler Handler) *Server {
+	srv := &Server{
+		Addr:    mustUnusedAddr(),
+		Handler: handler,
+	}
+
+	mustStart(srv)
+	return srv
+}
+
+func mustStart(srv *Server) {
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := net.ListenPacket("udp", srv.Addr)
+	if err != nil {
+		panic(err)
+	}
+
+	go srv.Serve(context.Background(), ln)
+	go srv.ServePacket(context.Background(), conn)
+}
+
+func mustUnusedAddr() string {
+	for {
+		lnTCP, err := net.Listen("tcp", ":0")
+		if err != nil {
+			panic(err)
+		}
+
+		lnUDP, err := net.ListenPacket("udp", lnTCP.Addr().String())
+		if err != nil {
+			if err := lnTCP.Close(); err != nil {
+				panic(err)
+			}
+			continue
+		}
+
+		if err := lnTCP.Close(); err != nil {
+			panic(err)
+		}
+		if err := lnUDP.Close(); err != nil {
+			panic(err)
+		}
+
+		return lnTCP.Addr().String()
+	}
+}
+
+func BenchmarkServePacket(b *testing.B) {
+	b.Run("goroutine-per-packet", func(b *testing.B) {
+		benchmarkServePacket(b, 0)
+	})
+
+	b.Run("worker-pool", func(b *testing.B) {
+		benchmarkServePacket(b, runtime.GOMAXPROCS(0))
+	})
+}
+
+func benchmarkServePacket(b *testing.B, workers int) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	srv := &Server{
+		Handler:    &answerHandler{answers},
+		UDPWorkers: workers,
+	}
+	go srv.ServePacket(context.Background(), conn)
+
+	raw, err := (&Message{Questions: []Question{questions["A"]}}).Pack(nil, true)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		c, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer c.Close()
+
+		buf := make([]byte, maxPacketLen)
+		for pb.Next() {
+			if _, err := c.Write(raw); err != nil {
+				b.Error(err)
+				return
+			}
+
+			c.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := c.Read(buf); err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					continue // dropped packet
+				}
+
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestServerMinimizeRRSetTTLs(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer("app.localhost.", 300*time.Second, &A{A: net.IPv4(10, 42, 0, 1).To4()})
+			w.Answer("APP.localhost.", 60*time.Second, &A{A: net.IPv4(10, 42, 0, 2).To4()})
+			w.Answer("app.localhost.", 120*time.Second, &TXT{TXT: []string{"app"}})
+		}),
+		MinimizeRRSetTTLs: true,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeALL, Class: ClassIN},
+			},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 3, len(msg.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	for i, want := range []time.Duration{60 * time.Second, 60 * time.Second, 120 * time.Second} {
+		if got := msg.Answers[i].TTL; want != got {
+			t.Errorf("want answer %d TTL %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestServerNegativeTTL(t *testing.T) {
+	t.Parallel()
+
+	soa := &SOA{
+		NS:     "dns.localhost.",
+		MBox:   "hostmaster.localhost.",
+		MinTTL: time.Hour,
+	}
+
+	zone := &Zone{
+		Origin: "localhost.",
+		TTL:    24 * time.Hour,
+		SOA:    soa,
+		RRs: RRSet{
+			"app": {
+				TypeA: {&A{A: net.IPv4(10, 42, 0, 1).To4()}},
+			},
+		},
+	}
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			if r.Questions[0].Name != "positive.localhost." {
+				zone.ServeDNS(ctx, w, r)
+				return
+			}
+
+			// a positive response carrying the SOA record of the zone
+			w.Answer("positive.localhost.", time.Minute, &A{A: net.IPv4(10, 42, 0, 2).To4()})
+			w.Authority("localhost.", 24*time.Hour, soa)
+		}),
+		NegativeTTL: 30 * time.Second,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		qtype Type
+
+		rcode       RCode
+		answers     []time.Duration
+		authorities []time.Duration
+	}{
+		{name: "missing.localhost.", qtype: TypeA, rcode: NXDomain, authorities: []time.Duration{30 * time.Second}},
+		{name: "app.localhost.", qtype: TypeAAAA, rcode: NoError, authorities: []time.Duration{30 * time.Second}},
+		{name: "localhost.", qtype: TypeSOA, rcode: NoError, answers: []time.Duration{24 * time.Hour}},
+		{name: "positive.localhost.", qtype: TypeA, rcode: NoError, answers: []time.Duration{time.Minute}, authorities: []time.Duration{24 * time.Hour}},
+	}
+
+	for _, test := range tests {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: test.name, Type: test.qtype, Class: ClassIN},
+				},
+			},
+		}
+
+		msg, err := new(Client).Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.rcode, msg.RCode; want != got {
+			t.Errorf("%s: want rcode %d, got %d", test.name, want, got)
+		}
+
+		for _, sec := range []struct {
+			name string
+			ttls []time.Duration
+			rrs  []Resource
+		}{
+			{"answer", test.answers, msg.Answers},
+			{"authority", test.authorities, msg.Authorities},
+		} {
+			if want, got := len(sec.ttls), len(sec.rrs); want != got {
+				t.Fatalf("%s: want %d %s records, got %d", test.name, want, sec.name, got)
+			}
+			for i, rr := range sec.rrs {
+				if want, got := sec.ttls[i], rr.TTL; want != got {
+					t.Errorf("%s: want %s TTL %s, got %s", test.name, sec.name, want, got)
+				}
+				if rec, ok := rr.Record.(*SOA); ok && soa.MinTTL != rec.MinTTL {
+					t.Errorf("%s: want SOA MINIMUM %s, got %s", test.name, soa.MinTTL, rec.MinTTL)
+				}
+			}
+		}
+	}
+}
+
+func TestServerStickyShuffle(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			for i := 1; i <= 16; i++ {
+				w.Answer("app.localhost.", time.Minute, &A{A: net.IPv4(10, 42, 0, byte(i)).To4()})
+			}
+		}),
+		StickyShuffle: true,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := func(localIP net.IP) string {
+		client := &Client{
+			Transport: &Transport{
+				DialContext: (&net.Dialer{LocalAddr: &net.UDPAddr{IP: localIP}}).DialContext,
+			},
+		}
+
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		msg, err := client.Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := 16, len(msg.Answers); want != got {
+			t.Fatalf("want %d answers, got %d", want, got)
+		}
+
+		var ips []string
+		for _, rr := range msg.Answers {
+			ips = append(ips, rr.Record.(*A).A.String())
+		}
+		return strings.Join(ips, " ")
+	}
+
+	first := order(net.IPv4(127, 0, 0, 1))
+	if want, got := first, order(net.IPv4(127, 0, 0, 1)); want != got {
+		t.Errorf("want same order %q for the same client, got %q", want, got)
+	}
+	if other := order(net.IPv4(127, 0, 0, 2)); first == other {
+		t.Errorf("want different orders for different clients, got %q", other)
+	}
+}
+
+func TestServerMaxRRsetSize(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			for i := 0; i < 1000; i++ {
+				w.Answer("big.localhost.", time.Minute, &TXT{TXT: []string{strconv.Itoa(i)}})
+			}
+			w.Answer("big.localhost.", time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		MaxRRsetSize: 50,
+		ErrorLog:     log.New(ioutil.Discard, "", 0),
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "big.localhost.", Type: TypeALL, Class: ClassIN},
+			},
+		},
+	}
+	query.SetEDNS0(maxEDNSPacketLen, false)
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !msg.Truncated {
+		t.Error("want truncated response")
+	}
+	if want, got := 51, len(msg.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	for i, rr := range msg.Answers[:50] {
+		if want, got := strconv.Itoa(i), rr.Record.(*TXT).TXT[0]; want != got {
+			t.Errorf("want answer %d TXT %q, got %q", i, want, got)
+		}
+	}
+	if want, got := TypeA, msg.Answers[50].Record.Type(); want != got {
+		t.Errorf("want last answer type %d, got %d", want, got)
+	}
+}
+
+func TestServerForceMaxUDPSize(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	handler := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		for i := 0; i < 20; i++ {
+			w.Answer("test.local.", time.Minute, &A{A: localhost})
+		}
+	})
+
+	for _, size := range []uint16{0, 256} {
+		srv := &Server{
+			Addr:            mustUnusedAddr(),
+			Handler:         handler,
+			ForceMaxUDPSize: size,
+		}
+		mustStart(srv)
+
+		addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "test.local.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		msg, err := new(Client).Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := size != 0, msg.Truncated; want != got {
+			t.Errorf("want truncated %t with size %d, got %t", want, size, got)
+		}
+		if size == 0 {
+			continue
+		}
+
+		buf, err := msg.Pack(nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(buf) > int(size) {
+			t.Errorf("want response of at most %d bytes, got %d", size, len(buf))
+		}
+	}
+}
+
+func TestServerTruncateAdditionalsFirst(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		answers, additionals int
+
+		truncated bool
+	}{
+		{name: "additionals", answers: 4, additionals: 64},
+		{name: "answers", answers: 64, additionals: 4, truncated: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				Addr: mustUnusedAddr(),
+				Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					for i := 0; i < test.answers; i++ {
+						w.Answer("app.localhost.", time.Minute, &NS{NS: "ns" + strconv.Itoa(i) + ".localhost."})
+					}
+					for i := 0; i < test.additionals; i++ {
+						w.Additional("ns"+strconv.Itoa(i)+".localhost.", time.Minute, &A{A: net.IPv4(127, 0, 0, byte(i)).To4()})
+					}
+				}),
+				ErrorLog: log.New(ioutil.Discard, "", 0),
+			}
+			mustStart(srv)
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{
+						{Name: "app.localhost.", Type: TypeNS, Class: ClassIN},
+					},
+				},
+			}
+			query.SetEDNS0(512, false)
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.truncated, msg.Truncated; want != got {
+				t.Errorf("want truncated %t, got %t", want, got)
+			}
+			if !test.truncated && len(msg.Answers) != test.answers {
+				t.Errorf("want %d answers, got %d", test.answers, len(msg.Answers))
+			}
+			if test.truncated && len(msg.Answers) >= test.answers {
+				t.Errorf("want fewer than %d answers, got %d", test.answers, len(msg.Answers))
+			}
+			if want, got := 1, len(msg.Additionals); want != got {
+				t.Fatalf("want %d additional, got %d", want, got)
+			}
+			if want, got := TypeOPT, msg.Additionals[0].Record.Type(); want != got {
+				t.Errorf("want additional type %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestServerAnswerAll(t *testing.T) {
+	t.Parallel()
+
+	recs := []Record{
+		&A{A: net.IPv4(127, 0, 0, 1).To4()},
+		&AAAA{AAAA: net.IPv6loopback},
+		&TXT{TXT: []string{"localhost"}},
+	}
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.AnswerAll(r.Questions[0].Name, time.Minute, recs...)
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "localhost.", Type: TypeALL, Class: ClassIN},
+			},
+		},
+	}
+
+	msg, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := len(recs), len(msg.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	for i, rec := range recs {
+		want := Resource{Name: "localhost.", Class: ClassIN, TTL: time.Minute, Record: rec}
+		if got := msg.Answers[i]; !reflect.DeepEqual(want, got) {
+			t.Errorf("want answer %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestServerAllowedClasses(t *testing.T) {
+	t.Parallel()
+
+	handler := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer(r.Questions[0].Name, time.Minute, &TXT{TXT: []string{"answered"}})
+	})
+
+	tests := []struct {
+		name string
+
+		allowed []Class
+		q       Question
+
+		rcode RCode
+	}{
+		{
+			name: "IN",
+			q:    Question{Name: "app.localhost.", Type: TypeTXT, Class: ClassIN},
+		},
+		{
+			name:  "HS",
+			q:     Question{Name: "app.localhost.", Type: TypeTXT, Class: ClassHS},
+			rcode: Refused,
+		},
+		{
+			name:  "CH",
+			q:     Question{Name: "app.localhost.", Type: TypeTXT, Class: ClassCH},
+			rcode: Refused,
+		},
+		{
+			name: "version.bind",
+			q:    Question{Name: "version.bind.", Type: TypeTXT, Class: ClassCH},
+		},
+		{
+			name:    "allowed-HS",
+			allowed: []Class{ClassIN, ClassHS},
+			q:       Question{Name: "app.localhost.", Type: TypeTXT, Class: ClassHS},
+		},
+		{
+			name:    "allowed-HS-only",
+			allowed: []Class{ClassHS},
+			q:       Question{Name: "app.localhost.", Type: TypeTXT, Class: ClassIN},
+			rcode:   Refused,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				Addr:           mustUnusedAddr(),
+				Handler:        handler,
+				AllowedClasses: test.allowed,
+			}
+			mustStart(srv)
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{test.q},
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %d, got %d", want, got)
+			}
+
+			nanswer := 1
+			if test.rcode == Refused {
+				nanswer = 0
+			}
+			if want, got := nanswer, len(msg.Answers); want != got {
+				t.Errorf("want %d answers, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestServerMinResponseLatency(t *testing.T) {
+	t.Parallel()
+
+	const latency = 200 * time.Millisecond
+
+	// with a single UDP worker, the worker is not held by delayed responses
+	for _, workers := range []int{0, 1} {
+		workers := workers
+
+		t.Run("workers="+strconv.Itoa(workers), func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				Addr:               mustUnusedAddr(),
+				Handler:            &answerHandler{answers},
+				MinResponseLatency: latency,
+				UDPWorkers:         workers,
+			}
+			mustStart(srv)
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// concurrent queries are delayed independently
+			const nquery = 4
+
+			start := time.Now()
+			errc := make(chan error, nquery)
+			for i := 0; i < nquery; i++ {
+				go func() {
+					query := &Query{
+						RemoteAddr: addr,
+						Message: &Message{
+							Questions: []Question{questions["A"]},
+						},
+					}
+
+					msg, err := new(Client).Do(context.Background(), query)
+					if err == nil && time.Since(start) < latency {
+						t.Errorf("response received after %s, before the %s floor", time.Since(start), latency)
+					}
+					if err == nil && len(msg.Answers) != 1 {
+						t.Errorf("want 1 answer, got %d", len(msg.Answers))
+					}
+					errc <- err
+				}()
+			}
+
+			for i := 0; i < nquery; i++ {
+				if err := <-errc; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if elapsed := time.Since(start); elapsed >= 2*latency {
+				t.Errorf("want concurrent responses within %s, took %s", 2*latency, elapsed)
+			}
+		})
+	}
+}
+
+func TestServerRCodeOpCode(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Status(NXDomain)
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opcode := range []OpCode{OpCodeQuery, OpCodeNotify} {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				OpCode: opcode,
+				Questions: []Question{
+					{Name: "missing.local.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		msg, err := new(Client).Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := NXDomain, msg.RCode; want != got {
+			t.Errorf("want rcode %d, got %d", want, got)
+		}
+		if want, got := opcode, msg.OpCode; want != got {
+			t.Errorf("want opcode %d, got %d", want, got)
+		}
+	}
+}
+
+func TestServerHeaderFlags(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr:               mustUnusedAddr(),
+		Handler:            localhostZone,
+		RecursionAvailable: true,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rd := range []bool{false, true} {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				RecursionDesired: rd,
+				Questions: []Question{
+					{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		msg, err := new(Client).Do(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := rd, msg.RecursionDesired; want != got {
+			t.Errorf("want RD bit %t, got %t", want, got)
+		}
+		if !msg.Authoritative {
+			t.Error("want AA bit set on a zone answer")
+		}
+		if !msg.RecursionAvailable {
+			t.Error("want RA bit set")
+		}
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	tests := []struct {
+		network  string
+		shutdown bool // call Shutdown instead of canceling the context
+
+		err error
+	}{
+		{network: "udp", err: context.Canceled},
+		{network: "tcp", err: context.Canceled},
+		{network: "udp", shutdown: true, err: ErrServerClosed},
+		{network: "tcp", shutdown: true, err: ErrServerClosed},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.network+"/"+strconv.FormatBool(test.shutdown), func(t *testing.T) {
+			t.Parallel()
+
+			startc, releasec := make(chan struct{}), make(chan struct{})
+			srv := &Server{
+				Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					close(startc)
+					<-releasec
+
+					if err := ctx.Err(); err != nil {
+						t.Errorf("handler context canceled during shutdown: %v", err)
+					}
+					w.Answer(r.Questions[0].Name, time.Minute, &A{A: localhost})
+				}),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var addr net.Addr
+			errc := make(chan error, 1)
+			switch test.network {
+			case "udp":
+				conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				addr = conn.LocalAddr()
+				go func() { errc <- srv.ServePacket(ctx, conn) }()
+			case "tcp":
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				addr = ln.Addr()
+				go func() { errc <- srv.Serve(ctx, ln) }()
+			}
+
+			msgc := make(chan *Message, 1)
+			go func() {
+				query := &Query{
+					RemoteAddr: addr,
+					Message: &Message{
+						Questions: []Question{
+							{Name: "drain.localhost.", Type: TypeA, Class: ClassIN},
+						},
+					},
+				}
+
+				msg, err := new(Client).Do(context.Background(), query)
+				if err != nil {
+					t.Error(err)
+				}
+				msgc <- msg
+			}()
+
+			<-startc
+
+			shutdownc := make(chan error, 1)
+			if test.shutdown {
+				go func() { shutdownc <- srv.Shutdown(context.Background()) }()
+			} else {
+				cancel()
+				shutdownc <- nil
+			}
+
+			select {
+			case err := <-errc:
+				t.Fatalf("server returned before the active query was answered: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			close(releasec)
+
+			if msg := <-msgc; msg == nil || len(msg.Answers) != 1 {
+				t.Errorf("want the reply of the active query, got %+v", msg)
+			}
+
+			select {
+			case err := <-errc:
+				if want, got := test.err, err; want != got {
+					t.Errorf("want serve error %v, got %v", want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("server did not return after the active query was answered")
+			}
+			if err := <-shutdownc; err != nil {
+				t.Errorf("want nil Shutdown error, got %v", err)
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		releasec := make(chan struct{})
+		defer close(releasec)
+
+		srv := &Server{
+			Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+				<-releasec
+			}),
+			ShutdownTimeout: 50 * time.Millisecond,
+		}
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		errc := make(chan error, 1)
+		go func() { errc <- srv.ServePacket(ctx, conn) }()
+
+		query := &Query{
+			RemoteAddr: conn.LocalAddr(),
+			Message: &Message{
+				Questions: []Question{
+					{Name: "stuck.localhost.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+
+		qctx, qcancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer qcancel()
+
+		go new(Client).Do(qctx, query)
+
+		for {
+			srv.shutmu.Lock()
+			n := srv.nactive
+			srv.shutmu.Unlock()
+			if n > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+
+		select {
+		case err := <-errc:
+			if want, got := context.Canceled, err; want != got {
+				t.Errorf("want serve error %v, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("server did not return after the shutdown timeout")
+		}
+	})
 }
@@ -21,7 +21,7 @@ func (s *packetSession) Read(b []byte) (int, error) {
 		return 0, err
 	}
 	if len(buf) > len(b) {
-		if buf, err = truncate(buf, len(b)); err != nil {
+		if buf, _, err = truncate(buf, len(b)); err != nil {
 			return 0, err
 		}
 
@@ -158,14 +158,60 @@ func (s session) recv() (*Message, error) {
 	return me.msg, me.err
 }
 
-func truncate(buf []byte, maxPacketLength int) ([]byte, error) {
+// truncate shortens the packed message buf to at most maxPacketLength bytes.
+// The additional records other than the OPT record are dropped first, and
+// the message is only marked as truncated, as reported by tc, if answer or
+// authority records are left out too (RFC 2181, section 9).
+func truncate(buf []byte, maxPacketLength int) (b []byte, tc bool, err error) {
+	full := new(Message)
+	if _, err := full.Unpack(buf); err != nil {
+		return nil, false, err
+	}
+
+	var opt []Resource
+	for _, rr := range full.Additionals {
+		if rr.Record.Type() == TypeOPT {
+			opt = append(opt, rr)
+		}
+	}
+
+	if len(opt) < len(full.Additionals) {
+		msg := *full
+		msg.Additionals = opt
+
+		if b, err = msg.Pack(nil, true); err != nil {
+			return nil, false, err
+		}
+		if len(b) <= maxPacketLength {
+			return b, false, nil
+		}
+	}
+
+	// keep the records that fit in the length limit, then drop records from
+	// the end until the OPT record fits too
 	msg := new(Message)
-	if _, err := msg.Unpack(buf[:maxPacketLen]); err != nil {
-		if err != errResourceLen && err != errBaseLen {
-			return nil, err
+	if _, err := msg.Unpack(buf[:maxPacketLength]); err != nil {
+		switch err {
+		case errResourceLen, errBaseLen, errCalcLen, errSectionCount:
+		default:
+			return nil, false, err
 		}
 	}
 	msg.Truncated = true
+	msg.Additionals = opt
 
-	return msg.Pack(buf[:0], true)
+	for {
+		if b, err = msg.Pack(nil, true); err != nil || len(b) <= maxPacketLength {
+			return b, true, err
+		}
+
+		switch {
+		case len(msg.Authorities) > 0:
+			msg.Authorities = msg.Authorities[:len(msg.Authorities)-1]
+		case len(msg.Answers) > 0:
+			msg.Answers = msg.Answers[:len(msg.Answers)-1]
+		default:
+			return b, true, nil
+		}
+	}
 }
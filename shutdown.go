@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"context"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, for unblocking reads.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Shutdown gracefully shuts down the server: the listeners and packet
+// connections of s stop accepting queries, and the stream connections stop
+// reading queries, then Shutdown waits for the active queries to be answered.
+// The stream connections are closed once their queries are answered. If ctx
+// is done first, Shutdown returns the context error.
+//
+// Once Shutdown has been called, the Serve, ServeTLS, and ServePacket methods
+// of s return ErrServerClosed, or the error of their canceled context.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutmu.Lock()
+	s.shutdown = true
+	for _, stop := range s.stops {
+		stop()
+	}
+	s.shutmu.Unlock()
+
+	return s.drain(ctx)
+}
+
+// track registers the stop function of a listener or connection, called on
+// shutdown. It returns false, without registering stop, if the server is
+// already shutting down.
+func (s *Server) track(key interface{}, stop func()) bool {
+	s.shutmu.Lock()
+	defer s.shutmu.Unlock()
+
+	if s.shutdown {
+		return false
+	}
+	if s.stops == nil {
+		s.stops = make(map[interface{}]func())
+	}
+	s.stops[key] = stop
+	return true
+}
+
+func (s *Server) untrack(key interface{}) {
+	s.shutmu.Lock()
+	defer s.shutmu.Unlock()
+
+	delete(s.stops, key)
+}
+
+// watch tracks the listener or connection key, and shuts down the whole
+// server, not only key, when ctx is done. The returned function untracks key.
+func (s *Server) watch(ctx context.Context, key interface{}, stop func()) func() {
+	if !s.track(key, stop) {
+		stop()
+		return func() {}
+	}
+
+	donec := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Shutdown(context.Background())
+		case <-donec:
+		}
+	}()
+
+	return func() {
+		close(donec)
+		s.untrack(key)
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.shutmu.Lock()
+	defer s.shutmu.Unlock()
+
+	return s.shutdown
+}
+
+// serveErr returns the error of a Serve method that failed to accept or read
+// with err. On shutdown, it returns once the active queries are answered or
+// the ShutdownTimeout has passed.
+func (s *Server) serveErr(ctx context.Context, err error) error {
+	if !s.shuttingDown() {
+		return err
+	}
+
+	dctx := context.Background()
+	if s.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		dctx, cancel = context.WithTimeout(dctx, s.ShutdownTimeout)
+		defer cancel()
+	}
+	s.drain(dctx)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrServerClosed
+}
+
+// serve handles the query counted by beginQuery.
+func (s *Server) serve(ctx context.Context, w MessageWriter, r *Query) {
+	defer s.endQuery()
+
+	s.handle(ctx, w, r)
+}
+
+func (s *Server) beginQuery() {
+	s.shutmu.Lock()
+	defer s.shutmu.Unlock()
+
+	s.nactive++
+}
+
+func (s *Server) endQuery() {
+	s.shutmu.Lock()
+	defer s.shutmu.Unlock()
+
+	s.nactive--
+}
+
+// drain waits until no query is active, or ctx is done.
+func (s *Server) drain(ctx context.Context) error {
+	// the timer starts stopped and drained, so that the first Reset waits
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	defer timer.Stop()
+
+	for d := time.Millisecond; ; {
+		s.shutmu.Lock()
+		idle := s.nactive == 0
+		s.shutmu.Unlock()
+		if idle {
+			return nil
+		}
+
+		timer.Reset(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if d < 100*time.Millisecond {
+			d *= 2
+		}
+	}
+}
+
+// detachedContext carries the values of a context, without its deadline and
+// cancellation, so that the handlers of active queries run to completion
+// after the context of a Serve method is canceled, and the upstream queries
+// shared by a Cache outlive the query that started them.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
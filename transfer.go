@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	errTransferStart = errors.New("zone transfer does not start with a SOA record")
+	errTransferID    = errors.New("zone transfer response ID does not match query")
+)
+
+// Transfer is a zone transfer (AXFR) client, as defined in RFC 5936. The
+// records of the zone are yielded as each response message arrives, so that
+// zones of any size are transferred with bounded memory.
+type Transfer struct {
+	// Transport dials the stream connection to the primary server. The
+	// connection must not be pipelined, since the responses to a transfer
+	// query span many messages. If nil, a Transport with DisablePipelining
+	// set is used.
+	Transport AddrDialer
+}
+
+// Do sends the AXFR query to its remote address over a stream connection, and
+// calls fn with each record of the answers of the responses, in order. The
+// transfer is done after the closing SOA record, which is passed to fn like
+// the opening SOA record. If fn returns an error, the transfer is aborted and
+// the error is returned.
+func (t *Transfer) Do(ctx context.Context, query *Query, fn func(Resource) error) error {
+	addr := query.RemoteAddr
+	if uaddr, ok := addr.(*net.UDPAddr); ok {
+		addr = &net.TCPAddr{IP: uaddr.IP, Port: uaddr.Port, Zone: uaddr.Zone}
+	}
+
+	tport := t.Transport
+	if tport == nil {
+		tport = &Transport{DisablePipelining: true}
+	}
+
+	msg := *query.Message
+	if msg.ID == 0 {
+		msg.ID = randomID()
+	}
+
+	client := &Client{Transport: tport}
+	_, err := client.withConn(ctx, addr, func(conn Conn) (*Message, error) {
+		if err := conn.Send(&msg); err != nil {
+			return nil, err
+		}
+		if err := transfer(conn, msg.ID, fn); err != nil {
+			// the responses left unread must not be read by the next
+			// query of a pooled connection
+			discard(conn)
+			return nil, err
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// transfer reads the responses of the transfer query with ID id from conn.
+func transfer(conn Conn, id int, fn func(Resource) error) error {
+	var nsoa int
+	for {
+		var res Message
+		if err := conn.Recv(&res); err != nil {
+			return err
+		}
+		if res.ID != id {
+			return errTransferID
+		}
+		if res.RCode != NoError {
+			return fmt.Errorf("dns: zone transfer failed with rcode %d", res.RCode)
+		}
+
+		for _, rr := range res.Answers {
+			if rr.Record.Type() == TypeSOA {
+				nsoa++
+			} else if nsoa == 0 {
+				return errTransferStart
+			}
+
+			if err := fn(rr); err != nil {
+				return err
+			}
+			if nsoa == 2 {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTransfer(t *testing.T) {
+	t.Parallel()
+
+	soa := &SOA{
+		NS:      "ns.example.com.",
+		MBox:    "hostmaster.example.com.",
+		Serial:  1,
+		Refresh: time.Hour,
+		Retry:   time.Minute,
+		Expire:  time.Hour,
+		MinTTL:  time.Minute,
+	}
+
+	// each message of the transfer is sent once the records of the previous
+	// message are received
+	batches := [][]Record{
+		{soa, &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+		{&A{A: net.IPv4(192, 0, 2, 2).To4()}, &A{A: net.IPv4(192, 0, 2, 3).To4()}},
+		{&A{A: net.IPv4(192, 0, 2, 4).To4()}, soa},
+	}
+	nextc := make(chan struct{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		conn := &StreamConn{Conn: c}
+
+		var query Message
+		if err := conn.Recv(&query); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for i, batch := range batches {
+			if i > 0 {
+				<-nextc
+			}
+
+			msg := response(&query)
+			msg.Answers = nil
+			for _, rec := range batch {
+				msg.Answers = append(msg.Answers, Resource{
+					Name:   "example.com.",
+					Class:  ClassIN,
+					TTL:    time.Hour,
+					Record: rec,
+				})
+			}
+			if err := conn.Send(msg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	query := &Query{
+		RemoteAddr: ln.Addr(),
+		Message: &Message{
+			Questions: []Question{
+				{Name: "example.com.", Type: TypeAXFR, Class: ClassIN},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var got []Record
+	err = new(Transfer).Do(ctx, query, func(rr Resource) error {
+		got = append(got, rr.Record)
+
+		// the last record of a batch is yielded before the next batch is sent
+		if len(got) == 2 || len(got) == 4 {
+			nextc <- struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 6, len(got); want != got {
+		t.Fatalf("want %d records, got %d", want, got)
+	}
+	if want, got := TypeSOA, got[5].Type(); want != got {
+		t.Errorf("want last record type %d, got %d", want, got)
+	}
+}
+
+func TestTransferAbortedPooledConn(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		conn := &StreamConn{Conn: c}
+
+		var query Message
+		if err := conn.Recv(&query); err != nil {
+			return
+		}
+
+		for i := 1; i <= 3; i++ {
+			msg := response(&query)
+			msg.Answers = []Resource{
+				{Name: "example.com.", Class: ClassIN, TTL: time.Hour, Record: &SOA{NS: "ns.example.com.", MBox: "hostmaster.example.com."}},
+				{Name: "example.com.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, byte(i)).To4()}},
+			}
+			if err := conn.Send(msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	tport := &Transport{
+		DisablePipelining: true,
+		MaxIdleConns:      1,
+	}
+
+	query := &Query{
+		RemoteAddr: ln.Addr(),
+		Message: &Message{
+			Questions: []Question{
+				{Name: "example.com.", Type: TypeAXFR, Class: ClassIN},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errAbort := errors.New("abort")
+	err = (&Transfer{Transport: tport}).Do(ctx, query, func(rr Resource) error {
+		if rr.Record.Type() == TypeA {
+			return errAbort
+		}
+		return nil
+	})
+	if want, got := errAbort, err; want != got {
+		t.Fatalf("want transfer error %v, got %v", want, got)
+	}
+
+	if conn := tport.getIdle(poolKey(ln.Addr())); conn != nil {
+		conn.Close()
+		t.Error("want aborted transfer connection closed, got it in the idle pool")
+	}
+}
@@ -3,16 +3,31 @@ package dns
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
+	"net/http"
+	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Transport is an implementation of AddrDialer that manages connections to DNS
 // servers. Transport may modify the sending and receiving of messages but does
 // not modify messages.
 type Transport struct {
-	TLSConfig *tls.Config // optional TLS config, used by DialAddr
+	TLSConfig *tls.Config // optional TLS config, used by DialAddr and for DNS-over-HTTPS
+
+	// TLSHandshakeTimeout, if non-zero, is the maximum duration of the TLS
+	// handshake of a DNS-over-TLS connection dialed by DialAddr, so that a
+	// stalled handshake fails before the deadline of the query context.
+	TLSHandshakeTimeout time.Duration
+
+	// HTTPTransport, if non-nil, sends the requests of DNS-over-HTTPS queries
+	// in place of an HTTP/2 capable http.Transport built from DialContext and
+	// TLSConfig. An HTTP/3 round tripper, such as the http3.RoundTripper of
+	// github.com/quic-go/quic-go, sends queries over DNS-over-HTTP/3.
+	HTTPTransport http.RoundTripper
 
 	// DialContext func creates the underlying net connection. The DialContext
 	// method of a new net.Dialer is used by default.
@@ -21,19 +36,149 @@ type Transport struct {
 	// Proxy modifies the address of the DNS server to dial.
 	Proxy ProxyFunc
 
+	// ProxyDial creates the underlying net connection for stream oriented
+	// networks, such as through a SOCKS5 proxy. It is compatible with the
+	// DialContext method of golang.org/x/net/proxy.ContextDialer. If nil,
+	// DialContext is used.
+	ProxyDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
 	// DisablePipelining disables query pipelining for stream oriented
 	// connections as defined in RFC 7766, section 6.2.1.1.
 	DisablePipelining bool
 
+	// ReassignConflictingIDs rewrites the ID of a query sent on a pipelined
+	// connection when it collides with an inflight query, and restores the
+	// original ID on the response. If false, the conflicting query fails
+	// with ErrConflictingID.
+	ReassignConflictingIDs bool
+
+	// HealthCheckTimeout, if non-zero, enables a check of an idle pipelined
+	// connection before it is reused: the connection is evicted if it fails
+	// within HealthCheckTimeout, such as when the server has closed it. The
+	// check adds up to HealthCheckTimeout of latency to the query.
+	HealthCheckTimeout time.Duration
+
+	// MaxIdleConns, if non-zero, is the maximum number of idle stream (TCP
+	// and TLS) connections kept per server address for reuse by DialAddr
+	// when DisablePipelining is set. A connection is returned to the pool
+	// when closed after all of its queries are answered, and is discarded
+	// instead if a Send or Recv failed. Pipelined connections are shared by
+	// queries and are not pooled.
+	MaxIdleConns int
+
+	// IdleConnTimeout is the maximum duration a connection stays idle in the
+	// pool before it is closed. If zero, there is no limit.
+	IdleConnTimeout time.Duration
+
+	// Retries is the number of times a query on a packet-oriented (UDP)
+	// connection is resent when no response is received within
+	// RetryTimeout. Stream-oriented connections are reliable and never
+	// resend queries. The read deadline of the connection, such as the
+	// deadline of the query context, bounds all attempts.
+	Retries int
+
+	// RetryTimeout is the duration to wait for a response before a query is
+	// resent. If zero, DefaultRetryTimeout is used.
+	RetryTimeout time.Duration
+
+	// PacketConn, if non-nil, is used to send queries to packet-oriented
+	// (UDP) addresses with WriteTo, rather than dialing a new connection.
+	// Responses read with ReadFrom are matched to queries by message ID.
+	// The PacketConn is owned by the caller and is not closed by the
+	// Transport. Once a query is sent, a goroutine of the Transport reads
+	// from it until Close is called, so the caller must not read from it in
+	// the meantime. Its deadlines are only modified by Close.
+	PacketConn net.PacketConn
+
+	// VerifySourceAddr discards responses read from PacketConn with a source
+	// address other than the server the query was sent to, as a defense
+	// against spoofed responses. A connected UDP socket is already filtered
+	// by the operating system.
+	VerifySourceAddr bool
+
 	plinemu sync.Mutex
 	plines  map[net.Addr]*pipeline
+	ppline  *pipeline
+	idle    map[string][]idleConn
+	hclient *http.Client
 }
 
-// DialAddr dials a net Addr and returns a Conn.
+// DefaultRetryTimeout is the default duration a Transport waits for a response
+// before a query is resent.
+const DefaultRetryTimeout = time.Second
+
+// DialAddr dials a net Addr and returns a Conn. An OverHTTPSAddr is queried
+// with DNS-over-HTTPS requests.
 func (t *Transport) DialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
+	if addr, ok := addr.(OverHTTPSAddr); ok {
+		return t.dialHTTPS(ctx, addr), nil
+	}
+
+	conn, err := t.dialConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Retries > 0 && strings.HasPrefix(addr.Network(), "udp") {
+		timeout := t.RetryTimeout
+		if timeout == 0 {
+			timeout = DefaultRetryTimeout
+		}
+
+		return &retryConn{
+			Conn:    conn,
+			retries: t.Retries,
+			timeout: timeout,
+		}, nil
+	}
+	return conn, nil
+}
+
+// Close closes the idle and pipelined connections of the Transport, and stops
+// reading from PacketConn. A pending read on PacketConn is interrupted by a
+// read deadline in the past, which is cleared once the reader has stopped;
+// the PacketConn itself is left open. Queries in flight fail. The Transport
+// may be used again after Close.
+func (t *Transport) Close() error {
+	t.plinemu.Lock()
+	plines, ppline, idle := t.plines, t.ppline, t.idle
+	t.plines, t.ppline, t.idle = nil, nil, nil
+	t.plinemu.Unlock()
+
+	for _, pline := range plines {
+		pline.Close()
+	}
+	for _, conns := range idle {
+		for _, ic := range conns {
+			ic.conn.Close()
+		}
+	}
+
+	if ppline == nil {
+		return nil
+	}
+	if err := t.PacketConn.SetReadDeadline(aLongTimeAgo); err != nil {
+		return err
+	}
+	<-ppline.donec
+	return t.PacketConn.SetReadDeadline(time.Time{})
+}
+
+func (t *Transport) dialConn(ctx context.Context, addr net.Addr) (Conn, error) {
+	if t.PacketConn != nil && strings.HasPrefix(addr.Network(), "udp") {
+		return t.dialPacketConn(ctx, addr)
+	}
+
 	if !t.DisablePipelining {
-		if pline := t.getPipeline(addr); pline != nil && pline.alive() {
-			return pline.conn(), nil
+		if pline := t.getPipeline(addr); pline != nil {
+			if t.healthy(pline) {
+				return pline.conn(), nil
+			}
+			pline.Close()
+		}
+	} else if t.MaxIdleConns > 0 {
+		if conn := t.getIdle(poolKey(addr)); conn != nil {
+			return conn, nil
 		}
 	}
 
@@ -45,6 +190,35 @@ func (t *Transport) DialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
 	return conn, nil
 }
 
+// handshake runs the TLS handshake of conn until the deadline of ctx or the
+// TLSHandshakeTimeout, whichever is earlier.
+func (t *Transport) handshake(ctx context.Context, conn *tls.Conn) error {
+	deadline, ok := ctx.Deadline()
+	if t.TLSHandshakeTimeout > 0 {
+		if hd := time.Now().Add(t.TLSHandshakeTimeout); !ok || hd.Before(deadline) {
+			deadline, ok = hd, true
+		}
+	}
+	if !ok {
+		return conn.Handshake()
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+func (t *Transport) healthy(pline *pipeline) bool {
+	if t.HealthCheckTimeout > 0 {
+		return pline.healthy(t.HealthCheckTimeout)
+	}
+	return pline.alive()
+}
+
 func (t *Transport) dialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
 	conn, dnsOverTLS, err := t.dial(ctx, addr)
 	if err != nil {
@@ -65,10 +239,12 @@ func (t *Transport) dialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
 			cfg = t.TLSConfig.Clone()
 		}
 
-		conn = tls.Client(conn, cfg)
-		if err := conn.(*tls.Conn).Handshake(); err != nil {
+		tconn := tls.Client(conn, cfg)
+		if err := t.handshake(ctx, tconn); err != nil {
+			tconn.Close()
 			return nil, err
 		}
+		conn = tconn
 	}
 
 	if _, ok := conn.(net.PacketConn); ok {
@@ -85,20 +261,302 @@ func (t *Transport) dialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
 		pline := t.setPipeline(addr, sconn)
 		return pline.conn(), nil
 	}
+	if t.MaxIdleConns > 0 {
+		return &pooledConn{Conn: sconn, t: t, key: poolKey(addr)}, nil
+	}
 
 	return sconn, nil
 }
 
+// poolKey returns the idle pool key of addr, which is equal for addresses of
+// the same network and string form.
+func poolKey(addr net.Addr) string {
+	return addr.Network() + "/" + addr.String()
+}
+
+// idleConn is a stream connection in the idle pool of a Transport.
+type idleConn struct {
+	conn  Conn
+	since time.Time
+}
+
+// getIdle returns the most recently used idle connection for key, or nil.
+// Connections idle for longer than IdleConnTimeout are closed.
+func (t *Transport) getIdle(key string) Conn {
+	t.plinemu.Lock()
+	defer t.plinemu.Unlock()
+
+	conns := t.idle[key]
+	for len(conns) > 0 {
+		ic := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+
+		if t.idleExpired(ic) {
+			ic.conn.Close()
+			continue
+		}
+
+		t.idle[key] = conns
+		return &pooledConn{Conn: ic.conn, t: t, key: key}
+	}
+	delete(t.idle, key)
+	return nil
+}
+
+// putIdle adds conn to the idle pool for key, and reports whether the pool
+// had room for it.
+func (t *Transport) putIdle(key string, conn Conn) bool {
+	t.plinemu.Lock()
+	defer t.plinemu.Unlock()
+
+	conns := t.idle[key]
+	for len(conns) > 0 && t.idleExpired(conns[0]) {
+		conns[0].conn.Close()
+		conns = conns[1:]
+	}
+	if len(conns) >= t.MaxIdleConns {
+		t.idle[key] = conns
+		return false
+	}
+
+	if t.idle == nil {
+		t.idle = make(map[string][]idleConn)
+	}
+	t.idle[key] = append(conns, idleConn{conn: conn, since: time.Now()})
+	return true
+}
+
+func (t *Transport) idleExpired(ic idleConn) bool {
+	return t.IdleConnTimeout > 0 && time.Since(ic.since) > t.IdleConnTimeout
+}
+
+// pooledConn is a stream connection that is returned to the idle pool of a
+// Transport when closed, unless it failed or has a query without a response.
+type pooledConn struct {
+	Conn
+
+	t   *Transport
+	key string
+
+	mu      sync.Mutex
+	pending bool // a query was sent without its response read
+	broken  bool // a Send or Recv failed
+	closed  bool
+}
+
+func (c *pooledConn) Send(msg *Message) error {
+	c.mu.Lock()
+	c.pending = true
+	c.mu.Unlock()
+
+	err := c.Conn.Send(msg)
+	if err != nil {
+		c.mu.Lock()
+		c.broken = true
+		c.mu.Unlock()
+	}
+	return err
+}
+
+func (c *pooledConn) Recv(msg *Message) error {
+	err := c.Conn.Recv(msg)
+
+	c.mu.Lock()
+	if err != nil {
+		c.broken = true
+	} else {
+		c.pending = false
+	}
+	c.mu.Unlock()
+
+	return err
+}
+
+// Close returns the connection to the pool, or closes it. A connection with a
+// pending query is closed, which unblocks a concurrent Recv.
+func (c *pooledConn) Close() error {
+	c.mu.Lock()
+	closed, reuse := c.closed, !c.pending && !c.broken
+	c.closed = true
+	c.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+	if reuse && c.Conn.SetDeadline(time.Time{}) == nil && c.t.putIdle(c.key, c.Conn) {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+// discard marks conn as unfit for reuse, if it is a pooled connection, so that
+// Close closes the connection instead of returning it to the idle pool.
+func discard(conn Conn) {
+	if pc, ok := conn.(*pooledConn); ok {
+		pc.mu.Lock()
+		pc.broken = true
+		pc.mu.Unlock()
+	}
+}
+
+func (t *Transport) dialPacketConn(ctx context.Context, addr net.Addr) (Conn, error) {
+	addr, err := t.proxy(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t.plinemu.Lock()
+	if t.ppline == nil || !t.ppline.alive() {
+		uconn := &unconnectedConn{PacketConn: t.PacketConn}
+
+		t.ppline = &pipeline{
+			Conn:     &PacketConn{Conn: uconn},
+			reassign: t.ReassignConflictingIDs,
+			packet:   true,
+			inflight: make(map[int]pipelineTx),
+			donec:    make(chan struct{}),
+		}
+		if t.VerifySourceAddr {
+			t.ppline.from = func() net.Addr { return uconn.from }
+		}
+		go t.ppline.run()
+	}
+	pline := t.ppline
+	t.plinemu.Unlock()
+
+	conn := pline.conn().(*pipelineConn)
+	conn.w = &PacketConn{Conn: &unconnectedConn{PacketConn: t.PacketConn, addr: addr}}
+
+	return conn, nil
+}
+
+// unconnectedConn is a net.Conn that writes to addr, and reads from any
+// address, over a shared PacketConn.
+type unconnectedConn struct {
+	net.PacketConn
+
+	addr net.Addr
+	from net.Addr // source address of the last read
+}
+
+func (c *unconnectedConn) Read(b []byte) (int, error) {
+	n, from, err := c.ReadFrom(b)
+	c.from = from
+	return n, err
+}
+
+func (c *unconnectedConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.addr)
+}
+
+func (c *unconnectedConn) RemoteAddr() net.Addr { return c.addr }
+
+// Close does not close the shared PacketConn, which is owned by the caller.
+func (c *unconnectedConn) Close() error { return nil }
+
+// The deadlines of the shared PacketConn are not modified. The read deadline
+// of a query is handled by the pipeline.
+
+func (c *unconnectedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *unconnectedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *unconnectedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// retryConn is a packet-oriented Conn that resends the last query when no
+// response is received within the timeout, up to retries times.
+type retryConn struct {
+	Conn
+
+	retries int
+	timeout time.Duration
+
+	query        *Message  // last query sent
+	readDeadline time.Time // read deadline of all attempts
+}
+
+func (c *retryConn) Send(msg *Message) error {
+	m := *msg // shallow copy, msg may be reused by Recv
+	c.query = &m
+
+	return c.Conn.Send(msg)
+}
+
+func (c *retryConn) Recv(msg *Message) error {
+	for i := 0; ; i++ {
+		if i == c.retries || c.query == nil {
+			if err := c.Conn.SetReadDeadline(c.readDeadline); err != nil {
+				return err
+			}
+			return c.Conn.Recv(msg)
+		}
+
+		deadline := time.Now().Add(c.timeout)
+		last := !c.readDeadline.IsZero() && !deadline.Before(c.readDeadline)
+		if last {
+			deadline = c.readDeadline
+		}
+		if err := c.Conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+
+		err := c.Conn.Recv(msg)
+		if last || !errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+
+		if err := c.Conn.Send(c.query); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *retryConn) SetDeadline(t time.Time) error {
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *retryConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// DialNetwork returns the network DialAddr uses for addr, such as "udp" or
+// "tcp", after the address is modified by Proxy. DNS-over-TLS networks have a
+// "-tls" suffix, such as "tcp-tls", and DNS-over-HTTPS networks a "-https"
+// suffix. UDP addresses queried over PacketConn have the network of the
+// PacketConn.
+func (t *Transport) DialNetwork(ctx context.Context, addr net.Addr) (string, error) {
+	if addr, ok := addr.(OverHTTPSAddr); ok {
+		return addr.Network(), nil
+	}
+
+	shared := t.PacketConn != nil && strings.HasPrefix(addr.Network(), "udp")
+
+	addr, err := t.proxy(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	if shared {
+		return t.PacketConn.LocalAddr().Network(), nil
+	}
+	return addr.Network(), nil
+}
+
+// proxy returns the address of the DNS server to dial for addr.
+func (t *Transport) proxy(ctx context.Context, addr net.Addr) (net.Addr, error) {
+	if t.Proxy == nil {
+		return addr, nil
+	}
+	return t.Proxy(ctx, addr)
+}
+
 var defaultDialer = &net.Dialer{
 	Resolver: &net.Resolver{},
 }
 
 func (t *Transport) dial(ctx context.Context, addr net.Addr) (net.Conn, bool, error) {
-	if t.Proxy != nil {
-		var err error
-		if addr, err = t.Proxy(ctx, addr); err != nil {
-			return nil, false, err
-		}
+	addr, err := t.proxy(ctx, addr)
+	if err != nil {
+		return nil, false, err
 	}
 
 	network, dnsOverTLS := addr.Network(), false
@@ -110,6 +568,9 @@ func (t *Transport) dial(ctx context.Context, addr net.Addr) (net.Conn, bool, er
 	if dial == nil {
 		dial = defaultDialer.DialContext
 	}
+	if t.ProxyDial != nil && strings.HasPrefix(network, "tcp") {
+		dial = t.ProxyDial
+	}
 
 	conn, err := dial(ctx, network, addr.String())
 	if err != nil {
@@ -133,7 +594,9 @@ func (t *Transport) getPipeline(addr net.Addr) *pipeline {
 func (t *Transport) setPipeline(addr net.Addr, conn Conn) *pipeline {
 	pline := &pipeline{
 		Conn:     conn,
+		reassign: t.ReassignConflictingIDs,
 		inflight: make(map[int]pipelineTx),
+		donec:    make(chan struct{}),
 	}
 	go pline.run()
 
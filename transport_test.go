@@ -3,8 +3,12 @@ package dns
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -62,6 +66,78 @@ var transportTests = []struct {
 			},
 		},
 	},
+	{
+		name: "single-MX-match",
+
+		req: &Message{
+			ID:        3,
+			Questions: []Question{questions["MX"]},
+		},
+
+		res: &Message{
+			ID:        3,
+			Response:  true,
+			Questions: []Question{questions["MX"]},
+			Answers: []Resource{
+				{
+					Name:   "MX.dev.",
+					Class:  ClassIN,
+					TTL:    60 * time.Second,
+					Record: answers[questions["MX"]],
+				},
+			},
+		},
+	},
+	{
+		name: "single-SRV-match",
+
+		req: &Message{
+			ID:        4,
+			Questions: []Question{questions["SRV"]},
+		},
+
+		res: &Message{
+			ID:        4,
+			Response:  true,
+			Questions: []Question{questions["SRV"]},
+			Answers: []Resource{
+				{
+					Name:   "_sip._tcp.example.com.",
+					Class:  ClassIN,
+					TTL:    60 * time.Second,
+					Record: answers[questions["SRV"]],
+				},
+			},
+		},
+	},
+	{
+		name: "reverse-PTR-match",
+
+		req: &Message{
+			ID:        5,
+			Questions: []Question{questions["PTR"], questions["PTR6"]},
+		},
+
+		res: &Message{
+			ID:        5,
+			Response:  true,
+			Questions: []Question{questions["PTR"], questions["PTR6"]},
+			Answers: []Resource{
+				{
+					Name:   "1.0.0.127.in-addr.arpa.",
+					Class:  ClassIN,
+					TTL:    60 * time.Second,
+					Record: answers[questions["PTR"]],
+				},
+				{
+					Name:   "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.",
+					Class:  ClassIN,
+					TTL:    60 * time.Second,
+					Record: answers[questions["PTR6"]],
+				},
+			},
+		},
+	},
 }
 
 func TestTransport(t *testing.T) {
@@ -145,6 +221,268 @@ func TestTransportProxy(t *testing.T) {
 	}
 }
 
+func TestTransportProxyDial(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.IP = net.IPv4(127, 0, 0, 1)
+
+	proxy, nconn := mustSOCKS5Proxy()
+	defer proxy.Close()
+
+	client := &Client{
+		Transport: &Transport{
+			ProxyDial: socks5Dialer(proxy.Addr().String()),
+		},
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+
+	msg, err := client.Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := answers[questions["A"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answer %+v, got %+v", want, got)
+	}
+	if want, got := int32(1), atomic.LoadInt32(nconn); want != got {
+		t.Errorf("want %d proxied connections, got %d", want, got)
+	}
+}
+
+func TestTransportPacketConn(t *testing.T) {
+	t.Parallel()
+
+	remotec := make(chan net.Addr, 2)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		remotec <- r.RemoteAddr
+
+		(&answerHandler{answers}).ServeDNS(ctx, w, r)
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.IP = net.IPv4(127, 0, 0, 1)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := &Client{
+		Transport: &Transport{
+			PacketConn: conn,
+		},
+	}
+
+	qs := []Question{questions["A"], questions["AAAA"]}
+
+	errc := make(chan error, len(qs))
+	for _, q := range qs {
+		q := q
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			query := &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{q},
+				},
+			}
+
+			msg, err := client.Do(ctx, query)
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			if want, got := answers[q], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+				errc <- fmt.Errorf("want answer %+v, got %+v", want, got)
+				return
+			}
+			errc <- nil
+		}()
+	}
+
+	for range qs {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+		if want, got := conn.LocalAddr().String(), (<-remotec).String(); want != got {
+			t.Errorf("want query from %s, got %s", want, got)
+		}
+	}
+}
+
+func TestTransportClosePacketConn(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr.IP = net.IPv4(127, 0, 0, 1)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tport := &Transport{PacketConn: conn}
+	client := &Client{Transport: tport}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+	if _, err := client.Do(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tport.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reader has stopped, so a datagram sent to conn reaches the caller
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	if _, err := peer.WriteTo([]byte("ping"), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 16)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "ping", string(buf[:n]); want != got {
+		t.Errorf("want read %q, got %q", want, got)
+	}
+
+	// the Transport is usable again
+	conn.SetReadDeadline(time.Time{})
+	if _, err := client.Do(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	if err := tport.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransportVerifySourceAddr(t *testing.T) {
+	t.Parallel()
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	spoofer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, maxPacketLen)
+		n, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		var query Message
+		if _, err := query.Unpack(buf[:n]); err != nil {
+			return
+		}
+
+		reply := func(pc net.PacketConn, ip net.IP) {
+			msg := query
+			msg.Response = true
+			msg.Answers = []Resource{
+				{
+					Name:   query.Questions[0].Name,
+					Class:  ClassIN,
+					TTL:    time.Minute,
+					Record: &A{A: ip},
+				},
+			}
+
+			raw, err := msg.Pack(nil, true)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(raw, addr)
+		}
+
+		reply(spoofer, net.IPv4(192, 0, 2, 1).To4())
+		time.Sleep(10 * time.Millisecond)
+		reply(server, net.IPv4(127, 0, 0, 1).To4())
+	}()
+
+	client := &Client{
+		Transport: &Transport{
+			PacketConn:       conn,
+			VerifySourceAddr: true,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	query := &Query{
+		RemoteAddr: server.LocalAddr(),
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	}
+
+	msg, err := client.Do(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := answers[questions["A"]], msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answer %+v, got %+v", want, got)
+	}
+}
+
 func testTransport(t *testing.T, tport *Transport, addr net.Addr) {
 	for _, test := range transportTests {
 		test := test
@@ -185,6 +523,26 @@ var (
 			Type:  TypeAAAA,
 			Class: ClassIN,
 		},
+		"MX": {
+			Name:  "MX.dev.",
+			Type:  TypeMX,
+			Class: ClassIN,
+		},
+		"SRV": {
+			Name:  "_sip._tcp.example.com.",
+			Type:  TypeSRV,
+			Class: ClassIN,
+		},
+		"PTR": {
+			Name:  mustReverseAddr(net.IPv4(127, 0, 0, 1)),
+			Type:  TypePTR,
+			Class: ClassIN,
+		},
+		"PTR6": {
+			Name:  mustReverseAddr(net.IPv6loopback),
+			Type:  TypePTR,
+			Class: ClassIN,
+		},
 	}
 
 	answers = map[Question]Record{
@@ -194,9 +552,33 @@ var (
 		questions["AAAA"]: &AAAA{
 			AAAA: net.ParseIP("::1"),
 		},
+		questions["MX"]: &MX{
+			Pref: 10,
+			MX:   "mail.MX.dev.",
+		},
+		questions["SRV"]: &SRV{
+			Priority: 10,
+			Weight:   5,
+			Port:     5060,
+			Target:   "sip.example.com.",
+		},
+		questions["PTR"]: &PTR{
+			PTR: "localhost.",
+		},
+		questions["PTR6"]: &PTR{
+			PTR: "localhost.",
+		},
 	}
 )
 
+func mustReverseAddr(ip net.IP) string {
+	name, err := ReverseAddr(ip)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
 type answerHandler struct {
 	Answers map[Question]Record
 }
@@ -208,3 +590,507 @@ func (a *answerHandler) ServeDNS(ctx context.Context, w MessageWriter, r *Query)
 		}
 	}
 }
+
+// mustSOCKS5Proxy starts a SOCKS5 proxy (RFC 1928) supporting the CONNECT
+// command to IPv4 addresses without authentication. The number of proxied
+// connections is counted.
+func mustSOCKS5Proxy() (net.Listener, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	nconn := new(int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				buf := make([]byte, 255)
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+					return
+				}
+				if _, err := conn.Write([]byte{0x05, 0x00}); err != nil {
+					return
+				}
+
+				if _, err := io.ReadFull(conn, buf[:10]); err != nil {
+					return
+				}
+				if buf[1] != 0x01 || buf[3] != 0x01 {
+					conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+
+				upstream, err := net.DialTCP("tcp", nil, &net.TCPAddr{
+					IP:   net.IP(buf[4:8]),
+					Port: int(nbo.Uint16(buf[8:10])),
+				})
+				if err != nil {
+					conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+
+				atomic.AddInt32(nconn, 1)
+				if _, err := conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+					return
+				}
+
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return ln, nconn
+}
+
+// socks5Dialer dials IPv4 TCP addresses through the SOCKS5 proxy at proxy.
+func socks5Dialer(proxy string) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		addr, err := net.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := new(net.Dialer).DialContext(ctx, "tcp", proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		req := []byte{
+			0x05, 0x01, 0x00, // VER=5 NMETHODS=1 METHODS=NO AUTH
+			0x05, 0x01, 0x00, 0x01, // VER=5 CMD=CONNECT RSV ATYP=IPv4
+		}
+		req = append(req, addr.IP.To4()...)
+		req = append(req, byte(addr.Port>>8), byte(addr.Port))
+
+		if _, err := conn.Write(req); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		res := make([]byte, 12)
+		if _, err := io.ReadFull(conn, res); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if res[1] != 0x00 || res[3] != 0x00 {
+			conn.Close()
+			return nil, errors.New("socks5 connect failed")
+		}
+
+		return conn, nil
+	}
+}
+
+func TestTransportDialNetwork(t *testing.T) {
+	t.Parallel()
+
+	udpAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+	tcpAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 853}
+
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pconn.Close()
+
+	overTLS := func(_ context.Context, _ net.Addr) (net.Addr, error) {
+		return OverTLSAddr{tcpAddr}, nil
+	}
+
+	tests := []struct {
+		name string
+
+		tport *Transport
+		addr  net.Addr
+
+		network string
+	}{
+		{name: "udp", tport: new(Transport), addr: udpAddr, network: "udp"},
+		{name: "tcp", tport: new(Transport), addr: tcpAddr, network: "tcp"},
+		{name: "tls", tport: new(Transport), addr: OverTLSAddr{tcpAddr}, network: "tcp-tls"},
+		{name: "https", tport: new(Transport), addr: OverHTTPSAddr{Addr: tcpAddr}, network: "tcp-https"},
+		{name: "proxy", tport: &Transport{Proxy: overTLS}, addr: udpAddr, network: "tcp-tls"},
+		{name: "packet-conn", tport: &Transport{PacketConn: pconn}, addr: udpAddr, network: "udp"},
+		{name: "packet-conn-tcp", tport: &Transport{PacketConn: pconn}, addr: tcpAddr, network: "tcp"},
+	}
+
+	for _, test := range tests {
+		network, err := test.tport.DialNetwork(context.Background(), test.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := test.network, network; want != got {
+			t.Errorf("%s: want network %q for %v, got %q", test.name, want, test.addr, got)
+		}
+	}
+
+	errProxy := errors.New("proxy failed")
+	tport := &Transport{
+		Proxy: func(_ context.Context, _ net.Addr) (net.Addr, error) { return nil, errProxy },
+	}
+	if _, err := tport.DialNetwork(context.Background(), udpAddr); err != errProxy {
+		t.Errorf("want proxy error %v, got %v", errProxy, err)
+	}
+}
+
+func TestTransportHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var accepts int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepts, 1)
+
+			// answer a single query, then close the connection
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				sconn := &StreamConn{Conn: conn}
+
+				var msg Message
+				if err := sconn.Recv(&msg); err != nil {
+					return
+				}
+				sconn.Send(response(&msg))
+			}(conn)
+		}
+	}()
+
+	tport := &Transport{HealthCheckTimeout: 50 * time.Millisecond}
+	client := &Client{Transport: tport}
+
+	for i := 0; i < 3; i++ {
+		query := &Query{
+			RemoteAddr: ln.Addr(),
+			Message: &Message{
+				ID:        i,
+				Questions: []Question{questions["A"]},
+			},
+		}
+
+		if _, err := client.Do(context.Background(), query); err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+	}
+
+	if want, got := int32(3), atomic.LoadInt32(&accepts); want != got {
+		t.Errorf("want %d connections, got %d", want, got)
+	}
+}
+
+func TestTransportRetries(t *testing.T) {
+	t.Parallel()
+
+	// mustDroppingServer answers every query after the first, which is
+	// dropped as if lost.
+	mustDroppingServer := func() (net.PacketConn, *int32) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+
+		var nquery int32
+		go func() {
+			buf := make([]byte, maxPacketLen)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				if atomic.AddInt32(&nquery, 1) == 1 {
+					continue
+				}
+
+				var msg Message
+				if _, err := msg.Unpack(buf[:n]); err != nil {
+					continue
+				}
+				if raw, err := response(&msg).Pack(nil, true); err == nil {
+					conn.WriteTo(raw, addr)
+				}
+			}
+		}()
+		return conn, &nquery
+	}
+
+	tests := []struct {
+		name string
+
+		packetConn bool
+	}{
+		{name: "dial"},
+		{name: "packet-conn", packetConn: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, nquery := mustDroppingServer()
+			defer srv.Close()
+
+			tport := &Transport{
+				Retries:      2,
+				RetryTimeout: 50 * time.Millisecond,
+			}
+			if test.packetConn {
+				conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+
+				tport.PacketConn = conn
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			query := &Query{
+				RemoteAddr: srv.LocalAddr(),
+				Message: &Message{
+					Questions: []Question{questions["A"]},
+				},
+			}
+
+			if _, err := (&Client{Transport: tport}).Do(ctx, query); err != nil {
+				t.Fatal(err)
+			}
+			if want, got := int32(2), atomic.LoadInt32(nquery); want != got {
+				t.Errorf("want %d queries sent, got %d", want, got)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		tport := &Transport{
+			Retries:      5,
+			RetryTimeout: time.Second,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		query := &Query{
+			RemoteAddr: conn.LocalAddr(),
+			Message: &Message{
+				Questions: []Question{questions["A"]},
+			},
+		}
+
+		start := time.Now()
+		if _, err := (&Client{Transport: tport}).Do(ctx, query); err != context.Canceled {
+			t.Errorf("want error %q, got %v", context.Canceled, err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("want canceled query to return within the retry timeout, took %s", d)
+		}
+	})
+}
+
+func TestTransportIdleConns(t *testing.T) {
+	t.Parallel()
+
+	// mustStreamServer answers up to n queries per connection, then closes
+	// it. The number of accepted connections is counted.
+	mustStreamServer := func(n int) (net.Listener, *int32) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+
+		var accepts int32
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				atomic.AddInt32(&accepts, 1)
+
+				go func(conn net.Conn) {
+					defer conn.Close()
+
+					sconn := &StreamConn{Conn: conn}
+					for i := 0; i < n; i++ {
+						var msg Message
+						if err := sconn.Recv(&msg); err != nil {
+							return
+						}
+						if err := sconn.Send(response(&msg)); err != nil {
+							return
+						}
+					}
+				}(conn)
+			}
+		}()
+		return ln, &accepts
+	}
+
+	query := func(client *Client, addr net.Addr) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := client.Do(ctx, &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{questions["A"]},
+			},
+		})
+		return err
+	}
+
+	t.Run("reuse", func(t *testing.T) {
+		t.Parallel()
+
+		ln, accepts := mustStreamServer(10)
+		defer ln.Close()
+
+		client := &Client{
+			Transport: &Transport{
+				DisablePipelining: true,
+				MaxIdleConns:      1,
+			},
+		}
+
+		for i := 0; i < 3; i++ {
+			if err := query(client, ln.Addr()); err != nil {
+				t.Fatalf("query %d: %v", i, err)
+			}
+		}
+
+		if want, got := int32(1), atomic.LoadInt32(accepts); want != got {
+			t.Errorf("want %d connections, got %d", want, got)
+		}
+	})
+
+	t.Run("discard-broken", func(t *testing.T) {
+		t.Parallel()
+
+		ln, accepts := mustStreamServer(1)
+		defer ln.Close()
+
+		client := &Client{
+			Transport: &Transport{
+				DisablePipelining: true,
+				MaxIdleConns:      1,
+			},
+		}
+
+		if err := query(client, ln.Addr()); err != nil {
+			t.Fatal(err)
+		}
+
+		// the pooled connection was closed by the server
+		if err := query(client, ln.Addr()); err == nil {
+			t.Fatal("want error on closed connection")
+		}
+
+		if err := query(client, ln.Addr()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := int32(2), atomic.LoadInt32(accepts); want != got {
+			t.Errorf("want %d connections, got %d", want, got)
+		}
+	})
+
+	t.Run("idle-timeout", func(t *testing.T) {
+		t.Parallel()
+
+		ln, accepts := mustStreamServer(10)
+		defer ln.Close()
+
+		client := &Client{
+			Transport: &Transport{
+				DisablePipelining: true,
+				MaxIdleConns:      1,
+				IdleConnTimeout:   20 * time.Millisecond,
+			},
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := query(client, ln.Addr()); err != nil {
+				t.Fatalf("query %d: %v", i, err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		if want, got := int32(2), atomic.LoadInt32(accepts); want != got {
+			t.Errorf("want %d connections, got %d", want, got)
+		}
+	})
+}
+
+func TestTransportTLSHandshakeTimeout(t *testing.T) {
+	t.Parallel()
+
+	// the server accepts connections but never completes a TLS handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tport := &Transport{
+		TLSHandshakeTimeout: 100 * time.Millisecond,
+		TLSConfig:           &tls.Config{InsecureSkipVerify: true},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err = tport.DialAddr(ctx, OverTLSAddr{ln.Addr()})
+	if err == nil {
+		t.Fatal("want handshake error, got none")
+	}
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Errorf("want timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want dial to fail within the handshake timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,83 @@
+package dns
+
+import "sort"
+
+// The type bitmap encoding of RFC 4034, section 4.1.2, shared by the NSEC
+// family of records and CSYNC: the types are split into windows by their
+// high-order byte, and each window is a bitmap of at most 32 bytes.
+
+// typeBitmapLen returns the encoded size of the type bitmap for types.
+func typeBitmapLen(types []Type) int {
+	var n int
+	for _, win := range typeWindows(types) {
+		n += 2 + len(win.bitmap)
+	}
+	return n
+}
+
+// packTypeBitmap appends the type bitmap for types to b.
+func packTypeBitmap(b []byte, types []Type) []byte {
+	for _, win := range typeWindows(types) {
+		b = append(append(b, win.num, byte(len(win.bitmap))), win.bitmap...)
+	}
+	return b
+}
+
+// unpackTypeBitmap decodes the types of the type bitmap in b.
+func unpackTypeBitmap(b []byte) ([]Type, error) {
+	var (
+		types []Type
+		last  = -1
+	)
+
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, errResourceLen
+		}
+
+		num, n := int(b[0]), int(b[1])
+		if num <= last || n == 0 || n > 32 {
+			return nil, errTypeBitmap
+		}
+		if len(b) < 2+n {
+			return nil, errResourceLen
+		}
+
+		for i, bits := range b[2 : 2+n] {
+			for j := 0; j < 8; j++ {
+				if bits&(0x80>>uint(j)) > 0 {
+					types = append(types, Type(num<<8|i*8+j))
+				}
+			}
+		}
+
+		last, b = num, b[2+n:]
+	}
+	return types, nil
+}
+
+type typeWindow struct {
+	num    byte
+	bitmap []byte
+}
+
+func typeWindows(types []Type) []typeWindow {
+	sorted := make([]Type, len(types))
+	copy(sorted, types)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var wins []typeWindow
+	for _, t := range sorted {
+		num, bit := byte(t>>8), int(t&0xff)
+		if len(wins) == 0 || wins[len(wins)-1].num != num {
+			wins = append(wins, typeWindow{num: num})
+		}
+
+		win := &wins[len(wins)-1]
+		for len(win.bitmap) <= bit/8 {
+			win.bitmap = append(win.bitmap, 0)
+		}
+		win.bitmap[bit/8] |= 0x80 >> uint(bit%8)
+	}
+	return wins
+}
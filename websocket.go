@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// WebSocketBinaryMessage is the WebSocket message type of binary data frames
+// (RFC 6455, section 11.8).
+const WebSocketBinaryMessage = 2
+
+var errWebSocketMessageType = errors.New("unexpected WebSocket message type")
+
+// WebSocketConn is a WebSocket connection of a WebSocket implementation, such
+// as a *websocket.Conn of the github.com/gorilla/websocket package.
+type WebSocketConn interface {
+	// ReadMessage reads the next data message.
+	ReadMessage() (messageType int, p []byte, err error)
+	// WriteMessage writes data as a single message.
+	WriteMessage(messageType int, data []byte) error
+
+	Close() error
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// WebSocketStream is a stream-oriented net Conn over a WebSocket connection,
+// for browser clients that cannot send DNS messages over UDP or TCP. Each
+// write is sent as a binary message, so that a DNS message and its 2-byte
+// length prefix (RFC 1035, section 4.2.2) make up one frame.
+//
+// A WebSocketStream is used with a StreamConn to send queries, or served with
+// Server.ServeWebSocket.
+type WebSocketStream struct {
+	WebSocketConn
+
+	rbuf []byte // unread data of the last message
+}
+
+// Read reads data from the binary messages of the WebSocket connection.
+func (s *WebSocketStream) Read(b []byte) (int, error) {
+	for len(s.rbuf) == 0 {
+		typ, p, err := s.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if typ != WebSocketBinaryMessage {
+			return 0, errWebSocketMessageType
+		}
+		s.rbuf = p
+	}
+
+	n := copy(b, s.rbuf)
+	s.rbuf = s.rbuf[n:]
+	return n, nil
+}
+
+// Write writes b as a single binary message.
+func (s *WebSocketStream) Write(b []byte) (int, error) {
+	if err := s.WriteMessage(WebSocketBinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetDeadline sets the read and write deadlines of the WebSocket connection.
+func (s *WebSocketStream) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
+
+// ServeWebSocket serves DNS queries read from the WebSocket connection ws,
+// framed as by WebSocketStream. It returns once reading from ws fails, such
+// as when the client closes the connection. On shutdown, or if the server is
+// already shutting down, ws is closed once its queries are answered;
+// otherwise it is left open for the caller to close.
+func (s *Server) ServeWebSocket(ctx context.Context, ws WebSocketConn) {
+	s.serveStream(ctx, &WebSocketStream{WebSocketConn: ws})
+}
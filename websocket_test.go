@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServeWebSocket(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Handler: &answerHandler{answers},
+	}
+
+	cws, sws := loopbackWebSocket()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		srv.ServeWebSocket(context.Background(), sws)
+	}()
+
+	conn := &StreamConn{
+		Conn: &WebSocketStream{WebSocketConn: cws},
+	}
+
+	query := &Message{
+		ID:        0x1234,
+		Questions: []Question{questions["A"]},
+	}
+	if err := conn.Send(query); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if err := conn.Recv(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 0x1234, msg.ID; want != got {
+		t.Errorf("want message ID %#x, got %#x", want, got)
+	}
+	if want, got := []Resource{
+		{Name: questions["A"].Name, Class: ClassIN, TTL: time.Minute, Record: answers[questions["A"]]},
+	}, msg.Answers; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answers %+v, got %+v", want, got)
+	}
+
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ServeWebSocket did not return after the connection was closed")
+	}
+}
+
+// loopbackWebSocket returns a pair of connected in-memory WebSocket
+// connections.
+func loopbackWebSocket() (*memWebSocket, *memWebSocket) {
+	a2b, b2a := make(chan wsMessage, 16), make(chan wsMessage, 16)
+	closed := make(chan struct{})
+	once := new(sync.Once)
+
+	a := &memWebSocket{in: b2a, out: a2b, closed: closed, once: once}
+	b := &memWebSocket{in: a2b, out: b2a, closed: closed, once: once}
+	return a, b
+}
+
+type wsMessage struct {
+	typ  int
+	data []byte
+}
+
+type memWebSocket struct {
+	in, out chan wsMessage
+
+	closed chan struct{}
+	once   *sync.Once
+}
+
+func (ws *memWebSocket) ReadMessage() (int, []byte, error) {
+	select {
+	case msg := <-ws.in:
+		return msg.typ, msg.data, nil
+	case <-ws.closed:
+		return 0, nil, io.EOF
+	}
+}
+
+func (ws *memWebSocket) WriteMessage(typ int, data []byte) error {
+	msg := wsMessage{typ: typ, data: append([]byte(nil), data...)}
+
+	select {
+	case ws.out <- msg:
+		return nil
+	case <-ws.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (ws *memWebSocket) Close() error {
+	ws.once.Do(func() { close(ws.closed) })
+	return nil
+}
+
+func (ws *memWebSocket) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (ws *memWebSocket) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (ws *memWebSocket) SetReadDeadline(time.Time) error  { return nil }
+func (ws *memWebSocket) SetWriteDeadline(time.Time) error { return nil }
@@ -4,9 +4,14 @@ import (
 	"context"
 	"strings"
 	"time"
+
+	"github.com/jjeffcaii/dns/edns"
 )
 
-// RRSet is a set of resource records indexed by name and type.
+// RRSet is a set of resource records indexed by name and type. Names are
+// relative to the zone origin, and "@" is the origin itself. Names of queries
+// are matched in lower case, so the names of an RRSet served by a Zone are
+// lower case.
 type RRSet map[string]map[Type][]Record
 
 // Zone is a contiguous set DNS records under an origin domain name.
@@ -17,30 +22,49 @@ type Zone struct {
 	SOA *SOA
 
 	RRs RRSet
+
+	// RefuseOutOfZone answers queries with no question for a name in the
+	// zone with a REFUSED message, instead of NXDOMAIN, which implies
+	// authority over the name.
+	RefuseOutOfZone bool
 }
 
-// ServeDNS answers DNS queries in zone z.
+// ServeDNS answers DNS queries in zone z. Questions for names in the zone
+// without records of the queried type, such as AAAA questions for names with
+// only A records, are answered with an empty NODATA response holding the SOA.
+// SOA queries at the zone apex with an empty EDNS EXPIRE option are answered
+// with the expire timer of the zone in the option.
 func (z *Zone) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
 	w.Authoritative(true)
 
-	var found bool
+	var found, inZone, exists bool
 	for _, q := range r.Questions {
-		if !strings.HasSuffix(q.Name, z.Origin) {
+		dn, ok := z.relative(q.Name)
+		if !ok {
 			continue
 		}
-		if q.Type == TypeSOA && q.Name == z.Origin {
+		inZone = true
+
+		if dn == "@" && z.SOA != nil {
+			exists = true
+		}
+
+		if q.Type == TypeSOA && dn == "@" && z.SOA != nil {
 			w.Answer(q.Name, z.TTL, z.SOA)
 			found = true
 
+			if exp, ok := r.Expire(); ok && exp.Empty {
+				z.expire(w)
+			}
+
 			continue
 		}
 
-		dn := q.Name[:len(q.Name)-len(z.Origin)-1]
-
 		rrs, ok := z.RRs[dn]
 		if !ok {
 			continue
 		}
+		exists = true
 
 		for _, rr := range rrs[q.Type] {
 			w.Answer(q.Name, z.TTL, rr)
@@ -48,7 +72,10 @@ func (z *Zone) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
 
 			if r.RecursionDesired && rr.Type() == TypeCNAME {
 				name := rr.(*CNAME).CNAME
-				dn := name[:len(name)-len(z.Origin)-1]
+				dn, ok := z.relative(name)
+				if !ok {
+					continue
+				}
 
 				if rrs, ok := z.RRs[dn]; ok {
 					for _, rr := range rrs[q.Type] {
@@ -60,10 +87,47 @@ func (z *Zone) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
 	}
 
 	if !found {
-		w.Status(NXDomain)
+		if !inZone && z.RefuseOutOfZone {
+			w.Authoritative(false)
+			w.Status(Refused)
+			return
+		}
+
+		// a name with records of other types is answered with NODATA
+		if !exists {
+			w.Status(NXDomain)
+		}
 
 		if z.SOA != nil {
 			w.Authority(z.Origin, z.TTL, z.SOA)
 		}
 	}
 }
+
+// expire adds an EDNS EXPIRE option (RFC 7314) holding the SOA expire timer
+// of the zone, for a secondary server that requested it.
+func (z *Zone) expire(w MessageWriter) {
+	o, err := edns.Expire{Expire: z.SOA.Expire}.Option()
+	if err != nil {
+		return
+	}
+
+	w.Additional(".", 0, &OPT{Options: []edns.Option{o}})
+}
+
+// relative returns name relative to the zone origin, in lower case, and
+// whether the name is within the zone. Names are compared case-insensitively.
+func (z *Zone) relative(name string) (string, bool) {
+	name, origin := strings.ToLower(name), strings.ToLower(z.Origin)
+
+	switch {
+	case name == origin:
+		return "@", true
+	case origin == ".":
+		return name[:len(name)-1], true
+	case strings.HasSuffix(name, "."+origin):
+		return name[:len(name)-len(origin)-1], true
+	default:
+		return "", false
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/jjeffcaii/dns/edns"
 )
 
 var localhostZone = &Zone{
@@ -189,3 +191,275 @@ func TestZone(t *testing.T) {
 		}
 	}
 }
+
+func TestZoneRefuseOutOfZone(t *testing.T) {
+	t.Parallel()
+
+	zone := *localhostZone
+	zone.RefuseOutOfZone = true
+
+	srv := mustServer(&zone)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := new(Client)
+
+	q := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{
+					Name:  "unknown.",
+					Type:  TypeA,
+					Class: ClassIN,
+				},
+			},
+		},
+	}
+
+	res, err := client.Do(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := Refused, res.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if res.Authoritative {
+		t.Error("want non-authoritative response")
+	}
+	if want, got := 0, len(res.Authorities); want != got {
+		t.Errorf("want %d authorities, got %d", want, got)
+	}
+
+	q.Message = &Message{
+		Questions: []Question{
+			{
+				Name:  "unknown.localhost.",
+				Type:  TypeA,
+				Class: ClassIN,
+			},
+		},
+	}
+
+	if res, err = client.Do(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := NXDomain, res.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if !res.Authoritative {
+		t.Error("want authoritative response")
+	}
+	if want, got := 1, len(res.Authorities); want != got {
+		t.Fatalf("want %d authorities, got %d", want, got)
+	}
+	if _, ok := res.Authorities[0].Record.(*SOA); !ok {
+		t.Errorf("non SOA authority record: %+v", res.Authorities[0])
+	}
+}
+
+func TestZoneApexSOA(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "example.com.",
+		TTL:    time.Hour,
+		SOA: &SOA{
+			NS:     "ns.example.com.",
+			MBox:   "hostmaster.example.com.",
+			Serial: 2021,
+		},
+		RRs: RRSet{
+			"www": {
+				TypeA: {&A{net.IPv4(192, 0, 2, 1).To4()}},
+			},
+		},
+	}
+
+	srv := mustServer(zone)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := new(Client)
+
+	for _, name := range []string{"example.com.", "EXAMPLE.com."} {
+		q := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{
+						Name:  name,
+						Type:  TypeSOA,
+						Class: ClassIN,
+					},
+				},
+			},
+		}
+
+		res, err := client.Do(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := NoError, res.RCode; want != got {
+			t.Errorf("want rcode %d, got %d", want, got)
+		}
+		if !res.Authoritative {
+			t.Error("want authoritative response")
+		}
+		if want, got := 1, len(res.Answers); want != got {
+			t.Fatalf("want %d answers, got %d", want, got)
+		}
+		soa, ok := res.Answers[0].Record.(*SOA)
+		if !ok {
+			t.Fatalf("non SOA answer record: %+v", res.Answers[0])
+		}
+		if want, got := zone.SOA, soa; !reflect.DeepEqual(*want, *got) {
+			t.Errorf("want SOA record %+v, got %+v", *want, *got)
+		}
+	}
+
+	// names below the apex are matched regardless of case too
+	for _, name := range []string{"www.example.com.", "WWW.Example.COM."} {
+		q := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{
+						Name:  name,
+						Type:  TypeA,
+						Class: ClassIN,
+					},
+				},
+			},
+		}
+
+		res, err := client.Do(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := NoError, res.RCode; want != got {
+			t.Errorf("%s: want rcode %d, got %d", name, want, got)
+		}
+		if want, got := 1, len(res.Answers); want != got {
+			t.Fatalf("%s: want %d answers, got %d", name, want, got)
+		}
+		if want, got := name, res.Answers[0].Name; want != got {
+			t.Errorf("want answer name %q, got %q", want, got)
+		}
+	}
+}
+
+func TestZoneNoData(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&Zone{
+		Origin: "localhost.",
+		TTL:    time.Hour,
+		SOA:    localhostZone.SOA,
+		RRs: RRSet{
+			"v4only": {
+				TypeA: {&A{net.IPv4(10, 42, 0, 4).To4()}},
+			},
+		},
+	})
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "v4only.localhost.", Type: TypeAAAA, Class: ClassIN},
+			},
+		},
+	}
+
+	res, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := NoError, res.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+	if !res.Authoritative {
+		t.Error("want authoritative response")
+	}
+	if want, got := 0, len(res.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+	if want, got := 1, len(res.Authorities); want != got {
+		t.Fatalf("want %d authorities, got %d", want, got)
+	}
+	if _, ok := res.Authorities[0].Record.(*SOA); !ok {
+		t.Errorf("non SOA authority record: %+v", res.Authorities[0])
+	}
+}
+
+func TestZoneExpire(t *testing.T) {
+	t.Parallel()
+
+	zone := *localhostZone
+	zone.SOA = &SOA{
+		NS:     "dns.localhost.",
+		MBox:   "hostmaster.localhost.",
+		Expire: 7 * 24 * time.Hour,
+	}
+
+	srv := mustServer(&zone)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := edns.Expire{Empty: true}.Option()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "localhost.", Type: TypeSOA, Class: ClassIN},
+			},
+			Additionals: []Resource{
+				{
+					Name:   ".",
+					Class:  Class(maxPacketLen),
+					Record: &OPT{Options: []edns.Option{o}},
+				},
+			},
+		},
+	}
+
+	res, err := new(Client).Do(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, ok := res.Expire()
+	if !ok {
+		t.Fatal("want EXPIRE option")
+	}
+	if want, got := (edns.Expire{Expire: 7 * 24 * time.Hour}), exp; want != got {
+		t.Errorf("want expire %+v, got %+v", want, got)
+	}
+	if want, got := 1, len(res.Additionals); want != got {
+		t.Errorf("want %d additionals, got %d", want, got)
+	}
+}
@@ -0,0 +1,701 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errIncludeCycle    = errors.New("$INCLUDE cycle")
+	errMissingOwner    = errors.New("missing owner name")
+	errMissingType     = errors.New("missing record type")
+	errUnbalancedParen = errors.New("unbalanced parentheses")
+	errUnterminatedStr = errors.New("unterminated quoted string")
+	errRDATA           = errors.New("invalid RDATA")
+	errLabelTooLong    = errors.New("label longer than 63 bytes")
+	errZoneNameTooLong = errors.New("name longer than 255 bytes")
+)
+
+// ZoneError is an error parsing a zone file.
+type ZoneError struct {
+	File string // name of the zone file, if known
+	Line int    // line number of the entry
+
+	Err error
+}
+
+func (e *ZoneError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("dns: %s:%d: %s", e.File, e.Line, e.Err.Error())
+	}
+	return fmt.Sprintf("dns: line %d: %s", e.Line, e.Err.Error())
+}
+
+// ParseZone parses the resource records of a zone file in the text format of
+// RFC 1035, section 5. Relative names are relative to origin, which may be
+// changed by an $ORIGIN directive.
+//
+// The names of $INCLUDE directives are relative to the current directory.
+func ParseZone(r io.Reader, origin string) ([]Resource, error) {
+	p := &zoneParser{
+		origin:   origin,
+		includes: make(map[string]struct{}),
+	}
+
+	if err := p.parse(r); err != nil {
+		return nil, err
+	}
+	return p.rrs, nil
+}
+
+// ParseZoneFile parses the resource records of the zone file named filename.
+// The names of $INCLUDE directives are relative to the directory of the file.
+func ParseZoneFile(filename, origin string) ([]Resource, error) {
+	p := &zoneParser{
+		origin:   origin,
+		includes: make(map[string]struct{}),
+	}
+
+	if err := p.include(filename); err != nil {
+		return nil, err
+	}
+	return p.rrs, nil
+}
+
+type zoneParser struct {
+	origin string
+	ttl    time.Duration
+	owner  string
+
+	file string
+	line int
+
+	// includes is the set of files being parsed, for cycle detection.
+	includes map[string]struct{}
+
+	rrs []Resource
+}
+
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+func (p *zoneParser) include(filename string) error {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return p.errorf(err)
+	}
+	if _, ok := p.includes[path]; ok {
+		return p.errorf(errIncludeCycle)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return p.errorf(err)
+	}
+	defer f.Close()
+
+	p.includes[path] = struct{}{}
+	defer delete(p.includes, path)
+
+	p.file, p.line = filename, 0
+
+	return p.parse(f)
+}
+
+func (p *zoneParser) parse(r io.Reader) error {
+	var (
+		entry []zoneToken
+		blank bool
+		depth int
+		start int
+	)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		p.line++
+
+		line := sc.Text()
+		if depth == 0 {
+			entry, start = entry[:0], p.line
+			blank = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		}
+
+		var err error
+		if entry, depth, err = lexZoneLine(entry, depth, line); err != nil {
+			return p.errorf(err)
+		}
+
+		if depth > 0 || len(entry) == 0 {
+			continue
+		}
+
+		end := p.line
+		p.line = start
+		if err := p.entry(entry, blank); err != nil {
+			return err
+		}
+		p.line = end
+	}
+	if err := sc.Err(); err != nil {
+		return p.errorf(err)
+	}
+	if depth > 0 {
+		p.line = start
+		return p.errorf(errUnbalancedParen)
+	}
+	return nil
+}
+
+// lexZoneLine appends the tokens of line to tokens. The parentheses depth
+// is carried across lines.
+func lexZoneLine(tokens []zoneToken, depth int, line string) ([]zoneToken, int, error) {
+	for i := 0; i < len(line); {
+		switch c := line[i]; c {
+		case ' ', '\t', '\r':
+			i++
+		case ';':
+			return tokens, depth, nil
+		case '(':
+			depth, i = depth+1, i+1
+		case ')':
+			if depth == 0 {
+				return nil, 0, errUnbalancedParen
+			}
+			depth, i = depth-1, i+1
+		case '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return nil, 0, errUnterminatedStr
+			}
+
+			text, err := unescapeZoneString(line[i+1 : j])
+			if err != nil {
+				return nil, 0, err
+			}
+
+			tokens = append(tokens, zoneToken{text: text, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[j])); j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j > len(line) {
+				j = len(line)
+			}
+
+			tokens = append(tokens, zoneToken{text: line[i:j]})
+			i = j
+		}
+	}
+	return tokens, depth, nil
+}
+
+// unescapeZoneString decodes the \X and \DDD escapes of a character-string.
+func unescapeZoneString(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+
+		if i+3 < len(s) && isDigit(s[i+1]) {
+			n, err := strconv.Atoi(s[i+1 : i+4])
+			if err != nil || n > 255 {
+				return "", errRDATA
+			}
+			b = append(b, byte(n))
+			i += 3
+			continue
+		}
+		if i+1 < len(s) {
+			b = append(b, s[i+1])
+			i++
+		}
+	}
+	return string(b), nil
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func (p *zoneParser) entry(tokens []zoneToken, blank bool) error {
+	if !blank && strings.HasPrefix(tokens[0].text, "$") && !tokens[0].quoted {
+		return p.directive(tokens)
+	}
+
+	if !blank {
+		name, err := p.name(tokens[0].text)
+		if err != nil {
+			return p.errorf(err)
+		}
+		p.owner, tokens = name, tokens[1:]
+	}
+	if p.owner == "" {
+		return p.errorf(errMissingOwner)
+	}
+
+	res := Resource{
+		Name:  p.owner,
+		Class: ClassIN,
+		TTL:   p.ttl,
+	}
+
+	// The TTL and class are optional, and in either order.
+	for i := 0; i < 2 && len(tokens) > 0; i++ {
+		// neither a TTL, class, nor type is quoted or empty
+		if tokens[0].quoted || tokens[0].text == "" {
+			return p.errorf(errRDATA)
+		}
+		if class, ok := classesByName[strings.ToUpper(tokens[0].text)]; ok {
+			res.Class, tokens = class, tokens[1:]
+			continue
+		}
+		if isDigit(tokens[0].text[0]) {
+			ttl, err := parseZoneTTL(tokens[0].text)
+			if err != nil {
+				return p.errorf(err)
+			}
+			res.TTL, tokens = ttl, tokens[1:]
+		}
+	}
+	if len(tokens) == 0 {
+		return p.errorf(errMissingType)
+	}
+
+	typ, ok := typesByName[strings.ToUpper(tokens[0].text)]
+	if !ok {
+		return p.errorf(fmt.Errorf("unsupported record type %q", tokens[0].text))
+	}
+
+	rec, err := p.rdata(typ, tokens[1:])
+	if err != nil {
+		return p.errorf(err)
+	}
+	res.Record = rec
+
+	p.rrs = append(p.rrs, res)
+	return nil
+}
+
+func (p *zoneParser) directive(tokens []zoneToken) error {
+	switch strings.ToUpper(tokens[0].text) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return p.errorf(errors.New("invalid $ORIGIN"))
+		}
+
+		origin, err := p.name(tokens[1].text)
+		if err != nil {
+			return p.errorf(err)
+		}
+		p.origin = origin
+	case "$TTL":
+		if len(tokens) != 2 {
+			return p.errorf(errors.New("invalid $TTL"))
+		}
+
+		ttl, err := parseZoneTTL(tokens[1].text)
+		if err != nil {
+			return p.errorf(err)
+		}
+		p.ttl = ttl
+	case "$INCLUDE":
+		if len(tokens) < 2 || len(tokens) > 3 {
+			return p.errorf(errors.New("invalid $INCLUDE"))
+		}
+
+		origin := p.origin
+		if len(tokens) == 3 {
+			var err error
+			if origin, err = p.name(tokens[2].text); err != nil {
+				return p.errorf(err)
+			}
+		}
+
+		filename := tokens[1].text
+		if !filepath.IsAbs(filename) && p.file != "" {
+			filename = filepath.Join(filepath.Dir(p.file), filename)
+		}
+
+		// The included file does not change the origin, TTL, or owner
+		// name of the parent file.
+		child := &zoneParser{
+			origin:   origin,
+			ttl:      p.ttl,
+			includes: p.includes,
+
+			file: p.file,
+			line: p.line,
+
+			rrs: p.rrs,
+		}
+		if err := child.include(filename); err != nil {
+			return err
+		}
+		p.rrs = child.rrs
+	default:
+		return p.errorf(fmt.Errorf("unsupported directive %q", tokens[0].text))
+	}
+	return nil
+}
+
+// name returns the fully-qualified domain name for a name relative to the
+// origin. Names with labels or a length beyond the limits of RFC 1035,
+// section 2.3.4, are rejected, rather than failing to encode later.
+func (p *zoneParser) name(name string) (string, error) {
+	var fqdn string
+	switch {
+	case name == "@":
+		if p.origin == "" {
+			return "", errInvalidFQDN
+		}
+		fqdn = p.origin
+	case strings.HasSuffix(name, "."):
+		fqdn = name
+	case p.origin == "":
+		return "", errInvalidFQDN
+	case p.origin == ".":
+		fqdn = name + "."
+	default:
+		fqdn = name + "." + p.origin
+	}
+
+	switch err := checkName(fqdn, false); err {
+	case nil:
+		return fqdn, nil
+	case errSegTooLong:
+		return "", fmt.Errorf("name %q: %w", fqdn, errLabelTooLong)
+	case errNameTooLong:
+		return "", fmt.Errorf("name %q: %w", fqdn, errZoneNameTooLong)
+	default:
+		return "", fmt.Errorf("name %q: %w", fqdn, err)
+	}
+}
+
+func (p *zoneParser) rdata(typ Type, tokens []zoneToken) (Record, error) {
+	args := make([]string, len(tokens))
+	for i, tok := range tokens {
+		args[i] = tok.text
+	}
+
+	nargs := map[Type]int{
+		TypeA:     1,
+		TypeAAAA:  1,
+		TypeNS:    1,
+		TypeCNAME: 1,
+		TypePTR:   1,
+		TypeDNAME: 1,
+		TypeMX:    2,
+		TypeHINFO: 2,
+		TypeCAA:   3,
+		TypeSRV:   4,
+		TypeSOA:   7,
+	}
+	if n, ok := nargs[typ]; ok && len(args) != n {
+		return nil, errRDATA
+	}
+
+	switch typ {
+	case TypeA:
+		ip := net.ParseIP(args[0]).To4()
+		if ip == nil {
+			return nil, errRDATA
+		}
+		return &A{A: ip}, nil
+	case TypeAAAA:
+		ip := net.ParseIP(args[0])
+		if ip == nil || ip.To4() != nil {
+			return nil, errRDATA
+		}
+		return &AAAA{AAAA: ip}, nil
+	case TypeNS, TypeCNAME, TypePTR, TypeDNAME:
+		name, err := p.name(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case TypeNS:
+			return &NS{NS: name}, nil
+		case TypeCNAME:
+			return &CNAME{CNAME: name}, nil
+		case TypePTR:
+			return &PTR{PTR: name}, nil
+		default:
+			return &DNAME{DNAME: name}, nil
+		}
+	case TypeMX:
+		pref, err := parseUint16(args[0])
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.name(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &MX{Pref: pref, MX: name}, nil
+	case TypeHINFO:
+		return &HINFO{CPU: args[0], OS: args[1]}, nil
+	case TypeTXT:
+		if len(args) == 0 {
+			return nil, errRDATA
+		}
+		return &TXT{TXT: args}, nil
+	case TypeCAA:
+		flags, err := strconv.ParseUint(args[0], 10, 8)
+		if err != nil {
+			return nil, errRDATA
+		}
+		return &CAA{IssuerCritical: flags&0x80 > 0, Tag: args[1], Value: args[2]}, nil
+	case TypeSRV:
+		var vals [3]int
+		for i := range vals {
+			var err error
+			if vals[i], err = parseUint16(args[i]); err != nil {
+				return nil, err
+			}
+		}
+		name, err := p.name(args[3])
+		if err != nil {
+			return nil, err
+		}
+		return &SRV{Priority: vals[0], Weight: vals[1], Port: vals[2], Target: name}, nil
+	case TypeSOA:
+		ns, err := p.name(args[0])
+		if err != nil {
+			return nil, err
+		}
+		mbox, err := p.name(args[1])
+		if err != nil {
+			return nil, err
+		}
+		serial, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return nil, errRDATA
+		}
+
+		var ttls [4]time.Duration
+		for i := range ttls {
+			if ttls[i], err = parseZoneTTL(args[3+i]); err != nil {
+				return nil, err
+			}
+		}
+
+		return &SOA{
+			NS:      ns,
+			MBox:    mbox,
+			Serial:  int(serial),
+			Refresh: ttls[0],
+			Retry:   ttls[1],
+			Expire:  ttls[2],
+			MinTTL:  ttls[3],
+		}, nil
+	case TypeRRSIG:
+		if len(args) < 9 {
+			return nil, errRDATA
+		}
+
+		covered, err := parseZoneType(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var vals [2]uint64
+		for i := range vals {
+			if vals[i], err = strconv.ParseUint(args[1+i], 10, 8); err != nil {
+				return nil, errRDATA
+			}
+		}
+		ttl, err := strconv.ParseUint(args[3], 10, 32)
+		if err != nil {
+			return nil, errRDATA
+		}
+		var times [2]time.Time
+		for i := range times {
+			if times[i], err = parseSigTime(args[4+i]); err != nil {
+				return nil, err
+			}
+		}
+		tag, err := parseUint16(args[6])
+		if err != nil {
+			return nil, err
+		}
+		signer, err := p.name(args[7])
+		if err != nil {
+			return nil, err
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.Join(args[8:], ""))
+		if err != nil {
+			return nil, errRDATA
+		}
+
+		return &RRSIG{
+			TypeCovered: covered,
+			Algorithm:   uint8(vals[0]),
+			Labels:      uint8(vals[1]),
+			OriginalTTL: time.Duration(ttl) * time.Second,
+			Expiration:  times[0],
+			Inception:   times[1],
+			KeyTag:      uint16(tag),
+			SignerName:  signer,
+			Signature:   sig,
+		}, nil
+	case TypeDNSKEY:
+		if len(args) < 4 {
+			return nil, errRDATA
+		}
+
+		flags, err := parseUint16(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var vals [2]uint64
+		for i := range vals {
+			if vals[i], err = strconv.ParseUint(args[1+i], 10, 8); err != nil {
+				return nil, errRDATA
+			}
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.Join(args[3:], ""))
+		if err != nil {
+			return nil, errRDATA
+		}
+
+		return &DNSKEY{
+			Flags:     uint16(flags),
+			Protocol:  uint8(vals[0]),
+			Algorithm: uint8(vals[1]),
+			PublicKey: key,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported record type %d", typ)
+	}
+}
+
+func (p *zoneParser) errorf(err error) error {
+	if _, ok := err.(*ZoneError); ok {
+		return err
+	}
+	return &ZoneError{File: p.file, Line: p.line, Err: err}
+}
+
+// parseZoneTTL parses a TTL in seconds, or with the BIND unit suffixes
+// (e.g. "1h30m").
+func parseZoneTTL(s string) (time.Duration, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	var ttl, n time.Duration
+	for i := 0; i < len(s); i++ {
+		if isDigit(s[i]) {
+			n = 10*n + time.Duration(s[i]-'0')
+			continue
+		}
+		if i == 0 || !isDigit(s[i-1]) {
+			return 0, errRDATA
+		}
+
+		unit, ok := map[byte]time.Duration{
+			's': time.Second,
+			'm': time.Minute,
+			'h': time.Hour,
+			'd': 24 * time.Hour,
+			'w': 7 * 24 * time.Hour,
+		}[s[i]|0x20]
+		if !ok {
+			return 0, errRDATA
+		}
+		ttl, n = ttl+n*unit, 0
+	}
+	if len(s) == 0 || isDigit(s[len(s)-1]) {
+		return 0, errRDATA
+	}
+	return ttl, nil
+}
+
+// parseZoneType parses a type mnemonic, or the generic TYPEnnn name of RFC
+// 3597.
+func parseZoneType(s string) (Type, error) {
+	for typ, name := range typeNames {
+		if strings.EqualFold(s, name) {
+			return typ, nil
+		}
+	}
+	if len(s) > 4 && strings.EqualFold(s[:4], "TYPE") {
+		n, err := parseUint16(s[4:])
+		return Type(n), err
+	}
+	return 0, errRDATA
+}
+
+// parseSigTime parses a RRSIG signature time in the YYYYMMDDHHmmSS format,
+// or as seconds since the epoch, as described in RFC 4034, section 3.2.
+func parseSigTime(s string) (time.Time, error) {
+	if len(s) == 14 {
+		t, err := time.Parse("20060102150405", s)
+		if err != nil {
+			return time.Time{}, errRDATA
+		}
+		return t, nil
+	}
+
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return time.Time{}, errRDATA
+	}
+	return time.Unix(int64(n), 0).UTC(), nil
+}
+
+func parseUint16(s string) (int, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, errRDATA
+	}
+	return int(n), nil
+}
+
+// typesByName are the record types with RDATA parsed from zone files.
+var typesByName = map[string]Type{
+	"A":      TypeA,
+	"NS":     TypeNS,
+	"CNAME":  TypeCNAME,
+	"SOA":    TypeSOA,
+	"PTR":    TypePTR,
+	"HINFO":  TypeHINFO,
+	"MX":     TypeMX,
+	"TXT":    TypeTXT,
+	"AAAA":   TypeAAAA,
+	"SRV":    TypeSRV,
+	"DNAME":  TypeDNAME,
+	"RRSIG":  TypeRRSIG,
+	"DNSKEY": TypeDNSKEY,
+	"CAA":    TypeCAA,
+}
+
+var classesByName = map[string]Class{
+	"IN": ClassIN,
+	"CH": ClassCH,
+	"HS": ClassHS,
+}
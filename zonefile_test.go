@@ -0,0 +1,406 @@
+package dns
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseZoneFileInclude(t *testing.T) {
+	t.Parallel()
+
+	dir := mustTempDir(t)
+
+	mustWriteFile(t, filepath.Join(dir, "db.localhost"), `
+$TTL 1h
+@	IN	SOA	dns hostmaster 1234 1h 15m 1w 5m
+	IN	NS	dns
+dns	IN	A	10.42.0.53
+$INCLUDE frag/db.app app.localhost.
+www	IN	A	10.42.0.80 ; after the include
+`)
+	mustWriteFile(t, filepath.Join(dir, "frag", "db.app"), `
+@	IN	A	10.42.0.1
+1	300	IN	A	10.42.0.1
+	IN	AAAA	dead:beef::1
+$ORIGIN other.
+$TTL 5m
+x	IN	A	10.42.0.2
+`)
+
+	rrs, err := ParseZoneFile(filepath.Join(dir, "db.localhost"), "localhost.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Resource{
+		{
+			Name:  "localhost.",
+			Class: ClassIN,
+			TTL:   time.Hour,
+			Record: &SOA{
+				NS:      "dns.localhost.",
+				MBox:    "hostmaster.localhost.",
+				Serial:  1234,
+				Refresh: time.Hour,
+				Retry:   15 * time.Minute,
+				Expire:  7 * 24 * time.Hour,
+				MinTTL:  5 * time.Minute,
+			},
+		},
+		{Name: "localhost.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "dns.localhost."}},
+		{Name: "dns.localhost.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(10, 42, 0, 53).To4()}},
+		{Name: "app.localhost.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(10, 42, 0, 1).To4()}},
+		{Name: "1.app.localhost.", Class: ClassIN, TTL: 300 * time.Second, Record: &A{A: net.IPv4(10, 42, 0, 1).To4()}},
+		{Name: "1.app.localhost.", Class: ClassIN, TTL: time.Hour, Record: &AAAA{AAAA: net.ParseIP("dead:beef::1")}},
+		{Name: "x.other.", Class: ClassIN, TTL: 5 * time.Minute, Record: &A{A: net.IPv4(10, 42, 0, 2).To4()}},
+
+		// the $ORIGIN and $TTL of the included file do not leak
+		{Name: "www.localhost.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(10, 42, 0, 80).To4()}},
+	}
+
+	if want, got := len(want), len(rrs); want != got {
+		t.Fatalf("want %d records, got %d: %+v", want, got, rrs)
+	}
+	for i := range want {
+		if want, got := want[i], rrs[i]; !reflect.DeepEqual(want, got) {
+			t.Errorf("want record %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestParseZoneFileIncludeCycle(t *testing.T) {
+	t.Parallel()
+
+	dir := mustTempDir(t)
+
+	mustWriteFile(t, filepath.Join(dir, "a"), "$INCLUDE b\n")
+	mustWriteFile(t, filepath.Join(dir, "b"), "foo IN A 127.0.0.1\n$INCLUDE a\n")
+
+	_, err := ParseZoneFile(filepath.Join(dir, "a"), "localhost.")
+
+	zerr, ok := err.(*ZoneError)
+	if !ok {
+		t.Fatalf("want zone error, got %v", err)
+	}
+	if want, got := errIncludeCycle, zerr.Err; want != got {
+		t.Errorf("want error %q, got %q", want, got)
+	}
+	if want, got := filepath.Join(dir, "b"), zerr.File; want != got {
+		t.Errorf("want error in file %q, got %q", want, got)
+	}
+	if want, got := 2, zerr.Line; want != got {
+		t.Errorf("want error on line %d, got %d", want, got)
+	}
+}
+
+func TestParseZone(t *testing.T) {
+	t.Parallel()
+
+	rrs, err := ParseZone(strings.NewReader(`
+$ORIGIN localhost.
+txt	60	TXT	"hello \"world\"" "\065\066"
+`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Resource{
+		Name:   "txt.localhost.",
+		Class:  ClassIN,
+		TTL:    time.Minute,
+		Record: &TXT{TXT: []string{`hello "world"`, "AB"}},
+	}
+
+	if want, got := 1, len(rrs); want != got {
+		t.Fatalf("want %d records, got %d", want, got)
+	}
+	if got := rrs[0]; !reflect.DeepEqual(want, got) {
+		t.Errorf("want record %+v, got %+v", want, got)
+	}
+}
+
+func TestParseZoneRecords(t *testing.T) {
+	t.Parallel()
+
+	rrs, err := ParseZone(strings.NewReader(`
+$TTL 1h
+@	IN	SOA	ns1 hostmaster.example.com. (
+		2021010101 ; serial
+		2h         ; refresh
+		15m        ; retry
+		1w         ; expire
+		5m )       ; minimum
+	IN	NS	ns1
+	IN	NS	ns.example.net.
+	IN	MX	10 mail
+ns1	IN	A	192.0.2.53
+mail	300	IN	AAAA	2001:db8::25
+www	IN	CNAME	@
+`), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Resource{
+		{
+			Name:  "example.com.",
+			Class: ClassIN,
+			TTL:   time.Hour,
+			Record: &SOA{
+				NS:      "ns1.example.com.",
+				MBox:    "hostmaster.example.com.",
+				Serial:  2021010101,
+				Refresh: 2 * time.Hour,
+				Retry:   15 * time.Minute,
+				Expire:  7 * 24 * time.Hour,
+				MinTTL:  5 * time.Minute,
+			},
+		},
+		{Name: "example.com.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "ns1.example.com."}},
+		{Name: "example.com.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "ns.example.net."}},
+		{Name: "example.com.", Class: ClassIN, TTL: time.Hour, Record: &MX{Pref: 10, MX: "mail.example.com."}},
+		{Name: "ns1.example.com.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 53).To4()}},
+		{Name: "mail.example.com.", Class: ClassIN, TTL: 5 * time.Minute, Record: &AAAA{AAAA: net.ParseIP("2001:db8::25")}},
+		{Name: "www.example.com.", Class: ClassIN, TTL: time.Hour, Record: &CNAME{CNAME: "example.com."}},
+	}
+
+	if want, got := len(want), len(rrs); want != got {
+		t.Fatalf("want %d records, got %d", want, got)
+	}
+	for i := range want {
+		if want, got := want[i], rrs[i]; !reflect.DeepEqual(want, got) {
+			t.Errorf("want record %d %+v, got %+v", i, want, got)
+		}
+	}
+}
+
+func mustTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func mustWriteFile(t *testing.T, filename, data string) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseZoneNameLimits(t *testing.T) {
+	t.Parallel()
+
+	label := strings.Repeat("a", 70)
+	long := strings.Repeat(strings.Repeat("b", 60)+".", 5)
+
+	tests := []struct {
+		name string
+
+		zone string
+
+		line int
+		err  error
+	}{
+		{
+			name: "owner-label",
+
+			zone: "$TTL 1h\nwww IN A 127.0.0.1\n" + label + " IN A 127.0.0.2\n",
+
+			line: 3,
+			err:  errLabelTooLong,
+		},
+		{
+			name: "rdata-label",
+
+			zone: "www IN CNAME " + label + ".example.com.\n",
+
+			line: 1,
+			err:  errLabelTooLong,
+		},
+		{
+			name: "owner-name",
+
+			zone: "www IN A 127.0.0.1\n\n" + long + " IN A 127.0.0.2\n",
+
+			line: 3,
+			err:  errZoneNameTooLong,
+		},
+		{
+			name: "origin",
+
+			zone: "$ORIGIN " + label + ".\n",
+
+			line: 1,
+			err:  errLabelTooLong,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseZone(strings.NewReader(test.zone), "example.com.")
+
+			zerr, ok := err.(*ZoneError)
+			if !ok {
+				t.Fatalf("want zone error, got %v", err)
+			}
+			if !errors.Is(zerr.Err, test.err) {
+				t.Errorf("want error %q, got %q", test.err, zerr.Err)
+			}
+			if want, got := test.line, zerr.Line; want != got {
+				t.Errorf("want error on line %d, got %d", want, got)
+			}
+			if want, got := "line "+strconv.Itoa(test.line)+":", err.Error(); !strings.Contains(got, want) {
+				t.Errorf("want error message with %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestParseZoneQuotedFields(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		zone string
+
+		line int
+	}{
+		{name: "owner", zone: "www \"\" A 1.2.3.4\n", line: 1},
+		{name: "continuation", zone: "www A 1.2.3.4\n   \"\" TXT x\n", line: 2},
+		{name: "ttl", zone: "www \"60\" A 1.2.3.4\n", line: 1},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseZone(strings.NewReader(test.zone), "example.com.")
+
+			zerr, ok := err.(*ZoneError)
+			if !ok {
+				t.Fatalf("want zone error, got %v", err)
+			}
+			if want, got := errRDATA, zerr.Err; want != got {
+				t.Errorf("want error %q, got %q", want, got)
+			}
+			if want, got := test.line, zerr.Line; want != got {
+				t.Errorf("want error on line %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestParseZoneUnsupportedType(t *testing.T) {
+	t.Parallel()
+
+	for _, typ := range []string{"OPT", "SMIMEA", "OPENPGPKEY", "CSYNC", "URI", "WKS"} {
+		_, err := ParseZone(strings.NewReader("www IN "+typ+" 0\n"), "example.com.")
+
+		zerr, ok := err.(*ZoneError)
+		if !ok {
+			t.Fatalf("%s: want zone error, got %v", typ, err)
+		}
+		if want, got := `unsupported record type "`+typ+`"`, zerr.Err.Error(); want != got {
+			t.Errorf("want error %q, got %q", want, got)
+		}
+	}
+}
+
+func TestParseZoneDNSSEC(t *testing.T) {
+	t.Parallel()
+
+	rrs, err := ParseZone(strings.NewReader(`
+$TTL 1h
+@	DNSKEY	257 3 13 ( AQID
+		BAU= )
+www	RRSIG	A 13 3 3600 20210201000000 20210101000000 12345 @ (
+		AQIDBAU= )
+www	RRSIG	TYPE65280 13 3 3600 1612137600 1609459200 12345 example.com. AQIDBAU=
+`), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := func(covered Type) *RRSIG {
+		return &RRSIG{
+			TypeCovered: covered,
+			Algorithm:   13,
+			Labels:      3,
+			OriginalTTL: time.Hour,
+			Expiration:  time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+			Inception:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			KeyTag:      12345,
+			SignerName:  "example.com.",
+			Signature:   []byte{1, 2, 3, 4, 5},
+		}
+	}
+
+	want := []Resource{
+		{
+			Name:  "example.com.",
+			Class: ClassIN,
+			TTL:   time.Hour,
+			Record: &DNSKEY{
+				Flags:     DNSKEYZone | DNSKEYSecureEntryPoint,
+				Protocol:  3,
+				Algorithm: 13,
+				PublicKey: []byte{1, 2, 3, 4, 5},
+			},
+		},
+		{Name: "www.example.com.", Class: ClassIN, TTL: time.Hour, Record: sig(TypeA)},
+		{Name: "www.example.com.", Class: ClassIN, TTL: time.Hour, Record: sig(65280)},
+	}
+
+	if want, got := len(want), len(rrs); want != got {
+		t.Fatalf("want %d records, got %d", want, got)
+	}
+	for i := range want {
+		if want, got := want[i], rrs[i]; !reflect.DeepEqual(want, got) {
+			t.Errorf("want record %d %+v, got %+v", i, want, got)
+		}
+
+		// the presentation format of the record parses back to the record
+		line := "@ IN " + typeName(rrs[i].Record.Type()) + " " + rrs[i].Record.RData()
+		rr, err := ParseZone(strings.NewReader(line), "example.com.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := want[i].Record, rr[0].Record; !reflect.DeepEqual(want, got) {
+			t.Errorf("want parsed RDATA %+v, got %+v", want, got)
+		}
+	}
+
+	for _, zone := range []string{
+		"@ DNSKEY 257 3 13\n",
+		"@ DNSKEY 257 3 13 AQ=ID\n",
+		"@ DNSKEY 65536 3 13 AQID\n",
+		"www RRSIG A 13 3 3600 20210201000000 20210101000000 12345 @\n",
+		"www RRSIG BOGUS 13 3 3600 20210201000000 20210101000000 12345 @ AQID\n",
+		"www RRSIG A 13 3 3600 20211301000000 20210101000000 12345 @ AQID\n",
+	} {
+		_, err := ParseZone(strings.NewReader(zone), "example.com.")
+		if zerr, ok := err.(*ZoneError); !ok || zerr.Err != errRDATA {
+			t.Errorf("%q: want error %q, got %v", zone, errRDATA, err)
+		}
+	}
+}